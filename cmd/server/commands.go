@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"goatsync/internal/config"
+	"goatsync/internal/database"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/service"
+	"goatsync/internal/storage"
+
+	"gorm.io/gorm"
+)
+
+// command is a one-shot admin command, run as `goatsync <name> [flags]`
+type command struct {
+	summary string
+	run     func(ctx context.Context, env *commandEnv, args []string) error
+}
+
+// commandEnv holds the dependencies shared by admin commands
+type commandEnv struct {
+	cfg   *config.Config
+	db    *gorm.DB
+	store storage.ChunkStore
+}
+
+var commands = map[string]command{
+	"gc-chunks": {
+		summary: "Remove unreferenced chunks and orphaned chunk files",
+		run:     runGCChunks,
+	},
+	"quota": {
+		summary: "Show or override a user's quotas",
+		run:     runQuota,
+	},
+	"prune-revisions": {
+		summary: "Remove revisions the retention policy no longer keeps",
+		run:     runPruneRevisions,
+	},
+	"retention": {
+		summary: "Show or override a collection's revision retention",
+		run:     runRetention,
+	},
+	"purge-collections": {
+		summary: "Purge deleted collections whose grace period has passed",
+		run:     runPurgeCollections,
+	},
+	"transfer-collection": {
+		summary: "Make an admin member the owner of a collection",
+		run:     runTransferCollection,
+	},
+}
+
+// runCommand runs the named admin command and returns the process exit code
+func runCommand(cfg *config.Config, name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok {
+		printCommands()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, err := database.Connect(cfg.DatabaseURL)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return 1
+	}
+	defer func() { _ = database.Close() }()
+
+	store, err := storage.NewChunkStore(cfg)
+	if err != nil {
+		log.Printf("ERROR: Failed to initialize chunk storage: %v", err)
+		return 1
+	}
+
+	env := &commandEnv{cfg: cfg, db: db, store: store}
+	if err := cmd.run(ctx, env, args); err != nil {
+		log.Printf("ERROR: %s: %v", name, err)
+		return 1
+	}
+	return 0
+}
+
+// printCommands prints the list of admin commands to stderr
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: goatsync [command] [flags]")
+	fmt.Fprintln(os.Stderr, "\nWithout a command, the server is started. Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, commands[name].summary)
+	}
+}
+
+// runGCChunks implements `goatsync gc-chunks`
+func runGCChunks(ctx context.Context, env *commandEnv, args []string) error {
+	flags := flag.NewFlagSet("gc-chunks", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would be removed without deleting anything")
+	grace := flags.Duration("grace", env.cfg.ChunkGCGracePeriod, "minimum age of anything removed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	collector := service.NewChunkCollector(repository.NewChunkRepository(env.db), env.store)
+	report, err := collector.Run(ctx, service.ChunkGCOptions{
+		DryRun:      *dryRun,
+		GracePeriod: *grace,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range report.OrphanFiles {
+		fmt.Println(key)
+	}
+	log.Println(report)
+	return nil
+}
+
+// runQuota implements `goatsync quota [flags] <username>`
+func runQuota(ctx context.Context, env *commandEnv, args []string) error {
+	flags := flag.NewFlagSet("quota", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: goatsync quota [flags] <username>")
+		fmt.Fprintln(flags.Output(), "\nLimits take a number (0 = unlimited) or \"default\" to remove the override.")
+		flags.PrintDefaults()
+	}
+	limits := map[model.QuotaResource]*string{
+		model.QuotaChunkBytes:  flags.String("chunk-bytes", "", "set the chunk storage limit in bytes"),
+		model.QuotaCollections: flags.String("collections", "", "set the collection limit"),
+		model.QuotaItems:       flags.String("items", "", "set the item limit"),
+	}
+	recalculate := flags.Bool("recalculate", false, "recount the user's usage")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected a username")
+	}
+
+	user, err := repository.NewUserRepository(env.db).GetByUsername(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %q not found", flags.Arg(0))
+	}
+
+	quotaRepo := repository.NewQuotaRepository(env.db)
+	quota, err := quotaRepo.Get(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for resource, value := range limits {
+		if *value == "" {
+			continue
+		}
+		limit, err := parseQuotaLimit(*value)
+		if err != nil {
+			return fmt.Errorf("-%s: %w", strings.ReplaceAll(string(resource), "_", "-"), err)
+		}
+		switch resource {
+		case model.QuotaChunkBytes:
+			quota.MaxChunkBytes = limit
+		case model.QuotaCollections:
+			quota.MaxCollections = limit
+		case model.QuotaItems:
+			quota.MaxItems = limit
+		}
+		changed = true
+	}
+	if changed {
+		if err := quotaRepo.SetLimits(ctx, quota); err != nil {
+			return err
+		}
+	}
+
+	if *recalculate {
+		if quota, err = quotaRepo.Recalculate(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	quotaService := service.NewQuotaService(quotaRepo, env.cfg)
+	for _, resource := range []model.QuotaResource{model.QuotaChunkBytes, model.QuotaCollections, model.QuotaItems} {
+		limit := "unlimited"
+		if l := quotaService.Limit(quota, resource); l > 0 {
+			limit = strconv.FormatInt(l, 10)
+		}
+		source := "default"
+		if quota.Limit(resource) != nil {
+			source = "override"
+		}
+		fmt.Printf("%-12s %d of %s (%s)\n", resource, quota.Used(resource), limit, source)
+	}
+	return nil
+}
+
+// parseQuotaLimit parses a quota limit flag; "default" removes the override
+func parseQuotaLimit(value string) (*int64, error) {
+	if value == "default" {
+		return nil, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		return nil, fmt.Errorf("invalid limit %q", value)
+	}
+	return &limit, nil
+}
+
+// newRevisionPruner builds a revision pruner from the command environment
+func newRevisionPruner(env *commandEnv) *service.RevisionPruner {
+	collectionRepo := repository.NewCollectionRepository(env.db)
+	quotaService := service.NewQuotaService(repository.NewQuotaRepository(env.db), env.cfg)
+	chunkService := service.NewChunkService(repository.NewChunkRepository(env.db), collectionRepo,
+		repository.NewMemberRepository(env.db), repository.NewTransactor(env.db), env.store, quotaService)
+	return service.NewRevisionPruner(repository.NewRevisionRepository(env.db),
+		repository.NewRetentionRepository(env.db), chunkService, env.cfg)
+}
+
+// runPruneRevisions implements `goatsync prune-revisions`
+func runPruneRevisions(ctx context.Context, env *commandEnv, args []string) error {
+	flags := flag.NewFlagSet("prune-revisions", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "count what would be removed without deleting anything")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := newRevisionPruner(env).Run(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+	log.Println(report)
+	return nil
+}
+
+// runRetention implements `goatsync retention [flags] <collection-uid>`
+func runRetention(ctx context.Context, env *commandEnv, args []string) error {
+	flags := flag.NewFlagSet("retention", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: goatsync retention [flags] <collection-uid>")
+		fmt.Fprintln(flags.Output(), "\nRules take a number (0 = disabled) or \"default\" to remove the override.")
+		fmt.Fprintln(flags.Output(), "Disabling both rules keeps the collection's full history.")
+		flags.PrintDefaults()
+	}
+	keepRevisions := flags.String("keep-revisions", "", "set the number of newest revisions kept per item")
+	keepDays := flags.String("keep-days", "", "set the age in days below which revisions are kept")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected a collection UID")
+	}
+
+	col, err := repository.NewCollectionRepository(env.db).GetByUID(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if col == nil {
+		return fmt.Errorf("collection %q not found", flags.Arg(0))
+	}
+
+	retentionRepo := repository.NewRetentionRepository(env.db)
+	retention, err := retentionRepo.Get(ctx, col.ID)
+	if err != nil {
+		return err
+	}
+	if retention == nil {
+		retention = &model.CollectionRetention{CollectionID: col.ID}
+	}
+
+	changed := false
+	for _, rule := range []struct {
+		name  string
+		value string
+		field **int
+	}{
+		{"keep-revisions", *keepRevisions, &retention.KeepRevisions},
+		{"keep-days", *keepDays, &retention.KeepDays},
+	} {
+		if rule.value == "" {
+			continue
+		}
+		value, err := parseRetentionRule(rule.value)
+		if err != nil {
+			return fmt.Errorf("-%s: %w", rule.name, err)
+		}
+		*rule.field = value
+		changed = true
+	}
+	if changed {
+		if err := retentionRepo.Set(ctx, retention); err != nil {
+			return err
+		}
+	}
+
+	policy := newRevisionPruner(env).Policy(retention)
+	for _, rule := range []struct {
+		name     string
+		value    int
+		override *int
+	}{
+		{"revisions", policy.KeepRevisions, retention.KeepRevisions},
+		{"days", policy.KeepDays, retention.KeepDays},
+	} {
+		value := "off"
+		if rule.value > 0 {
+			value = strconv.Itoa(rule.value)
+		}
+		source := "default"
+		if rule.override != nil {
+			source = "override"
+		}
+		fmt.Printf("%-12s %s (%s)\n", rule.name, value, source)
+	}
+	if policy.KeepsAll() {
+		fmt.Println("The full history is kept.")
+	}
+	return nil
+}
+
+// parseRetentionRule parses a retention rule flag; "default" removes the override
+func parseRetentionRule(value string) (*int, error) {
+	if value == "default" {
+		return nil, nil
+	}
+	rule, err := strconv.Atoi(value)
+	if err != nil || rule < 0 {
+		return nil, fmt.Errorf("invalid value %q", value)
+	}
+	return &rule, nil
+}
+
+// runPurgeCollections implements `goatsync purge-collections`
+func runPurgeCollections(ctx context.Context, env *commandEnv, args []string) error {
+	flags := flag.NewFlagSet("purge-collections", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list what would be purged without deleting anything")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	collectionRepo := repository.NewCollectionRepository(env.db)
+	quotaService := service.NewQuotaService(repository.NewQuotaRepository(env.db), env.cfg)
+	chunkService := service.NewChunkService(repository.NewChunkRepository(env.db), collectionRepo,
+		repository.NewMemberRepository(env.db), repository.NewTransactor(env.db), env.store, quotaService)
+	purger := service.NewCollectionPurger(collectionRepo, repository.NewRevisionRepository(env.db),
+		repository.NewTransactor(env.db), chunkService, quotaService, env.cfg)
+
+	report, err := purger.Run(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, uid := range report.Collections {
+		fmt.Println(uid)
+	}
+	log.Println(report)
+	return nil
+}
+
+// runTransferCollection implements `goatsync transfer-collection <collection-uid> <username>`
+func runTransferCollection(ctx context.Context, env *commandEnv, args []string) error {
+	flags := flag.NewFlagSet("transfer-collection", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: goatsync transfer-collection <collection-uid> <username>")
+		fmt.Fprintln(flags.Output(), "\nThe new owner must already be an admin of the collection.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("expected a collection UID and a username")
+	}
+
+	collectionRepo := repository.NewCollectionRepository(env.db)
+	memberRepo := repository.NewMemberRepository(env.db)
+	quotaService := service.NewQuotaService(repository.NewQuotaRepository(env.db), env.cfg)
+	chunkService := service.NewChunkService(repository.NewChunkRepository(env.db), collectionRepo,
+		memberRepo, repository.NewTransactor(env.db), env.store, quotaService)
+	collectionService := service.NewCollectionService(collectionRepo, repository.NewItemRepository(env.db),
+		repository.NewRevisionRepository(env.db), memberRepo, repository.NewCollectionTypeRepository(env.db),
+		repository.NewInvitationRepository(env.db), repository.NewTransactor(env.db), chunkService, quotaService, env.cfg)
+
+	if err := collectionService.TransferOwnership(ctx, flags.Arg(0), flags.Arg(1)); err != nil {
+		return err
+	}
+	log.Printf("collection %s now belongs to %s", flags.Arg(0), flags.Arg(1))
+	return nil
+}
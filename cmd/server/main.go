@@ -13,6 +13,7 @@ import (
 	"goatsync/internal/config"
 	"goatsync/internal/database"
 	"goatsync/internal/handler"
+	"goatsync/internal/jobs"
 	"goatsync/internal/model"
 	redisclient "goatsync/internal/redis"
 	"goatsync/internal/repository"
@@ -35,6 +36,11 @@ const banner = `
 `
 
 func main() {
+	// Admin commands (e.g. `goatsync gc-chunks`) run once and exit
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(config.Load(), os.Args[1], os.Args[2:]))
+	}
+
 	fmt.Print(banner)
 
 	// 1. Load configuration
@@ -56,6 +62,23 @@ func main() {
 			log.Printf("WARNING: Failed to connect to database: %v", err)
 			log.Println("Running in memory-only mode (data will not persist)")
 		} else {
+			// Chunk files used to be unique per chunk; content-addressed chunks share them
+			if err := database.DropIndex(db, &model.CollectionItemChunk{}, "idx_django_collectionitemchunk_chunk_file"); err != nil {
+				log.Fatalf("Failed to run migrations: %v", err)
+			}
+
+			// Invitations are unique per invitee and inviting member; older
+			// servers could store duplicates, of which the newest is kept
+			if err := database.DeleteDuplicates(db, &model.CollectionInvitation{}, "user_id", "from_member_id"); err != nil {
+				log.Fatalf("Failed to run migrations: %v", err)
+			}
+
+			// An item has at most one current revision; older servers could
+			// leave several, of which the newest stays current
+			if err := database.ClearDuplicates(db, &model.CollectionItemRevision{}, "current", "item_id"); err != nil {
+				log.Fatalf("Failed to run migrations: %v", err)
+			}
+
 			// Run auto-migrations (FK constraints disabled in GORM config to handle circular deps)
 			if err := database.AutoMigrate(db,
 				&model.Stoken{},
@@ -67,10 +90,14 @@ func main() {
 				&model.CollectionItem{},
 				&model.CollectionItemRevision{},
 				&model.CollectionItemChunk{},
+				&model.ChunkBlob{},
 				&model.RevisionChunkRelation{},
 				&model.CollectionMember{},
 				&model.CollectionMemberRemoved{},
 				&model.CollectionInvitation{},
+				&model.ChunkUpload{},
+				&model.UserQuota{},
+				&model.CollectionRetention{},
 			); err != nil {
 				log.Fatalf("Failed to run migrations: %v", err)
 			}
@@ -85,8 +112,12 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 5. Initialize file storage
-	fileStorage := storage.NewFileStorage(cfg.ChunkStoragePath)
+	// 5. Initialize chunk storage
+	chunkStore, err := storage.NewChunkStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize chunk storage: %v", err)
+	}
+	log.Printf("Chunk storage initialized (backend: %s)", cfg.ChunkStorageBackend)
 
 	// 6. Initialize Redis (optional)
 	var redis *redisclient.Client
@@ -106,18 +137,29 @@ func main() {
 	tokenRepo := repository.NewTokenRepository(db)
 	collectionRepo := repository.NewCollectionRepository(db)
 	itemRepo := repository.NewItemRepository(db)
+	revisionRepo := repository.NewRevisionRepository(db)
 	memberRepo := repository.NewMemberRepository(db)
+	collectionTypeRepo := repository.NewCollectionTypeRepository(db)
 	invitationRepo := repository.NewInvitationRepository(db)
 	chunkRepo := repository.NewChunkRepository(db)
+	quotaRepo := repository.NewQuotaRepository(db)
+	uploadRepo := repository.NewChunkUploadRepository(db)
+	retentionRepo := repository.NewRetentionRepository(db)
+	transactor := repository.NewTransactor(db)
 	log.Println("Repositories initialized")
 
 	// 8. Initialize services
 	authService := service.NewAuthService(userRepo, tokenRepo, cfg)
-	collectionService := service.NewCollectionService(collectionRepo, cfg)
-	itemService := service.NewItemService(itemRepo, nil, collectionRepo, memberRepo)
-	memberService := service.NewMemberService(memberRepo, collectionRepo)
-	invitationService := service.NewInvitationService(invitationRepo, memberRepo, userRepo)
-	chunkService := service.NewChunkService(chunkRepo, collectionRepo, memberRepo, fileStorage)
+	quotaService := service.NewQuotaService(quotaRepo, cfg)
+	chunkService := service.NewChunkService(chunkRepo, collectionRepo, memberRepo, transactor, chunkStore, quotaService)
+	collectionService := service.NewCollectionService(collectionRepo, itemRepo, revisionRepo, memberRepo, collectionTypeRepo, invitationRepo, transactor, chunkService, quotaService, cfg)
+	itemService := service.NewItemService(itemRepo, revisionRepo, collectionRepo, memberRepo, transactor, chunkService, quotaService, cfg)
+	memberService := service.NewMemberService(memberRepo, collectionRepo, invitationRepo, transactor)
+	invitationService := service.NewInvitationService(invitationRepo, memberRepo, userRepo, collectionRepo, transactor, cfg)
+	uploadService := service.NewChunkUploadService(uploadRepo, chunkRepo, collectionRepo, memberRepo, transactor, chunkService, quotaService, chunkStore, cfg)
+	chunkCollector := service.NewChunkCollector(chunkRepo, chunkStore)
+	revisionPruner := service.NewRevisionPruner(revisionRepo, retentionRepo, chunkService, cfg)
+	collectionPurger := service.NewCollectionPurger(collectionRepo, revisionRepo, transactor, chunkService, quotaService, cfg)
 	log.Println("Services initialized")
 
 	// 9. Initialize handlers
@@ -126,7 +168,7 @@ func main() {
 	itemHandler := handler.NewItemHandler(itemService)
 	memberHandler := handler.NewMemberHandler(memberService)
 	invitationHandler := handler.NewInvitationHandler(invitationService)
-	chunkHandler := handler.NewChunkHandler(chunkService)
+	chunkHandler := handler.NewChunkHandler(chunkService, uploadService)
 	websocketHandler := handler.NewWebSocketHandler(redis)
 	healthHandler := handler.NewHealthHandler(db)
 	testHandler := handler.NewTestHandler(db, cfg.Debug)
@@ -147,7 +189,38 @@ func main() {
 		testHandler,
 	)
 
-	// 11. Setup graceful shutdown
+	// 11. Start background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	jobs.Start(jobsCtx,
+		jobs.Job{
+			Name:     "chunk-gc",
+			Interval: cfg.ChunkGCInterval,
+			Run:      chunkCollector.RunJob(cfg.ChunkGCGracePeriod),
+		},
+		jobs.Job{
+			Name:     "revision-prune",
+			Interval: cfg.RevisionPruneInterval,
+			Run:      revisionPruner.RunJob(),
+		},
+		jobs.Job{
+			Name:     "collection-purge",
+			Interval: cfg.CollectionPurgeInterval,
+			Run:      collectionPurger.RunJob(),
+		},
+		jobs.Job{
+			Name:     "chunk-upload-cleanup",
+			Interval: time.Hour,
+			Run:      uploadService.CleanupJob(),
+		},
+		jobs.Job{
+			Name:     "invitation-cleanup",
+			Interval: time.Hour,
+			Run:      invitationService.CleanupJob(),
+		},
+	)
+
+	// 12. Setup graceful shutdown
 	httpServer := &http.Server{
 		Addr:    ":" + cfg.Port,
 		Handler: srv.Engine(),
@@ -166,6 +239,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
+	stopJobs()
 
 	// Give outstanding requests 30 seconds to complete
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Config holds all configuration for the GoatSync server.
@@ -23,7 +24,45 @@ type Config struct {
 	ChallengeValidSeconds int // How long login challenges are valid (default: 300)
 
 	// Storage
-	ChunkStoragePath string // Root directory for encrypted chunk files
+	ChunkStorageBackend string // Chunk store backend: "filesystem" (default) or "s3"
+	ChunkStoragePath    string // Root directory for encrypted chunk files
+
+	// Resumable chunk uploads
+	ChunkUploadExpiry time.Duration // How long an upload is kept without receiving data (default: 24h)
+
+	// S3-compatible chunk storage (used when ChunkStorageBackend is "s3")
+	S3Endpoint  string // host[:port] of the S3 API
+	S3Region    string // Bucket region
+	S3Bucket    string // Bucket holding the chunks
+	S3Prefix    string // Optional key prefix inside the bucket
+	S3AccessKey string
+	S3SecretKey string
+	S3UseSSL    bool // Connect over HTTPS (default: true)
+	S3PathStyle bool // Force path-style bucket addressing
+
+	// Chunk garbage collection
+	ChunkGCInterval    time.Duration // How often the background collector runs (0 disables it, default: 24h)
+	ChunkGCGracePeriod time.Duration // Minimum age of unreferenced chunks before they're collected (default: 24h)
+
+	// Item revision history
+	RevisionListMaxLimit int // Largest page of revisions returned at once (default: 1000)
+
+	// Revision retention, overridable per collection in the database (0 disables a rule)
+	RevisionKeepCount     int           // Newest revisions kept per item, including the current one
+	RevisionKeepDays      int           // Revisions younger than this many days are kept
+	RevisionPruneInterval time.Duration // How often the pruning job runs (0 disables it, default: 24h)
+
+	// Deleted collections
+	CollectionPurgeGracePeriod time.Duration // How long a deleted collection is kept before it's purged (default: 720h)
+	CollectionPurgeInterval    time.Duration // How often the purge job runs (0 disables it, default: 24h)
+
+	// Collection invitations
+	InvitationExpiry time.Duration // How long an invitation stays pending (0 = forever, default: 720h)
+
+	// Default per-user quotas, overridable per user in the database (0 = unlimited)
+	QuotaChunkBytes  int64 // Total size of chunks in a user's collections
+	QuotaCollections int64 // Number of collections a user owns
+	QuotaItems       int64 // Number of items in a user's collections
 
 	// Database
 	DatabaseURL string // PostgreSQL connection string
@@ -62,7 +101,45 @@ func Load() *Config {
 		ChallengeValidSeconds: getEnvInt("CHALLENGE_VALID_SECONDS", 300),
 
 		// Storage
-		ChunkStoragePath: getEnv("CHUNK_STORAGE_PATH", "./data/chunks"),
+		ChunkStorageBackend: getEnv("CHUNK_STORAGE_BACKEND", "filesystem"),
+		ChunkStoragePath:    getEnv("CHUNK_STORAGE_PATH", "./data/chunks"),
+
+		// Resumable chunk uploads
+		ChunkUploadExpiry: getEnvDuration("CHUNK_UPLOAD_EXPIRY", 24*time.Hour),
+
+		// S3-compatible chunk storage
+		S3Endpoint:  getEnv("S3_ENDPOINT", ""),
+		S3Region:    getEnv("S3_REGION", ""),
+		S3Bucket:    getEnv("S3_BUCKET", ""),
+		S3Prefix:    getEnv("S3_PREFIX", ""),
+		S3AccessKey: getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey: getEnv("S3_SECRET_KEY", ""),
+		S3UseSSL:    getEnvBool("S3_USE_SSL", true),
+		S3PathStyle: getEnvBool("S3_PATH_STYLE", false),
+
+		// Chunk garbage collection
+		ChunkGCInterval:    getEnvDuration("CHUNK_GC_INTERVAL", 24*time.Hour),
+		ChunkGCGracePeriod: getEnvDuration("CHUNK_GC_GRACE_PERIOD", 24*time.Hour),
+
+		// Item revision history
+		RevisionListMaxLimit: getEnvInt("REVISION_LIST_MAX_LIMIT", 1000),
+
+		// Revision retention
+		RevisionKeepCount:     getEnvInt("REVISION_KEEP_COUNT", 0),
+		RevisionKeepDays:      getEnvInt("REVISION_KEEP_DAYS", 0),
+		RevisionPruneInterval: getEnvDuration("REVISION_PRUNE_INTERVAL", 24*time.Hour),
+
+		// Deleted collections
+		CollectionPurgeGracePeriod: getEnvDuration("COLLECTION_PURGE_GRACE_PERIOD", 30*24*time.Hour),
+		CollectionPurgeInterval:    getEnvDuration("COLLECTION_PURGE_INTERVAL", 24*time.Hour),
+
+		// Collection invitations
+		InvitationExpiry: getEnvDuration("INVITATION_EXPIRY", 30*24*time.Hour),
+
+		// Quotas
+		QuotaChunkBytes:  getEnvInt64("QUOTA_CHUNK_BYTES", 0),
+		QuotaCollections: getEnvInt64("QUOTA_COLLECTIONS", 0),
+		QuotaItems:       getEnvInt64("QUOTA_ITEMS", 0),
 
 		// Database
 		DatabaseURL: getEnv("DATABASE_URL", ""),
@@ -112,6 +189,26 @@ func getEnvInt(key string, dflt int) int {
 	return dflt
 }
 
+func getEnvInt64(key string, dflt int64) int64 {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			return i
+		}
+	}
+	return dflt
+}
+
+func getEnvDuration(key string, dflt time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return dflt
+}
+
 func splitAndTrim(s string) []string {
 	parts := strings.Split(s, ",")
 	out := make([]string, 0, len(parts))
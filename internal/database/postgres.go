@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"gorm.io/driver/postgres"
@@ -100,6 +101,68 @@ func AutoMigrate(db *gorm.DB, models ...interface{}) error {
 	return nil
 }
 
+// DropIndex drops an index if it exists.
+// AutoMigrate never alters existing indexes, so an index whose definition
+// changed must be dropped first for AutoMigrate to recreate it.
+func DropIndex(db *gorm.DB, model interface{}, name string) error {
+	if !db.Migrator().HasIndex(model, name) {
+		return nil
+	}
+	if err := db.Migrator().DropIndex(model, name); err != nil {
+		return fmt.Errorf("failed to drop index %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteDuplicates deletes all but the newest row of each group of rows
+// sharing the values of columns, so a unique index on them can be created.
+// Does nothing if the model's table doesn't exist yet.
+func DeleteDuplicates(db *gorm.DB, model interface{}, columns ...string) error {
+	if !db.Migrator().HasTable(model) {
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	var same []string
+	for _, column := range columns {
+		same = append(same, fmt.Sprintf("a.%[1]s = b.%[1]s", column))
+	}
+	sql := fmt.Sprintf("DELETE FROM %[1]s a USING %[1]s b WHERE %[2]s AND a.id < b.id",
+		stmt.Schema.Table, strings.Join(same, " AND "))
+	if err := db.Exec(sql).Error; err != nil {
+		return fmt.Errorf("failed to delete duplicates from %s: %w", stmt.Schema.Table, err)
+	}
+	return nil
+}
+
+// ClearDuplicates sets column to NULL on all but the newest row of each group
+// of rows sharing the values of column and groupColumns, so a unique index on
+// them can be created without deleting anything.
+// Does nothing if the model's table doesn't exist yet.
+func ClearDuplicates(db *gorm.DB, model interface{}, column string, groupColumns ...string) error {
+	if !db.Migrator().HasTable(model) {
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	same := []string{fmt.Sprintf("a.%[1]s = b.%[1]s", column)}
+	for _, c := range groupColumns {
+		same = append(same, fmt.Sprintf("a.%[1]s = b.%[1]s", c))
+	}
+	sql := fmt.Sprintf("UPDATE %[1]s a SET %[2]s = NULL FROM %[1]s b WHERE %[3]s AND a.id < b.id",
+		stmt.Schema.Table, column, strings.Join(same, " AND "))
+	if err := db.Exec(sql).Error; err != nil {
+		return fmt.Errorf("failed to clear duplicates in %s: %w", stmt.Schema.Table, err)
+	}
+	return nil
+}
+
 // Close closes the database connection
 func Close() error {
 	if DB == nil {
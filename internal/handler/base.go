@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"goatsync/internal/codec"
+	"goatsync/internal/service"
 	pkgerrors "goatsync/pkg/errors"
 
 	"github.com/gin-gonic/gin"
@@ -55,6 +56,17 @@ func (h *Base) HandleError(c *gin.Context, err error) {
 	c.AbortWithStatus(http.StatusInternalServerError)
 }
 
+// GetPrefetch parses the prefetch query parameter.
+// If it's invalid, an error response is sent and ok is false.
+func (h *Base) GetPrefetch(c *gin.Context) (prefetch service.Prefetch, ok bool) {
+	prefetch, err := service.ParsePrefetch(c.Query("prefetch"))
+	if err != nil {
+		h.HandleError(c, err)
+		return "", false
+	}
+	return prefetch, true
+}
+
 // GetAuthToken extracts the auth token from the Authorization header
 func (h *Base) GetAuthToken(c *gin.Context) string {
 	return c.GetHeader("Authorization")
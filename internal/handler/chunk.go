@@ -2,6 +2,8 @@ package handler
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"goatsync/internal/model"
 	"goatsync/internal/service"
@@ -13,13 +15,15 @@ import (
 // ChunkHandler handles chunk endpoints
 type ChunkHandler struct {
 	Base
-	chunkService *service.ChunkService
+	chunkService  *service.ChunkService
+	uploadService *service.ChunkUploadService
 }
 
 // NewChunkHandler creates a new chunk handler
-func NewChunkHandler(chunkService *service.ChunkService) *ChunkHandler {
+func NewChunkHandler(chunkService *service.ChunkService, uploadService *service.ChunkUploadService) *ChunkHandler {
 	return &ChunkHandler{
-		chunkService: chunkService,
+		chunkService:  chunkService,
+		uploadService: uploadService,
 	}
 }
 
@@ -40,6 +44,7 @@ func (h *ChunkHandler) Upload(c *gin.Context) {
 		collectionUID, itemUID, chunkUID,
 		user.ID,
 		c.Request.Body,
+		c.Request.ContentLength,
 	)
 	if err != nil {
 		h.HandleError(c, err)
@@ -61,7 +66,7 @@ func (h *ChunkHandler) Download(c *gin.Context) {
 		return
 	}
 
-	data, err := h.chunkService.DownloadChunk(
+	download, err := h.chunkService.DownloadChunk(
 		c.Request.Context(),
 		collectionUID, itemUID, chunkUID,
 		user.ID,
@@ -70,7 +75,128 @@ func (h *ChunkHandler) Download(c *gin.Context) {
 		h.HandleError(c, err)
 		return
 	}
+	defer func() { _ = download.Content.Close() }()
 
-	c.Data(http.StatusOK, "application/octet-stream", data)
+	// ServeContent streams the chunk and handles Range, If-Range and
+	// If-None-Match, answering 206 Partial Content for ranges
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("ETag", download.ETag)
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, download.Content)
 }
 
+// uploadOffsetHeader carries the offset of a resumable upload, as in the tus protocol
+const uploadOffsetHeader = "Upload-Offset"
+
+// CreateUpload handles POST /api/v1/collection/:collection_uid/item/:item_uid/chunk/:chunk_uid/upload/
+func (h *ChunkHandler) CreateUpload(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+	collectionUID, itemUID, chunkUID, ok := h.chunkParams(c)
+	if !ok {
+		return
+	}
+
+	out, err := h.uploadService.CreateUpload(c.Request.Context(), collectionUID, itemUID, chunkUID, user.ID)
+	if err != nil {
+		h.HandleError(c, err)
+		return
+	}
+
+	h.respondUpload(c, http.StatusCreated, out)
+}
+
+// GetUpload handles GET /api/v1/collection/:collection_uid/item/:item_uid/chunk/:chunk_uid/upload/
+func (h *ChunkHandler) GetUpload(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+	collectionUID, itemUID, chunkUID, ok := h.chunkParams(c)
+	if !ok {
+		return
+	}
+
+	out, err := h.uploadService.GetUpload(c.Request.Context(), collectionUID, itemUID, chunkUID, user.ID)
+	if err != nil {
+		h.HandleError(c, err)
+		return
+	}
+
+	h.respondUpload(c, http.StatusOK, out)
+}
+
+// AppendUpload handles PATCH /api/v1/collection/:collection_uid/item/:item_uid/chunk/:chunk_uid/upload/
+// The body is the next piece of the chunk; the Upload-Offset header says where it starts.
+func (h *ChunkHandler) AppendUpload(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+	collectionUID, itemUID, chunkUID, ok := h.chunkParams(c)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		h.HandleError(c, pkgerrors.NewValidationError(uploadOffsetHeader, "A non-negative upload offset is required"))
+		return
+	}
+
+	out, err := h.uploadService.AppendUpload(
+		c.Request.Context(),
+		collectionUID, itemUID, chunkUID,
+		user.ID,
+		offset,
+		c.Request.Body,
+		c.Request.ContentLength,
+	)
+	if err != nil {
+		h.HandleError(c, err)
+		return
+	}
+
+	h.respondUpload(c, http.StatusOK, out)
+}
+
+// FinalizeUpload handles POST /api/v1/collection/:collection_uid/item/:item_uid/chunk/:chunk_uid/upload/finalize/
+func (h *ChunkHandler) FinalizeUpload(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+	collectionUID, itemUID, chunkUID, ok := h.chunkParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.uploadService.FinalizeUpload(c.Request.Context(), collectionUID, itemUID, chunkUID, user.ID); err != nil {
+		h.HandleError(c, err)
+		return
+	}
+
+	h.RespondEmpty(c, http.StatusCreated)
+}
+
+// CancelUpload handles DELETE /api/v1/collection/:collection_uid/item/:item_uid/chunk/:chunk_uid/upload/
+func (h *ChunkHandler) CancelUpload(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+	collectionUID, itemUID, chunkUID, ok := h.chunkParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.uploadService.CancelUpload(c.Request.Context(), collectionUID, itemUID, chunkUID, user.ID); err != nil {
+		h.HandleError(c, err)
+		return
+	}
+
+	h.RespondEmpty(c, http.StatusNoContent)
+}
+
+// chunkParams returns the path parameters of a chunk route,
+// responding with an error if the chunk UID is missing
+func (h *ChunkHandler) chunkParams(c *gin.Context) (collectionUID, itemUID, chunkUID string, ok bool) {
+	chunkUID = c.Param("chunk_uid")
+	if chunkUID == "" {
+		h.HandleError(c, pkgerrors.ErrInvalidRequest.WithDetail("missing chunk UID"))
+		return "", "", "", false
+	}
+	return c.Param("collection_uid"), c.Param("item_uid"), chunkUID, true
+}
+
+// respondUpload sends the state of a resumable upload, also as an Upload-Offset header
+func (h *ChunkHandler) respondUpload(c *gin.Context, status int, out *service.ChunkUploadOut) {
+	c.Header(uploadOffsetHeader, strconv.FormatInt(out.Offset, 10))
+	h.RespondMsgpack(c, status, out)
+}
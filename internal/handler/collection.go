@@ -37,7 +37,12 @@ func (h *CollectionHandler) List(c *gin.Context) {
 		}
 	}
 
-	resp, err := h.collectionService.ListCollections(c.Request.Context(), user.ID, stoken, limit)
+	prefetch, ok := h.GetPrefetch(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.collectionService.ListCollections(c.Request.Context(), user.ID, stoken, limit, prefetch)
 	if err != nil {
 		h.HandleError(c, err)
 		return
@@ -48,25 +53,57 @@ func (h *CollectionHandler) List(c *gin.Context) {
 
 // Get handles GET /api/v1/collection/:collection_uid/
 func (h *CollectionHandler) Get(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+
 	collectionUID := c.Param("collection_uid")
 	if collectionUID == "" {
 		h.HandleError(c, pkgerrors.ErrInvalidRequest.WithDetail("missing collection UID"))
 		return
 	}
 
-	col, err := h.collectionService.GetCollection(c.Request.Context(), collectionUID)
+	prefetch, ok := h.GetPrefetch(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.collectionService.GetCollection(c.Request.Context(), collectionUID, user.ID, prefetch)
 	if err != nil {
 		h.HandleError(c, err)
 		return
 	}
 
-	if col == nil {
-		h.HandleError(c, pkgerrors.ErrNotMember)
+	h.RespondMsgpack(c, http.StatusOK, resp)
+}
+
+// Delete handles DELETE /api/v1/collection/:collection_uid/ (GoatSync extension)
+func (h *CollectionHandler) Delete(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+	collectionUID := c.Param("collection_uid")
+
+	if err := h.collectionService.RemoveCollection(c.Request.Context(), collectionUID, user.ID); err != nil {
+		h.HandleError(c, err)
 		return
 	}
 
-	// TODO: Convert to proper response format
-	h.RespondMsgpack(c, http.StatusOK, col)
+	h.RespondEmpty(c, http.StatusNoContent)
+}
+
+// Transfer handles POST /api/v1/collection/:collection_uid/transfer/ (GoatSync extension)
+func (h *CollectionHandler) Transfer(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+	collectionUID := c.Param("collection_uid")
+
+	var req service.CollectionTransferRequest
+	if err := h.ParseMsgpack(c, &req); err != nil {
+		return
+	}
+
+	if err := h.collectionService.TransferCollection(c.Request.Context(), collectionUID, user.ID, &req); err != nil {
+		h.HandleError(c, err)
+		return
+	}
+
+	h.RespondEmpty(c, http.StatusNoContent)
 }
 
 // ListMulti handles POST /api/v1/collection/list_multi/
@@ -87,7 +124,12 @@ func (h *CollectionHandler) ListMulti(c *gin.Context) {
 		}
 	}
 
-	resp, err := h.collectionService.ListMultiCollections(c.Request.Context(), user.ID, req.CollectionTypes, stoken, limit)
+	prefetch, ok := h.GetPrefetch(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.collectionService.ListMultiCollections(c.Request.Context(), user.ID, req.CollectionTypes, stoken, limit, prefetch)
 	if err != nil {
 		h.HandleError(c, err)
 		return
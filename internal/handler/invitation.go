@@ -118,6 +118,24 @@ func (h *InvitationHandler) ListOutgoing(c *gin.Context) {
 	h.RespondMsgpack(c, http.StatusOK, resp)
 }
 
+// CreateOutgoing handles POST /api/v1/invitation/outgoing/
+func (h *InvitationHandler) CreateOutgoing(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+
+	var req service.InvitationCreateRequest
+	if err := h.ParseMsgpack(c, &req); err != nil {
+		return
+	}
+
+	err := h.invitationService.CreateOutgoing(c.Request.Context(), user.ID, &req)
+	if err != nil {
+		h.HandleError(c, err)
+		return
+	}
+
+	h.RespondEmpty(c, http.StatusCreated)
+}
+
 // DeleteOutgoing handles DELETE /api/v1/invitation/outgoing/:invitation_uid/
 func (h *InvitationHandler) DeleteOutgoing(c *gin.Context) {
 	user := c.MustGet("user").(*model.User)
@@ -38,7 +38,12 @@ func (h *ItemHandler) List(c *gin.Context) {
 		}
 	}
 
-	resp, err := h.itemService.ListItems(c.Request.Context(), collectionUID, user.ID, stoken, limit)
+	prefetch, ok := h.GetPrefetch(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.itemService.ListItems(c.Request.Context(), collectionUID, user.ID, stoken, limit, prefetch)
 	if err != nil {
 		h.HandleError(c, err)
 		return
@@ -58,7 +63,12 @@ func (h *ItemHandler) Get(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.itemService.GetItem(c.Request.Context(), collectionUID, itemUID, user.ID)
+	prefetch, ok := h.GetPrefetch(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.itemService.GetItem(c.Request.Context(), collectionUID, itemUID, user.ID, prefetch)
 	if err != nil {
 		h.HandleError(c, err)
 		return
@@ -87,7 +97,12 @@ func (h *ItemHandler) Revisions(c *gin.Context) {
 		}
 	}
 
-	resp, err := h.itemService.GetItemRevisions(c.Request.Context(), collectionUID, itemUID, user.ID, iterator, limit)
+	prefetch, ok := h.GetPrefetch(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.itemService.GetItemRevisions(c.Request.Context(), collectionUID, itemUID, user.ID, iterator, limit, prefetch)
 	if err != nil {
 		h.HandleError(c, err)
 		return
@@ -106,7 +121,8 @@ func (h *ItemHandler) Batch(c *gin.Context) {
 		return
 	}
 
-	err := h.itemService.BatchItems(c.Request.Context(), collectionUID, user.ID, &req)
+	stoken := c.Query("stoken")
+	err := h.itemService.BatchItems(c.Request.Context(), collectionUID, user.ID, stoken, &req)
 	if err != nil {
 		h.HandleError(c, err)
 		return
@@ -125,7 +141,8 @@ func (h *ItemHandler) Transaction(c *gin.Context) {
 		return
 	}
 
-	err := h.itemService.TransactionItems(c.Request.Context(), collectionUID, user.ID, &req)
+	stoken := c.Query("stoken")
+	err := h.itemService.TransactionItems(c.Request.Context(), collectionUID, user.ID, stoken, &req)
 	if err != nil {
 		h.HandleError(c, err)
 		return
@@ -144,7 +161,12 @@ func (h *ItemHandler) FetchUpdates(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.itemService.FetchUpdates(c.Request.Context(), collectionUID, user.ID, &req)
+	prefetch, ok := h.GetPrefetch(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.itemService.FetchUpdates(c.Request.Context(), collectionUID, user.ID, &req, prefetch)
 	if err != nil {
 		h.HandleError(c, err)
 		return
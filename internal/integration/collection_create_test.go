@@ -0,0 +1,105 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"goatsync/internal/model"
+	"goatsync/internal/service"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+func (f *itemFixture) collectionRequest(t testing.TB) *service.CollectionCreateRequest {
+	t.Helper()
+	return &service.CollectionCreateRequest{
+		CollectionType: []byte("type-" + randomUID(t)),
+		CollectionKey:  []byte("collection-key"),
+		Item:           f.item(t, randomUID(t)),
+	}
+}
+
+// TestCreateCollection verifies that a new collection comes with its main
+// item, an admin membership for its creator and a collection type, and that
+// it can be written to right away
+func TestCreateCollection(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	req := f.collectionRequest(t)
+
+	out, err := f.collectionService.CreateCollection(ctx, f.userID, req)
+	if err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	if out.Item.UID != req.Item.UID || out.Item.Etag != req.Item.Content.UID {
+		t.Errorf("Unexpected main item: %+v", out.Item)
+	}
+
+	col, err := f.collectionRepo.GetByUID(ctx, req.Item.UID)
+	if err != nil || col == nil || col.MainItemID == nil {
+		t.Fatalf("Expected the collection with a main item, got %+v, %v", col, err)
+	}
+	member, err := f.memberRepo.GetByUserAndCollection(ctx, f.userID, col.ID)
+	if err != nil || member == nil {
+		t.Fatalf("Expected a membership, got %v", err)
+	}
+	if !member.IsAdmin() || string(member.EncryptionKey) != "collection-key" || member.StokenID == nil {
+		t.Errorf("Expected an admin member with the key and a stoken, got %+v", member)
+	}
+	var colType model.CollectionType
+	if member.CollectionTypeID == nil || testDB.First(&colType, *member.CollectionTypeID).Error != nil ||
+		string(colType.UID) != string(req.CollectionType) || colType.OwnerID != f.userID {
+		t.Errorf("Expected the member to have collection type %s", req.CollectionType)
+	}
+
+	batch := &service.ItemBatchRequest{Items: []service.ItemBatchIn{f.item(t, randomUID(t))}}
+	if err := f.itemService.BatchItems(ctx, col.UID, f.userID, "", batch); err != nil {
+		t.Errorf("BatchItems into the new collection failed: %v", err)
+	}
+}
+
+// TestConcurrentCreateCollection verifies that of several creators racing
+// for the same UID, exactly one wins and the rest get unique_uid
+func TestConcurrentCreateCollection(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	uid := randomUID(t)
+
+	const writers = 5
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		req := f.collectionRequest(t)
+		req.Item.UID = uid
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = f.collectionService.CreateCollection(ctx, f.userID, req)
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		var etebaseErr *pkgerrors.EtebaseError
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrUniqueUID.Code:
+			t.Errorf("Expected nil or unique_uid, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("Expected exactly one creation to succeed, got %d", succeeded)
+	}
+
+	col, err := f.collectionRepo.GetByUID(ctx, uid)
+	if err != nil || col == nil {
+		t.Fatalf("Expected the collection to exist, got %v", err)
+	}
+	members, err := f.memberRepo.ListForCollection(ctx, col.ID)
+	if err != nil || len(members) != 1 {
+		t.Errorf("Expected 1 member, got %d, %v", len(members), err)
+	}
+}
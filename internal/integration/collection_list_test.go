@@ -0,0 +1,212 @@
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"goatsync/internal/model"
+	"goatsync/internal/service"
+
+	"gorm.io/gorm"
+)
+
+// TestCollectionListStoken verifies that collection listing pages through
+// collections in stoken order without writing anything, and that following
+// the returned stoken picks up exactly the collections changed since
+func TestCollectionListStoken(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	cols := []*model.Collection{f.collection, f.newCollection(t), f.newCollection(t)}
+
+	countStokens := func() int64 {
+		t.Helper()
+		var count int64
+		if err := testDB.Model(&model.Stoken{}).Count(&count).Error; err != nil {
+			t.Fatalf("Failed to count stokens: %v", err)
+		}
+		return count
+	}
+	list := func(stoken string, limit int) ([]model.Collection, string, bool) {
+		t.Helper()
+		collections, newStoken, done, err := f.collectionRepo.ListForUser(ctx, f.userID, stoken, limit)
+		if err != nil {
+			t.Fatalf("ListForUser failed: %v", err)
+		}
+		if newStoken == nil {
+			t.Fatal("ListForUser returned no stoken")
+		}
+		return collections, newStoken.UID, done
+	}
+
+	stokensBefore := countStokens()
+
+	// First page: the two oldest collections
+	page, stoken, done := list("", 2)
+	if len(page) != 2 || done || page[0].ID != cols[0].ID || page[1].ID != cols[1].ID {
+		t.Fatalf("Unexpected first page: %d collections, done=%v", len(page), done)
+	}
+
+	// Listing is repeatable and doesn't mint stokens
+	if _, again, _ := list("", 2); again != stoken {
+		t.Errorf("Listing twice returned different stokens: %s, %s", stoken, again)
+	}
+	if count := countStokens(); count != stokensBefore {
+		t.Errorf("Listing created %d stokens", count-stokensBefore)
+	}
+
+	// Second page continues where the first ended
+	page, stoken, done = list(stoken, 2)
+	if len(page) != 1 || !done || page[0].ID != cols[2].ID {
+		t.Fatalf("Unexpected second page: %d collections, done=%v", len(page), done)
+	}
+
+	// Nothing changed since: empty page, same stoken
+	page, again, done := list(stoken, 2)
+	if len(page) != 0 || !done || again != stoken {
+		t.Errorf("Expected an empty page with the same stoken, got %d collections, %s", len(page), again)
+	}
+
+	// Writing to the first collection brings it back, alone
+	req := &service.ItemBatchRequest{Items: []service.ItemBatchIn{f.item(t, randomUID(t))}}
+	if err := f.itemService.BatchItems(ctx, cols[0].UID, f.userID, "", req); err != nil {
+		t.Fatalf("BatchItems failed: %v", err)
+	}
+	page, _, done = list(stoken, 2)
+	if len(page) != 1 || !done || page[0].ID != cols[0].ID {
+		t.Errorf("Expected only the changed collection, got %d collections", len(page))
+	}
+}
+
+// TestCollectionListRemovedMemberships verifies that listing from a stoken
+// reports the collections the user was removed from since, once
+func TestCollectionListRemovedMemberships(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	gone := f.newCollection(t)
+
+	resp, err := f.collectionService.ListCollections(ctx, f.userID, "", 10, service.PrefetchMedium)
+	if err != nil || resp.Stoken == nil {
+		t.Fatalf("ListCollections failed: %v", err)
+	}
+	if len(resp.RemovedMemberships) != 0 {
+		t.Errorf("Expected no removals on a first sync, got %v", resp.RemovedMemberships)
+	}
+	stoken := *resp.Stoken
+
+	member, err := f.memberRepo.GetByUserAndCollection(ctx, f.userID, gone.ID)
+	if err != nil || member == nil {
+		t.Fatalf("Failed to get membership: %v", err)
+	}
+	if err := f.memberRepo.Delete(ctx, member.ID); err != nil {
+		t.Fatalf("Failed to remove membership: %v", err)
+	}
+
+	resp, err = f.collectionService.ListCollections(ctx, f.userID, stoken, 10, service.PrefetchMedium)
+	if err != nil {
+		t.Fatalf("ListCollections failed: %v", err)
+	}
+	if len(resp.Data) != 0 || len(resp.RemovedMemberships) != 1 || resp.RemovedMemberships[0].UID != gone.UID {
+		t.Fatalf("Expected only the removal of %s, got %d collections and %v", gone.UID, len(resp.Data), resp.RemovedMemberships)
+	}
+	if resp.Stoken == nil || *resp.Stoken == stoken {
+		t.Fatal("Expected the removal to move the stoken on")
+	}
+
+	// The removal isn't reported again
+	resp, err = f.collectionService.ListCollections(ctx, f.userID, *resp.Stoken, 10, service.PrefetchMedium)
+	if err != nil || len(resp.RemovedMemberships) != 0 {
+		t.Errorf("Expected no removals after following the stoken, got %v, %v", resp.RemovedMemberships, err)
+	}
+}
+
+// countQueries counts the statements run against the test database while fn runs
+func countQueries(t *testing.T, fn func()) int {
+	t.Helper()
+	var count int
+	name := "count_queries_" + randomUID(t)
+	counter := func(*gorm.DB) { count++ }
+	if err := testDB.Callback().Query().After("gorm:query").Register(name, counter); err != nil {
+		t.Fatalf("Failed to register query counter: %v", err)
+	}
+	if err := testDB.Callback().Row().After("gorm:row").Register(name, counter); err != nil {
+		t.Fatalf("Failed to register row counter: %v", err)
+	}
+	defer func() {
+		_ = testDB.Callback().Query().Remove(name)
+		_ = testDB.Callback().Row().Remove(name)
+	}()
+
+	fn()
+	return count
+}
+
+// TestCollectionListResponses verifies that listed collections carry the
+// requesting member's view and the collection's stoken, and that listing
+// takes the same number of queries however many collections there are
+func TestCollectionListResponses(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+
+	readOnly := f.newCollection(t)
+	member, err := f.memberRepo.GetByUserAndCollection(ctx, f.userID, readOnly.ID)
+	if err != nil || member == nil {
+		t.Fatalf("Failed to get membership: %v", err)
+	}
+	member.AccessLevel = model.AccessLevelReadOnly
+	member.User = nil
+	if err := f.memberRepo.Update(ctx, member); err != nil {
+		t.Fatalf("Failed to update membership: %v", err)
+	}
+
+	list := func() *service.CollectionListResponse {
+		t.Helper()
+		resp, err := f.collectionService.ListCollections(ctx, f.userID, "", 50, service.PrefetchAuto)
+		if err != nil {
+			t.Fatalf("ListCollections failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := list()
+	if len(resp.Data) != 2 {
+		t.Fatalf("Expected 2 collections, got %d", len(resp.Data))
+	}
+	for i, col := range []*model.Collection{f.collection, readOnly} {
+		stoken, err := f.collectionRepo.GetStoken(ctx, col.ID)
+		if err != nil || stoken == nil {
+			t.Fatalf("GetStoken failed: %v", err)
+		}
+		if resp.Data[i].Stoken != stoken.UID {
+			t.Errorf("Collection %d: expected stoken %s, got %s", i, stoken.UID, resp.Data[i].Stoken)
+		}
+		if string(resp.Data[i].CollectionKey) != "key" {
+			t.Errorf("Collection %d: expected the member's key, got %q", i, resp.Data[i].CollectionKey)
+		}
+	}
+	if resp.Data[0].AccessLevel != "admin" || resp.Data[1].AccessLevel != "readOnly" {
+		t.Errorf("Expected admin and readOnly, got %s and %s", resp.Data[0].AccessLevel, resp.Data[1].AccessLevel)
+	}
+
+	// More collections with main items and chunks don't add queries
+	create := func(n int) {
+		t.Helper()
+		for range n {
+			req := f.collectionRequest(t)
+			req.Item.Content.Chunks = []service.ChunkIn{{UID: randomUID(t), Content: []byte("chunk")}}
+			if _, err := f.collectionService.CreateCollection(ctx, f.userID, req); err != nil {
+				t.Fatalf("CreateCollection failed: %v", err)
+			}
+		}
+	}
+	create(1)
+	small := countQueries(t, func() { list() })
+	create(3)
+	large := countQueries(t, func() {
+		if resp := list(); len(resp.Data) != 6 {
+			t.Errorf("Expected 6 collections, got %d", len(resp.Data))
+		}
+	})
+	if large != small {
+		t.Errorf("Listing 6 collections took %d queries, 3 took %d", large, small)
+	}
+}
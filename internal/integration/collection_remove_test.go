@@ -0,0 +1,118 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/service"
+	"goatsync/internal/storage"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// TestRemoveCollection verifies that deleting a collection drops it from its
+// members' listings right away, and that the purge later deletes its
+// contents and gives the owner's quota back while still reporting the removal
+func TestRemoveCollection(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+
+	quotaRepo := repository.NewQuotaRepository(testDB)
+	initial, err := quotaRepo.Get(ctx, f.userID)
+	if err != nil {
+		t.Fatalf("Failed to load quota: %v", err)
+	}
+
+	req := f.collectionRequest(t)
+	if _, err := f.collectionService.CreateCollection(ctx, f.userID, req); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	in := f.item(t, randomUID(t))
+	in.Content.Chunks = []service.ChunkIn{{UID: randomUID(t), Content: []byte(randomUID(t))}}
+	if err := f.itemService.BatchItems(ctx, req.Item.UID, f.userID, "", &service.ItemBatchRequest{Items: []service.ItemBatchIn{in}}); err != nil {
+		t.Fatalf("BatchItems failed: %v", err)
+	}
+	col, _ := f.collectionRepo.GetByUID(ctx, req.Item.UID)
+
+	before, err := f.collectionService.ListCollections(ctx, f.userID, "", 100, service.PrefetchMedium)
+	if err != nil || before.Stoken == nil {
+		t.Fatalf("ListCollections failed: %+v, %v", before, err)
+	}
+
+	if err := f.collectionService.RemoveCollection(ctx, col.UID, f.userID); err != nil {
+		t.Fatalf("RemoveCollection failed: %v", err)
+	}
+
+	// Syncing from before the deletion reports it as a removed membership
+	after, err := f.collectionService.ListCollections(ctx, f.userID, *before.Stoken, 100, service.PrefetchMedium)
+	if err != nil {
+		t.Fatalf("ListCollections failed: %v", err)
+	}
+	found := false
+	for _, rm := range after.RemovedMemberships {
+		found = found || rm.UID == col.UID
+	}
+	if !found {
+		t.Errorf("Expected %s among the removed memberships, got %+v", col.UID, after.RemovedMemberships)
+	}
+	_, err = f.collectionService.GetCollection(ctx, col.UID, f.userID, service.PrefetchMedium)
+	var etebaseErr *pkgerrors.EtebaseError
+	if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrNotMember.Code {
+		t.Errorf("Expected not_member for a removed collection, got %v", err)
+	}
+
+	cfg := &config.Config{}
+	quotaService := service.NewQuotaService(quotaRepo, cfg)
+	chunkService := service.NewChunkService(repository.NewChunkRepository(testDB), f.collectionRepo, f.memberRepo, repository.NewTransactor(testDB), storage.NewFileStorage(t.TempDir()), quotaService)
+	purger := service.NewCollectionPurger(f.collectionRepo, repository.NewRevisionRepository(testDB), repository.NewTransactor(testDB), chunkService, quotaService, cfg)
+
+	report, err := purger.Run(ctx, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	found = false
+	for _, uid := range report.Collections {
+		found = found || uid == col.UID
+	}
+	if !found {
+		t.Errorf("Expected %s to be purged, got %v", col.UID, report.Collections)
+	}
+
+	for _, table := range []any{&model.CollectionItem{}, &model.CollectionItemChunk{}} {
+		var count int64
+		if err := testDB.Model(table).Where("collection_id = ?", col.ID).Count(&count).Error; err != nil {
+			t.Fatalf("Failed to count %T: %v", table, err)
+		}
+		if count != 0 {
+			t.Errorf("Expected no %T left, got %d", table, count)
+		}
+	}
+
+	// A device offline since before the deletion is still told to drop it
+	if purged, err := f.collectionRepo.GetByUID(ctx, col.UID); err != nil || purged == nil || purged.PurgedAt == nil {
+		t.Errorf("Expected the collection to be kept as purged, got %+v, %v", purged, err)
+	}
+	after, err = f.collectionService.ListCollections(ctx, f.userID, *before.Stoken, 100, service.PrefetchMedium)
+	if err != nil {
+		t.Fatalf("ListCollections failed: %v", err)
+	}
+	found = false
+	for _, rm := range after.RemovedMemberships {
+		found = found || rm.UID == col.UID
+	}
+	if !found {
+		t.Errorf("Expected %s among the removed memberships after the purge, got %+v", col.UID, after.RemovedMemberships)
+	}
+
+	quota, err := quotaRepo.Get(ctx, f.userID)
+	if err != nil {
+		t.Fatalf("Failed to load quota: %v", err)
+	}
+	if quota.UsedCollections != initial.UsedCollections || quota.UsedItems != initial.UsedItems {
+		t.Errorf("Expected the quota to be given back to %d collections and %d items, got %d and %d",
+			initial.UsedCollections, initial.UsedItems, quota.UsedCollections, quota.UsedItems)
+	}
+}
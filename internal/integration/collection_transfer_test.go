@@ -0,0 +1,112 @@
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/service"
+)
+
+// TestTransferCollection verifies that a transferred collection's usage
+// moves to the new owner's quota, that the new owner's membership ends up
+// with a collection type of theirs, and that no member has anything to sync
+func TestTransferCollection(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	other := newItemFixture(t)
+
+	req := f.collectionRequest(t)
+	if _, err := f.collectionService.CreateCollection(ctx, f.userID, req); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	in := f.item(t, randomUID(t))
+	content := []byte(randomUID(t))
+	in.Content.Chunks = []service.ChunkIn{{UID: randomUID(t), Content: content}}
+	if err := f.itemService.BatchItems(ctx, req.Item.UID, f.userID, "", &service.ItemBatchRequest{Items: []service.ItemBatchIn{in}}); err != nil {
+		t.Fatalf("BatchItems failed: %v", err)
+	}
+	col, _ := f.collectionRepo.GetByUID(ctx, req.Item.UID)
+
+	// A membership left pointing at the owner's collection type
+	colType := &model.CollectionType{OwnerID: f.userID, UID: []byte("type-" + randomUID(t))}
+	if err := repository.NewCollectionTypeRepository(testDB).Create(ctx, colType); err != nil {
+		t.Fatalf("Failed to create collection type: %v", err)
+	}
+	member := &model.CollectionMember{
+		CollectionID:     col.ID,
+		UserID:           other.userID,
+		AccessLevel:      model.AccessLevelAdmin,
+		EncryptionKey:    []byte("key"),
+		CollectionTypeID: &colType.ID,
+	}
+	if err := f.memberRepo.Create(ctx, member); err != nil {
+		t.Fatalf("Failed to create member: %v", err)
+	}
+
+	quotaRepo := repository.NewQuotaRepository(testDB)
+	fromBefore, err := quotaRepo.Get(ctx, f.userID)
+	if err != nil {
+		t.Fatalf("Failed to load quota: %v", err)
+	}
+	toBefore, err := quotaRepo.Get(ctx, other.userID)
+	if err != nil {
+		t.Fatalf("Failed to load quota: %v", err)
+	}
+	synced := map[uint]string{}
+	for _, userID := range []uint{f.userID, other.userID} {
+		list, err := f.collectionService.ListCollections(ctx, userID, "", 100, service.PrefetchMedium)
+		if err != nil || list.Stoken == nil {
+			t.Fatalf("ListCollections failed: %+v, %v", list, err)
+		}
+		synced[userID] = *list.Stoken
+	}
+
+	if err := f.collectionService.TransferOwnership(ctx, col.UID, other.username(t)); err != nil {
+		t.Fatalf("TransferOwnership failed: %v", err)
+	}
+
+	col, _ = f.collectionRepo.GetByUID(ctx, col.UID)
+	if col.OwnerID != other.userID {
+		t.Errorf("Expected user %d to own the collection, got %d", other.userID, col.OwnerID)
+	}
+	var typeOwner uint
+	if err := testDB.Model(&model.CollectionType{}).Where("id = ?", colType.ID).Pluck("owner_id", &typeOwner).Error; err != nil {
+		t.Fatalf("Failed to load collection type: %v", err)
+	}
+	if typeOwner != other.userID {
+		t.Errorf("Expected the new owner's collection type to be theirs, got owner %d", typeOwner)
+	}
+
+	// One collection, one item and the chunk move between quotas
+	fromAfter, _ := quotaRepo.Get(ctx, f.userID)
+	toAfter, _ := quotaRepo.Get(ctx, other.userID)
+	size := int64(len(content))
+	if fromAfter.UsedCollections != fromBefore.UsedCollections-1 || fromAfter.UsedItems != fromBefore.UsedItems-1 ||
+		fromAfter.UsedChunkBytes != fromBefore.UsedChunkBytes-size {
+		t.Errorf("Expected the old owner's usage to drop from %+v, got %+v", fromBefore, fromAfter)
+	}
+	if toAfter.UsedCollections != toBefore.UsedCollections+1 || toAfter.UsedItems != toBefore.UsedItems+1 ||
+		toAfter.UsedChunkBytes != toBefore.UsedChunkBytes+size {
+		t.Errorf("Expected the new owner's usage to grow from %+v, got %+v", toBefore, toAfter)
+	}
+
+	// Every member is up to date, and the chunk is still there
+	for userID, stoken := range synced {
+		list, err := f.collectionService.ListCollections(ctx, userID, stoken, 100, service.PrefetchMedium)
+		if err != nil {
+			t.Fatalf("ListCollections failed: %v", err)
+		}
+		if len(list.Data) != 0 || len(list.RemovedMemberships) != 0 {
+			t.Errorf("Expected nothing new for user %d, got %+v", userID, list)
+		}
+	}
+	item, err := f.itemService.GetItem(ctx, col.UID, in.UID, other.userID, service.PrefetchAuto)
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if len(item.Content.Chunks) != 1 || string(item.Content.Chunks[0].Content) != string(content) {
+		t.Errorf("Expected the chunk content to be served, got %+v", item.Content.Chunks)
+	}
+}
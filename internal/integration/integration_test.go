@@ -11,6 +11,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"goatsync/internal/config"
 	"goatsync/internal/crypto"
@@ -34,6 +35,7 @@ func TestMain(m *testing.M) {
 		Debug:                 true,
 		EncryptionSecret:      "test-secret-key-for-testing-32ch",
 		ChunkStoragePath:      "/tmp/goatsync-test-chunks",
+		ChunkUploadExpiry:     time.Hour,
 		ChallengeValidSeconds: 300,
 	}
 
@@ -49,6 +51,8 @@ func TestMain(m *testing.M) {
 		os.Exit(0)
 	}
 
+	testDB = db
+
 	// Run migrations
 	_ = database.AutoMigrate(db,
 		&model.Stoken{},
@@ -60,10 +64,14 @@ func TestMain(m *testing.M) {
 		&model.CollectionItem{},
 		&model.CollectionItemRevision{},
 		&model.CollectionItemChunk{},
+		&model.ChunkBlob{},
 		&model.RevisionChunkRelation{},
 		&model.CollectionMember{},
 		&model.CollectionMemberRemoved{},
 		&model.CollectionInvitation{},
+		&model.ChunkUpload{},
+		&model.UserQuota{},
+		&model.CollectionRetention{},
 	)
 
 	// Clear test data
@@ -75,23 +83,30 @@ func TestMain(m *testing.M) {
 	tokenRepo := repository.NewTokenRepository(db)
 	collectionRepo := repository.NewCollectionRepository(db)
 	itemRepo := repository.NewItemRepository(db)
+	revisionRepo := repository.NewRevisionRepository(db)
 	memberRepo := repository.NewMemberRepository(db)
+	collectionTypeRepo := repository.NewCollectionTypeRepository(db)
 	invitationRepo := repository.NewInvitationRepository(db)
 	chunkRepo := repository.NewChunkRepository(db)
+	quotaRepo := repository.NewQuotaRepository(db)
+	uploadRepo := repository.NewChunkUploadRepository(db)
+	transactor := repository.NewTransactor(db)
 
 	authService := service.NewAuthService(userRepo, tokenRepo, cfg)
-	collectionService := service.NewCollectionService(collectionRepo, cfg)
-	itemService := service.NewItemService(itemRepo, nil, collectionRepo, memberRepo)
-	memberService := service.NewMemberService(memberRepo, collectionRepo)
-	invitationService := service.NewInvitationService(invitationRepo, memberRepo, userRepo)
-	chunkService := service.NewChunkService(chunkRepo, collectionRepo, memberRepo, fileStorage)
+	quotaService := service.NewQuotaService(quotaRepo, cfg)
+	chunkService := service.NewChunkService(chunkRepo, collectionRepo, memberRepo, transactor, fileStorage, quotaService)
+	collectionService := service.NewCollectionService(collectionRepo, itemRepo, revisionRepo, memberRepo, collectionTypeRepo, invitationRepo, transactor, chunkService, quotaService, cfg)
+	itemService := service.NewItemService(itemRepo, revisionRepo, collectionRepo, memberRepo, transactor, chunkService, quotaService, cfg)
+	memberService := service.NewMemberService(memberRepo, collectionRepo, invitationRepo, transactor)
+	invitationService := service.NewInvitationService(invitationRepo, memberRepo, userRepo, collectionRepo, transactor, cfg)
+	uploadService := service.NewChunkUploadService(uploadRepo, chunkRepo, collectionRepo, memberRepo, transactor, chunkService, quotaService, fileStorage, cfg)
 
 	authHandler := handler.NewAuthHandler(authService)
 	collectionHandler := handler.NewCollectionHandler(collectionService)
 	itemHandler := handler.NewItemHandler(itemService)
 	memberHandler := handler.NewMemberHandler(memberService)
 	invitationHandler := handler.NewInvitationHandler(invitationService)
-	chunkHandler := handler.NewChunkHandler(chunkService)
+	chunkHandler := handler.NewChunkHandler(chunkService, uploadService)
 	websocketHandler := handler.NewWebSocketHandler(nil)
 	healthHandler := handler.NewHealthHandler(db)
 	testHandler := handler.NewTestHandler(db, true)
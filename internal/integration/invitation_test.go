@@ -0,0 +1,124 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/service"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// username returns the name of the fixture's user
+func (f *itemFixture) username(t testing.TB) string {
+	t.Helper()
+	var username string
+	if err := testDB.Model(&model.User{}).Where("id = ?", f.userID).Pluck("username", &username).Error; err != nil {
+		t.Fatalf("Failed to load username: %v", err)
+	}
+	return username
+}
+
+// TestCreateInvitation verifies that an admin's invitation reaches the
+// invitee, that inviting them again replaces it, and that it can be accepted
+func TestCreateInvitation(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	invitee := newItemFixture(t)
+
+	userRepo := repository.NewUserRepository(testDB)
+	invitationRepo := repository.NewInvitationRepository(testDB)
+	invitationService := service.NewInvitationService(invitationRepo, f.memberRepo, userRepo, f.collectionRepo, repository.NewTransactor(testDB), &config.Config{})
+
+	req := &service.InvitationCreateRequest{
+		UID:                 randomUID(t),
+		Version:             1,
+		AccessLevel:         "readWrite",
+		Username:            invitee.username(t),
+		Collection:          f.collection.UID,
+		SignedEncryptionKey: []byte("signed"),
+	}
+	if err := invitationService.CreateOutgoing(ctx, f.userID, req); err != nil {
+		t.Fatalf("CreateOutgoing failed: %v", err)
+	}
+
+	req.UID = randomUID(t)
+	req.AccessLevel = "readOnly"
+	if err := invitationService.CreateOutgoing(ctx, f.userID, req); err != nil {
+		t.Fatalf("Re-inviting failed: %v", err)
+	}
+
+	incoming, err := invitationService.ListIncoming(ctx, invitee.userID)
+	if err != nil {
+		t.Fatalf("ListIncoming failed: %v", err)
+	}
+	if len(incoming.Data) != 1 || incoming.Data[0].UID != req.UID || incoming.Data[0].AccessLevel != "readOnly" {
+		t.Fatalf("Expected the one updated invitation, got %+v", incoming.Data)
+	}
+
+	if err := invitationService.AcceptInvitation(ctx, req.UID, invitee.userID, []byte("key")); err != nil {
+		t.Fatalf("AcceptInvitation failed: %v", err)
+	}
+	member, err := f.memberRepo.GetByUserAndCollection(ctx, invitee.userID, f.collection.ID)
+	if err != nil || member == nil || member.AccessLevel != model.AccessLevelReadOnly {
+		t.Errorf("Expected a read-only membership, got %+v, %v", member, err)
+	}
+}
+
+// TestConcurrentInvitations verifies that inviting the same user several
+// times at once leaves a single pending invitation, with any sender that
+// lost the race told it exists
+func TestConcurrentInvitations(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	invitee := newItemFixture(t)
+
+	invitationRepo := repository.NewInvitationRepository(testDB)
+	invitationService := service.NewInvitationService(invitationRepo, f.memberRepo, repository.NewUserRepository(testDB),
+		f.collectionRepo, repository.NewTransactor(testDB), &config.Config{})
+
+	const senders = 5
+	errs := make([]error, senders)
+	var wg sync.WaitGroup
+	for i := range senders {
+		req := &service.InvitationCreateRequest{
+			UID:                 randomUID(t),
+			Version:             1,
+			AccessLevel:         "readWrite",
+			Username:            invitee.username(t),
+			Collection:          f.collection.UID,
+			SignedEncryptionKey: []byte("signed"),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = invitationService.CreateOutgoing(ctx, f.userID, req)
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		var etebaseErr *pkgerrors.EtebaseError
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrInvitationExists.Code:
+			t.Errorf("Expected nil or invitation_exists, got %v", err)
+		}
+	}
+	if succeeded == 0 {
+		t.Error("Expected at least one invitation to succeed")
+	}
+	var count int64
+	if err := testDB.Model(&model.CollectionInvitation{}).Where("user_id = ?", invitee.userID).Count(&count).Error; err != nil {
+		t.Fatalf("Failed to count invitations: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 pending invitation, got %d", count)
+	}
+}
@@ -0,0 +1,301 @@
+package integration
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/service"
+	"goatsync/internal/storage"
+	pkgerrors "goatsync/pkg/errors"
+
+	"gorm.io/gorm"
+)
+
+// testDB is the database the integration tests run against
+var testDB *gorm.DB
+
+// itemFixture is a collection owned by a fresh user, with services wired to the test database
+type itemFixture struct {
+	userID            uint
+	collection        *model.Collection
+	collectionRepo    repository.CollectionRepository
+	memberRepo        repository.MemberRepository
+	itemService       *service.ItemService
+	collectionService *service.CollectionService
+}
+
+func randomUID(t testing.TB) string {
+	t.Helper()
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("Failed to generate UID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func newItemFixture(t testing.TB) *itemFixture {
+	t.Helper()
+	ctx := context.Background()
+	cfg := &config.Config{}
+
+	userRepo := repository.NewUserRepository(testDB)
+	collectionRepo := repository.NewCollectionRepository(testDB)
+	itemRepo := repository.NewItemRepository(testDB)
+	revisionRepo := repository.NewRevisionRepository(testDB)
+	memberRepo := repository.NewMemberRepository(testDB)
+	collectionTypeRepo := repository.NewCollectionTypeRepository(testDB)
+	invitationRepo := repository.NewInvitationRepository(testDB)
+	chunkRepo := repository.NewChunkRepository(testDB)
+	quotaRepo := repository.NewQuotaRepository(testDB)
+	transactor := repository.NewTransactor(testDB)
+
+	name := "concurrency_" + randomUID(t)[:12]
+	user := &model.User{Username: name, Email: name + "@example.com"}
+	userInfo := &model.UserInfo{
+		LoginPubkey:      []byte("login-pubkey"),
+		Pubkey:           []byte("pubkey"),
+		EncryptedContent: []byte("content"),
+		Salt:             []byte("salt"),
+	}
+	if err := userRepo.Create(ctx, user, userInfo); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	quotaService := service.NewQuotaService(quotaRepo, cfg)
+	chunkService := service.NewChunkService(chunkRepo, collectionRepo, memberRepo, transactor, storage.NewFileStorage(t.TempDir()), quotaService)
+	itemService := service.NewItemService(itemRepo, revisionRepo, collectionRepo, memberRepo, transactor, chunkService, quotaService, cfg)
+	collectionService := service.NewCollectionService(collectionRepo, itemRepo, revisionRepo, memberRepo, collectionTypeRepo, invitationRepo, transactor, chunkService, quotaService, cfg)
+
+	f := &itemFixture{
+		userID:            user.ID,
+		collectionRepo:    collectionRepo,
+		memberRepo:        memberRepo,
+		itemService:       itemService,
+		collectionService: collectionService,
+	}
+	f.collection = f.newCollection(t)
+	return f
+}
+
+// newCollection creates another collection administered by the fixture's user
+func (f *itemFixture) newCollection(t testing.TB) *model.Collection {
+	t.Helper()
+	ctx := context.Background()
+
+	col := &model.Collection{UID: randomUID(t), OwnerID: f.userID}
+	if err := f.collectionRepo.Create(ctx, col); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	member := &model.CollectionMember{
+		CollectionID:  col.ID,
+		UserID:        f.userID,
+		AccessLevel:   model.AccessLevelAdmin,
+		EncryptionKey: []byte("key"),
+	}
+	if err := f.memberRepo.Create(ctx, member); err != nil {
+		t.Fatalf("Failed to create member: %v", err)
+	}
+	return col
+}
+
+func (f *itemFixture) item(t testing.TB, uid string) service.ItemBatchIn {
+	t.Helper()
+	return service.ItemBatchIn{
+		UID:     uid,
+		Version: 1,
+		Content: service.ContentIn{UID: randomUID(t), Meta: []byte("meta")},
+	}
+}
+
+func (f *itemFixture) stoken(t testing.TB) string {
+	t.Helper()
+	stoken, err := f.collectionRepo.GetStoken(context.Background(), f.collection.ID)
+	if err != nil || stoken == nil {
+		t.Fatalf("GetStoken: got %v, %v", stoken, err)
+	}
+	return stoken.UID
+}
+
+func (f *itemFixture) countItems(t testing.TB) int64 {
+	t.Helper()
+	var count int64
+	if err := testDB.Model(&model.CollectionItem{}).Where("collection_id = ?", f.collection.ID).Count(&count).Error; err != nil {
+		t.Fatalf("Failed to count items: %v", err)
+	}
+	return count
+}
+
+// TestConcurrentTransactionsStaleStoken verifies that of several writers
+// starting from the same stoken, exactly one wins and the rest are told
+// their view is stale
+func TestConcurrentTransactionsStaleStoken(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+
+	first := f.item(t, randomUID(t))
+	if err := f.itemService.BatchItems(ctx, f.collection.UID, f.userID, "", &service.ItemBatchRequest{Items: []service.ItemBatchIn{first}}); err != nil {
+		t.Fatalf("Initial batch failed: %v", err)
+	}
+	stoken := f.stoken(t)
+
+	const writers = 5
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		req := &service.ItemTransactionRequest{
+			Items: []service.ItemBatchIn{f.item(t, randomUID(t))},
+			Deps:  &service.Dependencies{Stoken: stoken},
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.itemService.TransactionItems(ctx, f.collection.UID, f.userID, "", req)
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, pkgerrors.ErrStaleStoken):
+			t.Errorf("Expected nil or stale_stoken, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("Expected exactly one transaction to succeed, got %d", succeeded)
+	}
+	if count := f.countItems(t); count != 2 {
+		t.Errorf("Expected 2 items, got %d", count)
+	}
+
+	// The winner moved the stoken on
+	if f.stoken(t) == stoken {
+		t.Error("Collection stoken did not change after a write")
+	}
+}
+
+// TestConcurrentBatches verifies that concurrent batches without a
+// dependency all apply in full
+func TestConcurrentBatches(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+
+	const writers, perBatch = 5, 3
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		req := &service.ItemBatchRequest{}
+		for range perBatch {
+			req.Items = append(req.Items, f.item(t, randomUID(t)))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.itemService.BatchItems(ctx, f.collection.UID, f.userID, "", req)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Batch %d failed: %v", i, err)
+		}
+	}
+	if count := f.countItems(t); count != writers*perBatch {
+		t.Errorf("Expected %d items, got %d", writers*perBatch, count)
+	}
+}
+
+// TestTransactionRollback verifies that a failed etag check discards the
+// items written earlier in the same transaction
+func TestTransactionRollback(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+
+	existing := f.item(t, randomUID(t))
+	if err := f.itemService.BatchItems(ctx, f.collection.UID, f.userID, "", &service.ItemBatchRequest{Items: []service.ItemBatchIn{existing}}); err != nil {
+		t.Fatalf("Initial batch failed: %v", err)
+	}
+
+	wrongEtag := "wrong-etag"
+	conflicting := f.item(t, existing.UID)
+	conflicting.Etag = &wrongEtag
+	req := &service.ItemTransactionRequest{
+		Items: []service.ItemBatchIn{f.item(t, randomUID(t)), conflicting},
+	}
+
+	err := f.itemService.TransactionItems(ctx, f.collection.UID, f.userID, "", req)
+	var etebaseErr *pkgerrors.EtebaseError
+	if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrWrongEtag.Code {
+		t.Fatalf("Expected wrong_etag, got %v", err)
+	}
+	if count := f.countItems(t); count != 1 {
+		t.Errorf("Expected the transaction to roll back to 1 item, got %d", count)
+	}
+}
+
+// TestConcurrentItemUpdates verifies that concurrent writes to the same item
+// each add a revision, leaving exactly one of them current
+func TestConcurrentItemUpdates(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	uid := randomUID(t)
+
+	if err := f.itemService.BatchItems(ctx, f.collection.UID, f.userID, "", &service.ItemBatchRequest{Items: []service.ItemBatchIn{f.item(t, uid)}}); err != nil {
+		t.Fatalf("Initial batch failed: %v", err)
+	}
+
+	const writers = 5
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		req := &service.ItemBatchRequest{Items: []service.ItemBatchIn{f.item(t, uid)}}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.itemService.BatchItems(ctx, f.collection.UID, f.userID, "", req)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Write %d failed: %v", i, err)
+		}
+	}
+
+	var revisions []model.CollectionItemRevision
+	if err := testDB.Joins("JOIN django_collectionitem item ON item.id = django_collectionitemrevision.item_id").
+		Where("item.collection_id = ? AND item.uid = ?", f.collection.ID, uid).
+		Find(&revisions).Error; err != nil {
+		t.Fatalf("Failed to load revisions: %v", err)
+	}
+	current := 0
+	var currentUID string
+	for _, rev := range revisions {
+		if rev.Current != nil && *rev.Current {
+			current++
+			currentUID = rev.UID
+		}
+	}
+	if len(revisions) != writers+1 || current != 1 {
+		t.Fatalf("Expected %d revisions with 1 current, got %d with %d", writers+1, len(revisions), current)
+	}
+
+	item, err := f.itemService.GetItem(ctx, f.collection.UID, uid, f.userID, service.PrefetchMedium)
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if item.Etag != currentUID {
+		t.Errorf("Expected etag %s, got %s", currentUID, item.Etag)
+	}
+}
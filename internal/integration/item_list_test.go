@@ -0,0 +1,181 @@
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/service"
+)
+
+// seedItems inserts n items with one current revision each into col,
+// bypassing the services so large collections can be set up quickly
+func seedItems(tb testing.TB, col *model.Collection, n int) {
+	tb.Helper()
+	const batchSize = 1000
+	current := true
+
+	for start := 0; start < n; start += batchSize {
+		size := min(batchSize, n-start)
+
+		stokens := make([]model.Stoken, size)
+		items := make([]model.CollectionItem, size)
+		for i := range items {
+			items[i] = model.CollectionItem{UID: randomUID(tb), CollectionID: col.ID, Version: 1}
+		}
+		if err := testDB.Create(&stokens).Error; err != nil {
+			tb.Fatalf("Failed to create stokens: %v", err)
+		}
+		if err := testDB.Create(&items).Error; err != nil {
+			tb.Fatalf("Failed to create items: %v", err)
+		}
+
+		revisions := make([]model.CollectionItemRevision, size)
+		for i := range revisions {
+			revisions[i] = model.CollectionItemRevision{
+				UID:      randomUID(tb),
+				ItemID:   items[i].ID,
+				StokenID: stokens[i].ID,
+				Meta:     []byte("meta"),
+				Current:  &current,
+			}
+		}
+		if err := testDB.Create(&revisions).Error; err != nil {
+			tb.Fatalf("Failed to create revisions: %v", err)
+		}
+	}
+}
+
+// TestItemListStoken verifies that item listing pages through items in
+// stoken order and only returns items changed after the given stoken
+func TestItemListStoken(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	itemRepo := repository.NewItemRepository(testDB)
+	seedItems(t, f.collection, 5)
+
+	// Page through everything
+	var seen []uint
+	stoken, done := "", false
+	for !done {
+		items, newStoken, pageDone, err := itemRepo.ListForCollection(ctx, f.collection.ID, stoken, 2)
+		if err != nil {
+			t.Fatalf("ListForCollection failed: %v", err)
+		}
+		for _, item := range items {
+			seen = append(seen, item.ID)
+		}
+		stoken, done = newStoken.UID, pageDone
+	}
+	if len(seen) != 5 {
+		t.Fatalf("Expected 5 items, got %d", len(seen))
+	}
+
+	// Nothing changed since: empty page, same stoken
+	items, newStoken, done, err := itemRepo.ListForCollection(ctx, f.collection.ID, stoken, 2)
+	if err != nil || len(items) != 0 || !done || newStoken == nil || newStoken.UID != stoken {
+		t.Fatalf("Expected an empty page with the same stoken, got %d items, %v", len(items), err)
+	}
+
+	// Updating the oldest item moves it to the end of the stoken order
+	var oldest model.CollectionItem
+	if err := testDB.First(&oldest, seen[0]).Error; err != nil {
+		t.Fatalf("Failed to load item: %v", err)
+	}
+	req := &service.ItemBatchRequest{Items: []service.ItemBatchIn{f.item(t, oldest.UID)}}
+	if err := f.itemService.BatchItems(ctx, f.collection.UID, f.userID, "", req); err != nil {
+		t.Fatalf("BatchItems failed: %v", err)
+	}
+
+	items, _, done, err = itemRepo.ListForCollection(ctx, f.collection.ID, stoken, 2)
+	if err != nil || len(items) != 1 || !done || items[0].ID != oldest.ID {
+		t.Fatalf("Expected only the updated item, got %d items, %v", len(items), err)
+	}
+
+	items, _, _, err = itemRepo.ListForCollection(ctx, f.collection.ID, "", 10)
+	if err != nil || len(items) != 5 || items[4].ID != oldest.ID {
+		t.Errorf("Expected the updated item last in a full listing, got %d items, %v", len(items), err)
+	}
+}
+
+// BenchmarkItemList measures item listing in a collection with tens of
+// thousands of items, both the first page of an initial sync and an
+// incremental sync that only picks up a handful of changes
+func BenchmarkItemList(b *testing.B) {
+	ctx := context.Background()
+	f := newItemFixture(b)
+	itemRepo := repository.NewItemRepository(testDB)
+
+	const total, changed = 20000, 10
+	seedItems(b, f.collection, total)
+	stoken := f.stoken(b)
+
+	req := &service.ItemBatchRequest{}
+	for range changed {
+		req.Items = append(req.Items, f.item(b, randomUID(b)))
+	}
+	if err := f.itemService.BatchItems(ctx, f.collection.UID, f.userID, "", req); err != nil {
+		b.Fatalf("BatchItems failed: %v", err)
+	}
+
+	b.Run("initial", func(b *testing.B) {
+		for b.Loop() {
+			items, _, done, err := itemRepo.ListForCollection(ctx, f.collection.ID, "", 50)
+			if err != nil || len(items) != 50 || done {
+				b.Fatalf("Unexpected page: %d items, done=%v, %v", len(items), done, err)
+			}
+		}
+	})
+
+	b.Run("incremental", func(b *testing.B) {
+		for b.Loop() {
+			items, _, done, err := itemRepo.ListForCollection(ctx, f.collection.ID, stoken, 50)
+			if err != nil || len(items) != changed || !done {
+				b.Fatalf("Unexpected page: %d items, done=%v, %v", len(items), done, err)
+			}
+		}
+	})
+}
+
+// TestItemRevisionPaging verifies that an item's history pages newest first
+// and that following the iterator visits every revision exactly once
+func TestItemRevisionPaging(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	uid := randomUID(t)
+
+	var written []string
+	for range 7 {
+		in := f.item(t, uid)
+		written = append(written, in.Content.UID)
+		if err := f.itemService.BatchItems(ctx, f.collection.UID, f.userID, "", &service.ItemBatchRequest{Items: []service.ItemBatchIn{in}}); err != nil {
+			t.Fatalf("BatchItems failed: %v", err)
+		}
+	}
+
+	var got []string
+	iterator := ""
+	for {
+		resp, err := f.itemService.GetItemRevisions(ctx, f.collection.UID, uid, f.userID, iterator, 3, service.PrefetchMedium)
+		if err != nil {
+			t.Fatalf("GetItemRevisions failed: %v", err)
+		}
+		for _, rev := range resp.Data {
+			got = append(got, rev.UID)
+		}
+		if resp.Done {
+			break
+		}
+		iterator = *resp.Iterator
+	}
+
+	if len(got) != len(written) {
+		t.Fatalf("Expected %d revisions, got %d", len(written), len(got))
+	}
+	for i, uid := range got {
+		if expected := written[len(written)-1-i]; uid != expected {
+			t.Errorf("Revision %d: expected %s, got %s", i, expected, uid)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/service"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// TestModifyMember verifies that a member's devices see a new access level on
+// their next sync, and that the owner's access level can't be changed
+func TestModifyMember(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	other := newItemFixture(t)
+
+	invitationRepo := repository.NewInvitationRepository(testDB)
+	memberService := service.NewMemberService(f.memberRepo, f.collectionRepo, invitationRepo, repository.NewTransactor(testDB))
+
+	member := &model.CollectionMember{
+		CollectionID:  f.collection.ID,
+		UserID:        other.userID,
+		AccessLevel:   model.AccessLevelReadOnly,
+		EncryptionKey: []byte("key"),
+	}
+	if err := f.memberRepo.Create(ctx, member); err != nil {
+		t.Fatalf("Failed to create member: %v", err)
+	}
+	before, err := f.collectionService.ListCollections(ctx, other.userID, "", 100, service.PrefetchMedium)
+	if err != nil || before.Stoken == nil {
+		t.Fatalf("ListCollections failed: %+v, %v", before, err)
+	}
+
+	if err := memberService.ModifyMember(ctx, f.collection.UID, other.username(t), f.userID, "readWrite"); err != nil {
+		t.Fatalf("ModifyMember failed: %v", err)
+	}
+
+	after, err := f.collectionService.ListCollections(ctx, other.userID, *before.Stoken, 100, service.PrefetchMedium)
+	if err != nil {
+		t.Fatalf("ListCollections failed: %v", err)
+	}
+	if len(after.Data) != 1 || after.Data[0].AccessLevel != "readWrite" {
+		t.Errorf("Expected the collection again with readWrite access, got %+v", after.Data)
+	}
+
+	// The owner is the only admin
+	err = memberService.ModifyMember(ctx, f.collection.UID, f.username(t), f.userID, "readOnly")
+	var etebaseErr *pkgerrors.EtebaseError
+	if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrOwnerImmutable.Code {
+		t.Errorf("Expected owner_immutable changing the owner's level, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/service"
+)
+
+// TestRemoveMember verifies that a removed member loses access, is told on
+// their next sync, and that the invitations they sent are withdrawn
+func TestRemoveMember(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	other := newItemFixture(t)
+	invitee := newItemFixture(t)
+
+	invitationRepo := repository.NewInvitationRepository(testDB)
+	memberService := service.NewMemberService(f.memberRepo, f.collectionRepo, invitationRepo, repository.NewTransactor(testDB))
+
+	member := &model.CollectionMember{
+		CollectionID:  f.collection.ID,
+		UserID:        other.userID,
+		AccessLevel:   model.AccessLevelReadWrite,
+		EncryptionKey: []byte("key"),
+	}
+	if err := f.memberRepo.Create(ctx, member); err != nil {
+		t.Fatalf("Failed to create member: %v", err)
+	}
+	invitation := &model.CollectionInvitation{
+		UID:                 randomUID(t),
+		FromMemberID:        member.ID,
+		UserID:              invitee.userID,
+		SignedEncryptionKey: []byte("signed"),
+	}
+	if err := invitationRepo.Create(ctx, invitation); err != nil {
+		t.Fatalf("Failed to create invitation: %v", err)
+	}
+
+	before, err := f.collectionService.ListCollections(ctx, other.userID, "", 100, service.PrefetchMedium)
+	if err != nil || before.Stoken == nil {
+		t.Fatalf("ListCollections failed: %+v, %v", before, err)
+	}
+
+	if err := memberService.RemoveMember(ctx, f.collection.UID, other.username(t), f.userID); err != nil {
+		t.Fatalf("RemoveMember failed: %v", err)
+	}
+
+	if m, err := f.memberRepo.GetByUserAndCollection(ctx, other.userID, f.collection.ID); err != nil || m != nil {
+		t.Errorf("Expected the membership to be deleted, got %+v, %v", m, err)
+	}
+	if inv, err := invitationRepo.GetByID(ctx, invitation.ID); err != nil || inv != nil {
+		t.Errorf("Expected the invitation to be withdrawn, got %+v, %v", inv, err)
+	}
+
+	after, err := f.collectionService.ListCollections(ctx, other.userID, *before.Stoken, 100, service.PrefetchMedium)
+	if err != nil {
+		t.Fatalf("ListCollections failed: %v", err)
+	}
+	if len(after.RemovedMemberships) != 1 || after.RemovedMemberships[0].UID != f.collection.UID {
+		t.Errorf("Expected %s among the removed memberships, got %+v", f.collection.UID, after.RemovedMemberships)
+	}
+}
+
+// TestRemoveMemberRejoin verifies that a member who is removed and then
+// joins again isn't told to drop the collection by a sync from before
+func TestRemoveMemberRejoin(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	other := newItemFixture(t)
+
+	invitationRepo := repository.NewInvitationRepository(testDB)
+	transactor := repository.NewTransactor(testDB)
+	memberService := service.NewMemberService(f.memberRepo, f.collectionRepo, invitationRepo, transactor)
+	invitationService := service.NewInvitationService(invitationRepo, f.memberRepo, repository.NewUserRepository(testDB),
+		f.collectionRepo, transactor, &config.Config{})
+
+	member := &model.CollectionMember{
+		CollectionID:  f.collection.ID,
+		UserID:        other.userID,
+		AccessLevel:   model.AccessLevelReadWrite,
+		EncryptionKey: []byte("key"),
+	}
+	if err := f.memberRepo.Create(ctx, member); err != nil {
+		t.Fatalf("Failed to create member: %v", err)
+	}
+	before, err := f.collectionService.ListCollections(ctx, other.userID, "", 100, service.PrefetchMedium)
+	if err != nil || before.Stoken == nil {
+		t.Fatalf("ListCollections failed: %+v, %v", before, err)
+	}
+
+	if err := memberService.RemoveMember(ctx, f.collection.UID, other.username(t), f.userID); err != nil {
+		t.Fatalf("RemoveMember failed: %v", err)
+	}
+	req := &service.InvitationCreateRequest{
+		UID:                 randomUID(t),
+		Version:             1,
+		AccessLevel:         "readWrite",
+		Username:            other.username(t),
+		Collection:          f.collection.UID,
+		SignedEncryptionKey: []byte("signed"),
+	}
+	if err := invitationService.CreateOutgoing(ctx, f.userID, req); err != nil {
+		t.Fatalf("CreateOutgoing failed: %v", err)
+	}
+	if err := invitationService.AcceptInvitation(ctx, req.UID, other.userID, []byte("key")); err != nil {
+		t.Fatalf("AcceptInvitation failed: %v", err)
+	}
+
+	after, err := f.collectionService.ListCollections(ctx, other.userID, *before.Stoken, 100, service.PrefetchMedium)
+	if err != nil {
+		t.Fatalf("ListCollections failed: %v", err)
+	}
+	for _, rm := range after.RemovedMemberships {
+		if rm.UID == f.collection.UID {
+			t.Errorf("Expected %s not to be reported removed after rejoining", f.collection.UID)
+		}
+	}
+	if len(after.Data) != 1 {
+		t.Errorf("Expected the rejoined collection back, got %+v", after.Data)
+	}
+}
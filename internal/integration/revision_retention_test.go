@@ -0,0 +1,102 @@
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/service"
+	"goatsync/internal/storage"
+)
+
+// TestRevisionPruning verifies that pruning keeps each item's newest
+// revisions, honours per-collection overrides and releases chunk references
+func TestRevisionPruning(t *testing.T) {
+	ctx := context.Background()
+	f := newItemFixture(t)
+	important := f.newCollection(t)
+
+	// Four revisions of one item in each collection, each with its own chunk
+	write := func(col *model.Collection) string {
+		t.Helper()
+		uid := randomUID(t)
+		for range 4 {
+			in := f.item(t, uid)
+			in.Content.Chunks = []service.ChunkIn{{UID: randomUID(t), Content: []byte(randomUID(t))}}
+			if err := f.itemService.BatchItems(ctx, col.UID, f.userID, "", &service.ItemBatchRequest{Items: []service.ItemBatchIn{in}}); err != nil {
+				t.Fatalf("BatchItems failed: %v", err)
+			}
+		}
+		return uid
+	}
+	itemUID := write(f.collection)
+	importantUID := write(important)
+
+	revisionRepo := repository.NewRevisionRepository(testDB)
+	retentionRepo := repository.NewRetentionRepository(testDB)
+	chunkRepo := repository.NewChunkRepository(testDB)
+	quotaService := service.NewQuotaService(repository.NewQuotaRepository(testDB), &config.Config{})
+	chunkService := service.NewChunkService(chunkRepo, f.collectionRepo, f.memberRepo, repository.NewTransactor(testDB), storage.NewFileStorage(t.TempDir()), quotaService)
+
+	// The important collection keeps everything
+	keepAll := 0
+	if err := retentionRepo.Set(ctx, &model.CollectionRetention{CollectionID: important.ID, KeepRevisions: &keepAll, KeepDays: &keepAll}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	cfg := &config.Config{RevisionKeepCount: 2}
+	pruner := service.NewRevisionPruner(revisionRepo, retentionRepo, chunkService, cfg)
+	if _, err := pruner.Run(ctx, false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	history := func(col *model.Collection, uid string) []model.CollectionItemRevision {
+		t.Helper()
+		var revisions []model.CollectionItemRevision
+		if err := testDB.Joins("JOIN django_collectionitem item ON item.id = django_collectionitemrevision.item_id").
+			Where("item.collection_id = ? AND item.uid = ?", col.ID, uid).
+			Order("django_collectionitemrevision.id DESC").
+			Preload("Chunks").
+			Find(&revisions).Error; err != nil {
+			t.Fatalf("Failed to load revisions: %v", err)
+		}
+		return revisions
+	}
+
+	revisions := history(f.collection, itemUID)
+	if len(revisions) != 2 || revisions[0].Current == nil || !*revisions[0].Current {
+		t.Fatalf("Expected the current revision and one more, got %d", len(revisions))
+	}
+	for _, rev := range revisions {
+		if len(rev.Chunks) != 1 {
+			t.Errorf("Kept revision %s lost its chunks", rev.UID)
+		}
+	}
+	if count := len(history(important, importantUID)); count != 4 {
+		t.Errorf("Expected the important collection to keep 4 revisions, got %d", count)
+	}
+
+	// No relation points at a pruned revision
+	var dangling int64
+	if err := testDB.Model(&model.RevisionChunkRelation{}).
+		Where("revision_id NOT IN (?)", testDB.Model(&model.CollectionItemRevision{}).Select("id")).
+		Count(&dangling).Error; err != nil {
+		t.Fatalf("Failed to count relations: %v", err)
+	}
+	if dangling != 0 {
+		t.Errorf("Found %d chunk references to pruned revisions", dangling)
+	}
+
+	// Removing the override puts the collection back on the default
+	if err := retentionRepo.Set(ctx, &model.CollectionRetention{CollectionID: important.ID}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := pruner.Run(ctx, false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if count := len(history(important, importantUID)); count != 2 {
+		t.Errorf("Expected the default policy to keep 2 revisions, got %d", count)
+	}
+}
@@ -0,0 +1,44 @@
+// Package jobs runs periodic background tasks inside the server process.
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Job is a task run on a fixed interval
+type Job struct {
+	Name     string
+	Interval time.Duration // Zero or negative disables the job
+	Run      func(ctx context.Context) error
+}
+
+// Start runs every enabled job in its own goroutine until ctx is cancelled.
+// The first run happens one interval after Start.
+func Start(ctx context.Context, jobs ...Job) {
+	for _, job := range jobs {
+		if job.Interval <= 0 {
+			log.Printf("Background job %s disabled", job.Name)
+			continue
+		}
+		log.Printf("Background job %s scheduled every %s", job.Name, job.Interval)
+		go run(ctx, job)
+	}
+}
+
+func run(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := job.Run(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Background job %s failed: %v", job.Name, err)
+			}
+		}
+	}
+}
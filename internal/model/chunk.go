@@ -1,5 +1,9 @@
 package model
 
+import (
+	"time"
+)
+
 // CollectionItemChunk represents a chunk of binary data for a collection item.
 // Large items are split into chunks for efficient transfer and storage.
 //
@@ -16,10 +20,16 @@ type CollectionItemChunk struct {
 	ID           uint   `gorm:"primaryKey"`
 	UID          string `gorm:"size:60;not null;index"`            // Chunk UID
 	CollectionID uint   `gorm:"not null;index"`                    // Foreign key to Collection
-	ChunkFile    string `gorm:"size:150;uniqueIndex;not null"`     // Path to chunk file
+	ChunkFile    string `gorm:"size:150;index;not null"`           // Store key of the chunk file (shared with identical chunks)
+	BlobID       *uint  `gorm:"index"`                             // Content-addressed blob (nil for chunks stored before deduplication)
+
+	// GoatSync extension: upload time, used to give in-flight uploads a grace
+	// period before garbage collection. Nil for chunks uploaded before it was added.
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
 
 	// Relations
 	Collection *Collection `gorm:"foreignKey:CollectionID;constraint:OnDelete:CASCADE"`
+	Blob       *ChunkBlob  `gorm:"foreignKey:BlobID;constraint:OnDelete:SET NULL"`
 }
 
 // TableName specifies the table name for GORM
@@ -53,3 +63,46 @@ func (RevisionChunkRelation) TableName() string {
 	return "django_revisionchunkrelation"
 }
 
+// ChunkBlob is a content-addressed chunk file shared by every chunk with the same bytes.
+// RefCount is the number of RevisionChunkRelation rows pointing at chunks backed by
+// this blob. Once it drops to zero the row goes, and the file is left to the chunk
+// garbage collector, which removes it after its grace period.
+//
+// GoatSync extension: there is no Django equivalent, the reference server stores
+// one file per chunk.
+type ChunkBlob struct {
+	ID        uint      `gorm:"primaryKey"`
+	Hash      string    `gorm:"size:64;uniqueIndex;not null"` // Hex-encoded SHA-256 of the content
+	Size      int64     `gorm:"not null"`                     // Content length in bytes
+	RefCount  int       `gorm:"not null;default:0"`           // Revision references to this blob
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for GORM
+func (ChunkBlob) TableName() string {
+	return "goatsync_chunkblob"
+}
+
+// ChunkUpload is an in-progress resumable chunk upload. The bytes received so
+// far are kept in the chunk store; Offset is the number of bytes safely stored.
+// Once complete, the upload is finalized into a CollectionItemChunk.
+//
+// GoatSync extension: there is no Django equivalent, stock Etebase clients
+// upload chunks in a single request.
+type ChunkUpload struct {
+	ID           uint      `gorm:"primaryKey"`
+	CollectionID uint      `gorm:"not null;uniqueIndex:idx_goatsync_chunkupload_chunk"`
+	ChunkUID     string    `gorm:"size:60;not null;uniqueIndex:idx_goatsync_chunkupload_chunk"`
+	UserID       uint      `gorm:"not null"`           // User who started the upload
+	Offset       int64     `gorm:"not null;default:0"` // Bytes received so far
+	ExpiresAt    time.Time `gorm:"not null;index"`     // Pushed back whenever data is received
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+
+	// Relations
+	Collection *Collection `gorm:"foreignKey:CollectionID;constraint:OnDelete:CASCADE"`
+}
+
+// TableName specifies the table name for GORM
+func (ChunkUpload) TableName() string {
+	return "goatsync_chunkupload"
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // CollectionType represents a type of collection owned by a user.
 // Users can have multiple collections of different types (calendar, contacts, etc.)
 //
@@ -36,6 +38,14 @@ type Collection struct {
 	OwnerID    uint   `gorm:"not null;index"`
 	MainItemID *uint  `gorm:"unique"` // Nullable, points to the "main" item
 
+	// GoatSync extension: when the owner deleted the collection. Removed
+	// collections have no members left and are purged after a grace period.
+	RemovedAt *time.Time `gorm:"index"`
+
+	// GoatSync extension: when the removed collection's contents were purged.
+	// The row is kept so its former members are still told it was removed.
+	PurgedAt *time.Time
+
 	// Relations
 	Owner    *User           `gorm:"foreignKey:OwnerID;constraint:OnDelete:CASCADE"`
 	MainItem *CollectionItem `gorm:"foreignKey:MainItemID;constraint:OnDelete:SET NULL"`
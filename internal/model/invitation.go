@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // CollectionInvitation represents an invitation to join a collection.
 // A member with admin access can invite other users to join a collection.
 //
@@ -17,12 +19,16 @@ package model
 //	        unique_together = ("user", "fromMember")
 type CollectionInvitation struct {
 	ID                  uint        `gorm:"primaryKey"`
-	UID                 string      `gorm:"size:43;not null;index"`           // Invitation UID
-	Version             uint16      `gorm:"default:1"`                        // Protocol version
-	FromMemberID        uint        `gorm:"not null;index"`                   // Foreign key to CollectionMember
-	UserID              uint        `gorm:"not null;index"`                   // User being invited
-	SignedEncryptionKey []byte      `gorm:"type:bytea;not null"`              // Signed encryption key
-	AccessLevel         AccessLevel `gorm:"default:0"`                        // Default: read-only
+	UID                 string      `gorm:"size:43;not null;index"`                                                // Invitation UID
+	Version             uint16      `gorm:"default:1"`                                                             // Protocol version
+	FromMemberID        uint        `gorm:"not null;index;uniqueIndex:idx_invitation_user_from_member,priority:2"` // Foreign key to CollectionMember
+	UserID              uint        `gorm:"not null;index;uniqueIndex:idx_invitation_user_from_member,priority:1"` // User being invited
+	SignedEncryptionKey []byte      `gorm:"type:bytea;not null"`                                                   // Signed encryption key
+	AccessLevel         AccessLevel `gorm:"default:0"`                                                             // Default: read-only
+
+	// GoatSync extension: when the invitation was sent, used to expire it.
+	// Invitations sent before it was added count from the upgrade.
+	CreatedAt time.Time `gorm:"autoCreateTime;default:CURRENT_TIMESTAMP"`
 
 	// Relations
 	FromMember *CollectionMember `gorm:"foreignKey:FromMemberID;constraint:OnDelete:CASCADE"`
@@ -0,0 +1,68 @@
+package model
+
+import "time"
+
+// QuotaResource is a resource whose per-user usage is limited
+type QuotaResource string
+
+const (
+	QuotaChunkBytes  QuotaResource = "chunk_bytes" // Total size of chunks in the user's collections
+	QuotaCollections QuotaResource = "collections" // Number of collections owned by the user
+	QuotaItems       QuotaResource = "items"       // Number of items (besides main items) in the user's collections
+)
+
+// UserQuota holds a user's quota overrides and current usage.
+// Usage is charged to the collection owner, including what other members
+// write to shared collections. Usage counters are kept up to date as
+// resources are created and deleted rather than recomputed.
+//
+// GoatSync extension: there is no Django equivalent.
+type UserQuota struct {
+	UserID uint `gorm:"primaryKey"`
+
+	// Per-user limits; nil uses the server default, 0 means unlimited
+	MaxChunkBytes  *int64
+	MaxCollections *int64
+	MaxItems       *int64
+
+	// Current usage
+	UsedChunkBytes  int64 `gorm:"not null;default:0"`
+	UsedCollections int64 `gorm:"not null;default:0"`
+	UsedItems       int64 `gorm:"not null;default:0"`
+
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+
+	// Relations
+	User *User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+// TableName specifies the table name for GORM
+func (UserQuota) TableName() string {
+	return "goatsync_userquota"
+}
+
+// Limit returns the user's override for resource, or nil if there is none
+func (q *UserQuota) Limit(resource QuotaResource) *int64 {
+	switch resource {
+	case QuotaChunkBytes:
+		return q.MaxChunkBytes
+	case QuotaCollections:
+		return q.MaxCollections
+	case QuotaItems:
+		return q.MaxItems
+	}
+	return nil
+}
+
+// Used returns the user's current usage of resource
+func (q *UserQuota) Used(resource QuotaResource) int64 {
+	switch resource {
+	case QuotaChunkBytes:
+		return q.UsedChunkBytes
+	case QuotaCollections:
+		return q.UsedCollections
+	case QuotaItems:
+		return q.UsedItems
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// CollectionRetention overrides the server's revision retention policy for
+// one collection, e.g. so an important collection keeps its full history.
+// A collection without a row follows the server default.
+//
+// GoatSync extension: there is no Django equivalent.
+type CollectionRetention struct {
+	CollectionID uint `gorm:"primaryKey"`
+
+	// Per-collection policy; nil uses the server default, 0 disables the rule
+	KeepRevisions *int // Newest revisions kept per item
+	KeepDays      *int // Revisions younger than this many days are kept
+
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+
+	// Relations
+	Collection *Collection `gorm:"foreignKey:CollectionID;constraint:OnDelete:CASCADE"`
+}
+
+// TableName specifies the table name for GORM
+func (CollectionRetention) TableName() string {
+	return "goatsync_collectionretention"
+}
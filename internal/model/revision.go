@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // CollectionItemRevision represents a revision of a collection item.
 // Items can have multiple revisions, but only one is marked as "current".
 //
@@ -15,15 +17,23 @@ package model
 //
 //	    class Meta:
 //	        unique_together = ("item", "current")
+//
+// GoatSync extension: idx_goatsync_revision_item_current_stoken covers the
+// lookup of an item's current revision stoken, so incremental item listings
+// don't have to visit the revisions themselves. CreatedAt is also ours.
 type CollectionItemRevision struct {
 	ID       uint   `gorm:"primaryKey"`
 	UID      string `gorm:"uniqueIndex;size:43;not null"`     // Revision UID
-	ItemID   uint   `gorm:"not null;index"`                   // Foreign key to CollectionItem
-	StokenID uint   `gorm:"unique;not null"`                  // One-to-one with Stoken
+	ItemID   uint   `gorm:"not null;index;uniqueIndex:idx_revision_item_current,priority:1;index:idx_goatsync_revision_item_current_stoken,priority:1"` // Foreign key to CollectionItem
+	StokenID uint   `gorm:"unique;not null;index:idx_goatsync_revision_item_current_stoken,priority:3"` // One-to-one with Stoken
 	Meta     []byte `gorm:"type:bytea;not null"`              // Encrypted metadata
-	Current  *bool  `gorm:"index"`                            // Nullable, only one can be true per item
+	Current  *bool  `gorm:"index;uniqueIndex:idx_revision_item_current,priority:2;index:idx_goatsync_revision_item_current_stoken,priority:2"` // Nullable, only one can be true per item
 	Deleted  bool   `gorm:"default:false"`                    // Soft delete flag
 
+	// GoatSync extension: creation time, used by the revision retention policy.
+	// Nil for revisions created before it was added.
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+
 	// Relations
 	Item   *CollectionItem `gorm:"foreignKey:ItemID;constraint:OnDelete:CASCADE"`
 	Stoken *Stoken         `gorm:"foreignKey:StokenID;constraint:OnDelete:RESTRICT"`
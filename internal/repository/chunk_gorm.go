@@ -3,10 +3,12 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"goatsync/internal/model"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // chunkRepository implements ChunkRepository using GORM
@@ -21,13 +23,14 @@ func NewChunkRepository(db *gorm.DB) ChunkRepository {
 
 // Create creates a new chunk
 func (r *chunkRepository) Create(ctx context.Context, chunk *model.CollectionItemChunk) error {
-	return r.db.WithContext(ctx).Create(chunk).Error
+	return dbFromContext(ctx, r.db).Create(chunk).Error
 }
 
 // GetByUID retrieves a chunk by UID within a collection
 func (r *chunkRepository) GetByUID(ctx context.Context, collectionID uint, uid string) (*model.CollectionItemChunk, error) {
 	var chunk model.CollectionItemChunk
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
+		Preload("Blob").
 		Where("collection_id = ? AND uid = ?", collectionID, uid).
 		First(&chunk).Error
 
@@ -37,8 +40,276 @@ func (r *chunkRepository) GetByUID(ctx context.Context, collectionID uint, uid s
 	return &chunk, err
 }
 
+// AddRevisionChunks links chunks to a revision and bumps their blobs' reference counts
+func (r *chunkRepository) AddRevisionChunks(ctx context.Context, revisionID uint, chunkIDs []uint) error {
+	if len(chunkIDs) == 0 {
+		return nil
+	}
+
+	return dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		// Insert one at a time so relation IDs keep the chunk order
+		for _, chunkID := range chunkIDs {
+			rel := &model.RevisionChunkRelation{ChunkID: chunkID, RevisionID: revisionID}
+			if err := tx.Create(rel).Error; err != nil {
+				return err
+			}
+		}
+
+		return adjustBlobRefs(tx, countIDs(chunkIDs), 1)
+	})
+}
+
+// ClaimBlob records a content-addressed blob if it's new and locks it until
+// the end of the transaction in ctx. ReleaseRevisionChunks locks blobs for
+// update before deleting them, so it waits until the claiming chunk is recorded.
+func (r *chunkRepository) ClaimBlob(ctx context.Context, blob *model.ChunkBlob) (bool, error) {
+	db := dbFromContext(ctx, r.db)
+
+	// Concurrent uploads of the same content race on the hash; let one of them win
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoNothing: true,
+	}).Create(blob)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if err := db.Clauses(clause.Locking{Strength: "SHARE"}).
+		Where("hash = ?", blob.Hash).
+		First(blob).Error; err != nil {
+		return false, err
+	}
+	return result.RowsAffected == 0, nil
+}
+
+// ReleaseRevisionChunks unlinks all chunks from the given revisions.
+// Files aren't deleted here: an upload of the same content may have found
+// the file and be about to record a new blob for it, so orphaned files are
+// only removed by the garbage collector once past its grace period.
+func (r *chunkRepository) ReleaseRevisionChunks(ctx context.Context, revisionIDs []uint) error {
+	if len(revisionIDs) == 0 {
+		return nil
+	}
+
+	return dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		var chunkIDs []uint
+		if err := tx.Model(&model.RevisionChunkRelation{}).
+			Where("revision_id IN ?", revisionIDs).
+			Pluck("chunk_id", &chunkIDs).Error; err != nil {
+			return err
+		}
+		if len(chunkIDs) == 0 {
+			return nil
+		}
+
+		if err := tx.Where("revision_id IN ?", revisionIDs).
+			Delete(&model.RevisionChunkRelation{}).Error; err != nil {
+			return err
+		}
+
+		refs := countIDs(chunkIDs)
+		if err := adjustBlobRefs(tx, refs, -1); err != nil {
+			return err
+		}
+
+		// Find the blobs that lost their last reference
+		releasedChunkIDs := make([]uint, 0, len(refs))
+		for id := range refs {
+			releasedChunkIDs = append(releasedChunkIDs, id)
+		}
+		var blobs []model.ChunkBlob
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("ref_count <= 0 AND id IN (?)",
+				tx.Model(&model.CollectionItemChunk{}).Select("blob_id").Where("id IN ?", releasedChunkIDs)).
+			Find(&blobs).Error; err != nil {
+			return err
+		}
+
+		for _, blob := range blobs {
+			// Drop the released chunks. A chunk that was uploaded but isn't
+			// referenced by any revision yet keeps the blob alive.
+			var dropIDs []uint
+			if err := tx.Model(&model.CollectionItemChunk{}).
+				Where("blob_id = ? AND id IN ?", blob.ID, releasedChunkIDs).
+				Pluck("id", &dropIDs).Error; err != nil {
+				return err
+			}
+			if err := deleteChunks(tx, dropIDs); err != nil {
+				return err
+			}
+
+			var remaining int64
+			if err := tx.Model(&model.CollectionItemChunk{}).
+				Where("blob_id = ?", blob.ID).
+				Count(&remaining).Error; err != nil {
+				return err
+			}
+			if remaining > 0 {
+				continue
+			}
+
+			if err := tx.Delete(&blob).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// unreferencedChunk matches chunks that no revision references
+const unreferencedChunk = "NOT EXISTS (SELECT 1 FROM django_revisionchunkrelation " +
+	"WHERE django_revisionchunkrelation.chunk_id = django_collectionitemchunk.id)"
+
+// ListUnreferenced lists chunks uploaded before createdBefore that no revision references
+func (r *chunkRepository) ListUnreferenced(ctx context.Context, createdBefore time.Time) ([]model.CollectionItemChunk, error) {
+	var chunks []model.CollectionItemChunk
+	err := dbFromContext(ctx, r.db).
+		Where(unreferencedChunk).
+		Where("created_at IS NULL OR created_at < ?", createdBefore).
+		Order("id ASC").
+		Find(&chunks).Error
+	return chunks, err
+}
+
+// DeleteUnreferenced deletes the given chunks if they are still unreferenced
+func (r *chunkRepository) DeleteUnreferenced(ctx context.Context, ids []uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var deleted int64
+	err := dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		// Re-check references: a revision may have picked a chunk up since it was listed
+		var stillUnreferenced []uint
+		if err := tx.Model(&model.CollectionItemChunk{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", ids).
+			Where(unreferencedChunk).
+			Pluck("id", &stillUnreferenced).Error; err != nil {
+			return err
+		}
+		if err := deleteChunks(tx, stillUnreferenced); err != nil {
+			return err
+		}
+		deleted = int64(len(stillUnreferenced))
+
+		return deleteUnusedBlobs(tx)
+	})
+	return deleted, err
+}
+
+// deleteUnusedBlobs deletes the blobs no revision references and no chunk holds.
+// Their files are left to the garbage collector.
+func deleteUnusedBlobs(tx *gorm.DB) error {
+	return tx.Where("ref_count <= 0").
+		Where("NOT EXISTS (SELECT 1 FROM django_collectionitemchunk WHERE django_collectionitemchunk.blob_id = goatsync_chunkblob.id)").
+		Delete(&model.ChunkBlob{}).Error
+}
+
+// FilesInUse returns which of the given chunk file locations are held by a chunk
+func (r *chunkRepository) FilesInUse(ctx context.Context, locations []string, ignoreIDs []uint) (map[string]bool, error) {
+	inUse := make(map[string]bool)
+	if len(locations) == 0 {
+		return inUse, nil
+	}
+
+	query := dbFromContext(ctx, r.db).
+		Model(&model.CollectionItemChunk{}).
+		Where("chunk_file IN ?", locations)
+	if len(ignoreIDs) > 0 {
+		query = query.Where("id NOT IN ?", ignoreIDs)
+	}
+
+	var files []string
+	if err := query.Distinct().Pluck("chunk_file", &files).Error; err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		inUse[f] = true
+	}
+	return inUse, nil
+}
+
+// BlobsInUse returns which of the given content hashes have a recorded blob
+func (r *chunkRepository) BlobsInUse(ctx context.Context, hashes []string, ignoreIDs []uint) (map[string]bool, error) {
+	inUse := make(map[string]bool)
+	if len(hashes) == 0 {
+		return inUse, nil
+	}
+
+	query := dbFromContext(ctx, r.db).
+		Model(&model.ChunkBlob{}).
+		Where("hash IN ?", hashes)
+	if len(ignoreIDs) > 0 {
+		// A blob only held by ignored chunks goes with them
+		query = query.Where("(NOT EXISTS (SELECT 1 FROM django_collectionitemchunk c "+
+			"WHERE c.blob_id = goatsync_chunkblob.id AND c.id IN ?) "+
+			"OR EXISTS (SELECT 1 FROM django_collectionitemchunk c "+
+			"WHERE c.blob_id = goatsync_chunkblob.id AND c.id NOT IN ?))", ignoreIDs, ignoreIDs)
+	}
+
+	var found []string
+	if err := query.Pluck("hash", &found).Error; err != nil {
+		return nil, err
+	}
+	for _, h := range found {
+		inUse[h] = true
+	}
+	return inUse, nil
+}
+
 // Delete deletes a chunk
 func (r *chunkRepository) Delete(ctx context.Context, id uint) error {
-	return r.db.WithContext(ctx).Delete(&model.CollectionItemChunk{}, id).Error
+	return dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		return deleteChunks(tx, []uint{id})
+	})
+}
+
+// deleteChunks deletes chunk rows, refunding their size to the collection owners' quotas
+func deleteChunks(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := refundChunkUsage(tx, ids); err != nil {
+		return err
+	}
+	return tx.Where("id IN ?", ids).Delete(&model.CollectionItemChunk{}).Error
 }
 
+// adjustBlobRefs changes the reference count of the blob behind each chunk
+// by sign times the number of references to that chunk
+func adjustBlobRefs(tx *gorm.DB, refs map[uint]int, sign int) error {
+	var chunks []model.CollectionItemChunk
+	ids := make([]uint, 0, len(refs))
+	for id := range refs {
+		ids = append(ids, id)
+	}
+	if err := tx.Select("id", "blob_id").
+		Where("id IN ? AND blob_id IS NOT NULL", ids).
+		Find(&chunks).Error; err != nil {
+		return err
+	}
+
+	perBlob := make(map[uint]int)
+	for _, chunk := range chunks {
+		perBlob[*chunk.BlobID] += refs[chunk.ID]
+	}
+
+	for blobID, n := range perBlob {
+		if err := tx.Model(&model.ChunkBlob{}).
+			Where("id = ?", blobID).
+			Update("ref_count", gorm.Expr("ref_count + ?", sign*n)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countIDs returns how many times each ID appears in ids
+func countIDs(ids []uint) map[uint]int {
+	counts := make(map[uint]int, len(ids))
+	for _, id := range ids {
+		counts[id]++
+	}
+	return counts
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"goatsync/internal/model"
+	pkgerrors "goatsync/pkg/errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// chunkUploadRepository implements ChunkUploadRepository using GORM
+type chunkUploadRepository struct {
+	db *gorm.DB
+}
+
+// NewChunkUploadRepository creates a new resumable upload repository
+func NewChunkUploadRepository(db *gorm.DB) ChunkUploadRepository {
+	return &chunkUploadRepository{db: db}
+}
+
+// Create creates a new upload.
+// The unique index on the collection and chunk UID catches concurrent uploads
+// of the same chunk.
+func (r *chunkUploadRepository) Create(ctx context.Context, upload *model.ChunkUpload) error {
+	err := dbFromContext(ctx, r.db).Create(upload).Error
+	if isUniqueViolation(err) {
+		return pkgerrors.ErrChunkExists.WithDetail("Chunk is already being uploaded.")
+	}
+	return err
+}
+
+// GetByChunk retrieves the upload of a chunk UID within a collection
+func (r *chunkUploadRepository) GetByChunk(ctx context.Context, collectionID uint, chunkUID string) (*model.ChunkUpload, error) {
+	var upload model.ChunkUpload
+	err := dbFromContext(ctx, r.db).
+		Where("collection_id = ? AND chunk_uid = ?", collectionID, chunkUID).
+		First(&upload).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &upload, err
+}
+
+// LockForWrite locks the upload row until the end of the transaction in ctx
+func (r *chunkUploadRepository) LockForWrite(ctx context.Context, id uint) error {
+	var upload model.ChunkUpload
+	err := dbFromContext(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		First(&upload, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Advance moves an upload's offset from one position to another
+func (r *chunkUploadRepository) Advance(ctx context.Context, id uint, from, to int64, expiresAt time.Time) (bool, error) {
+	result := dbFromContext(ctx, r.db).
+		Model(&model.ChunkUpload{}).
+		Where("id = ? AND \"offset\" = ?", id, from).
+		Updates(map[string]interface{}{
+			"offset":     to,
+			"expires_at": expiresAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ListExpired lists uploads that expired before the given time
+func (r *chunkUploadRepository) ListExpired(ctx context.Context, before time.Time) ([]model.ChunkUpload, error) {
+	var uploads []model.ChunkUpload
+	err := dbFromContext(ctx, r.db).
+		Where("expires_at < ?", before).
+		Order("id ASC").
+		Find(&uploads).Error
+	return uploads, err
+}
+
+// Delete deletes an upload
+func (r *chunkUploadRepository) Delete(ctx context.Context, id uint) error {
+	return dbFromContext(ctx, r.db).Delete(&model.ChunkUpload{}, id).Error
+}
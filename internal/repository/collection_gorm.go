@@ -3,10 +3,12 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"goatsync/internal/model"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // collectionRepository implements CollectionRepository using GORM
@@ -27,13 +29,13 @@ func NewCollectionRepository(db *gorm.DB) CollectionRepository {
 
 // Create creates a new collection
 func (r *collectionRepository) Create(ctx context.Context, collection *model.Collection) error {
-	return r.db.WithContext(ctx).Create(collection).Error
+	return dbFromContext(ctx, r.db).Create(collection).Error
 }
 
 // GetByID retrieves a collection by ID
 func (r *collectionRepository) GetByID(ctx context.Context, id uint) (*model.Collection, error) {
 	var collection model.Collection
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("MainItem").
 		Preload("MainItem.Revisions", "current = ?", true).
 		First(&collection, id).Error
@@ -50,7 +52,7 @@ func (r *collectionRepository) GetByID(ctx context.Context, id uint) (*model.Col
 // GetByUID retrieves a collection by UID
 func (r *collectionRepository) GetByUID(ctx context.Context, uid string) (*model.Collection, error) {
 	var collection model.Collection
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("MainItem").
 		Preload("MainItem.Revisions", "current = ?", true).
 		Where("uid = ?", uid).
@@ -65,6 +67,36 @@ func (r *collectionRepository) GetByUID(ctx context.Context, uid string) (*model
 	return &collection, nil
 }
 
+// GetForUser retrieves a collection by UID if the user is a member of it
+func (r *collectionRepository) GetForUser(ctx context.Context, userID uint, uid string) (*model.Collection, error) {
+	var collection model.Collection
+	err := dbFromContext(ctx, r.db).
+		Scopes(preloadForUser(userID)).
+		Joins("JOIN django_collectionmember ON django_collectionmember.collection_id = django_collection.id").
+		Where("django_collectionmember.user_id = ? AND django_collection.uid = ?", userID, uid).
+		First(&collection).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+// preloadForUser preloads what collection responses are built from: the main
+// item's current revision and the user's own membership with its type
+func preloadForUser(userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.
+			Preload("MainItem").
+			Scopes(preloadCurrentRevision("MainItem.Revisions")).
+			Preload("Members", "user_id = ?", userID).
+			Preload("Members.CollectionType")
+	}
+}
+
 // ListForUser lists collections for a user with pagination using stoken
 //
 // This implements the stoken-based pagination:
@@ -77,6 +109,63 @@ func (r *collectionRepository) ListForUser(
 	userID uint,
 	stokenUID string,
 	limit int,
+) (collections []model.Collection, newStoken *model.Stoken, done bool, err error) {
+	// Build query: collections where user is a member
+	query := dbFromContext(ctx, r.db).
+		Model(&model.Collection{}).
+		Joins("JOIN django_collectionmember ON django_collectionmember.collection_id = django_collection.id").
+		Where("django_collectionmember.user_id = ?", userID)
+
+	return r.listByStoken(ctx, userID, query, stokenUID, limit)
+}
+
+// ListByTypes lists collections filtered by collection types
+func (r *collectionRepository) ListByTypes(
+	ctx context.Context,
+	userID uint,
+	typeUIDs [][]byte,
+	stokenUID string,
+	limit int,
+) (collections []model.Collection, newStoken *model.Stoken, done bool, err error) {
+	// Similar to ListForUser but with additional type filter
+	query := dbFromContext(ctx, r.db).
+		Model(&model.Collection{}).
+		Joins("JOIN django_collectionmember ON django_collectionmember.collection_id = django_collection.id").
+		Where("django_collectionmember.user_id = ?", userID)
+
+	// Filter by collection types if provided
+	if len(typeUIDs) > 0 {
+		query = query.
+			Joins("JOIN django_collectiontype ON django_collectiontype.id = django_collectionmember.collection_type_id").
+			Where("django_collectiontype.uid IN ?", typeUIDs)
+	}
+
+	return r.listByStoken(ctx, userID, query, stokenUID, limit)
+}
+
+// collectionStoken is a collection ID annotated with the collection's max stoken
+type collectionStoken struct {
+	ID        uint
+	MaxStoken uint
+}
+
+// listByStoken pages through the collections matched by query, which must
+// join the user's django_collectionmember row, in stoken order.
+//
+// Each collection is annotated with the newest stoken among its item revisions
+// and the user's membership. Only collections changed after stokenUID are
+// returned, and the new stoken is the newest one on the page, so following it
+// continues exactly where the page ended. Nothing is written.
+//
+// Django equivalent:
+//
+//	filter_by_stoken_and_limit(stoken, limit, queryset, Collection.stoken_annotation)
+func (r *collectionRepository) listByStoken(
+	ctx context.Context,
+	userID uint,
+	query *gorm.DB,
+	stokenUID string,
+	limit int,
 ) (collections []model.Collection, newStoken *model.Stoken, done bool, err error) {
 	// Default limit
 	if limit <= 0 {
@@ -92,145 +181,269 @@ func (r *collectionRepository) ListForUser(
 		}
 	}
 
-	// Build query: collections where user is a member
 	// Annotate with max stoken from items.revisions and members
 	//
 	// Django equivalent:
 	//   stoken_annotation = stoken_annotation_builder(["items__revisions__stoken", "members__stoken"])
-	//
-	// We use a raw SQL subquery to calculate the max stoken
-	query := r.db.WithContext(ctx).
-		Model(&model.Collection{}).
-		Joins("JOIN django_collectionmember ON django_collectionmember.collection_id = django_collection.id").
-		Where("django_collectionmember.user_id = ?", userID).
-		Preload("MainItem").
-		Preload("MainItem.Revisions", "current = ?", true)
+	const maxStoken = "GREATEST(" +
+		"COALESCE(MAX(django_collectionitemrevision.stoken_id), 0), " +
+		"COALESCE(MAX(django_collectionmember.stoken_id), 0))"
+	query = query.
+		Select("django_collection.id, " + maxStoken + " AS max_stoken").
+		Joins("LEFT JOIN django_collectionitem ON django_collectionitem.collection_id = django_collection.id").
+		Joins("LEFT JOIN django_collectionitemrevision ON django_collectionitemrevision.item_id = django_collectionitem.id").
+		Group("django_collection.id")
 
 	// Add stoken filter if we have one
 	if stokenObj != nil {
-		// Filter collections where max(revision.stoken_id, member.stoken_id) > stokenObj.ID
-		query = query.
-			Joins("LEFT JOIN django_collectionitem ON django_collectionitem.collection_id = django_collection.id").
-			Joins("LEFT JOIN django_collectionitemrevision ON django_collectionitemrevision.item_id = django_collectionitem.id").
-			Group("django_collection.id").
-			Having("COALESCE(MAX(django_collectionitemrevision.stoken_id), 0) > ? OR COALESCE(MAX(django_collectionmember.stoken_id), 0) > ?",
-				stokenObj.ID, stokenObj.ID)
+		query = query.Having(maxStoken+" > ?", stokenObj.ID)
 	}
 
 	// Fetch limit+1 to check if done
+	var rows []collectionStoken
 	err = query.
-		Order("django_collection.id ASC").
+		Order("max_stoken ASC, django_collection.id ASC").
 		Limit(limit + 1).
-		Find(&collections).Error
-
+		Scan(&rows).Error
 	if err != nil {
 		return nil, nil, false, err
 	}
 
 	// Check if we have more results
-	if len(collections) > limit {
-		collections = collections[:limit]
+	if len(rows) > limit {
+		rows = rows[:limit]
 		done = false
 	} else {
 		done = true
 	}
 
-	// Get new stoken from the last result
-	if len(collections) > 0 {
-		// In a real implementation, we'd calculate the max stoken from the results
-		// For now, we create a new stoken to represent the current state
-		newStoken, err = r.stokenRepo.Create(ctx)
-		if err != nil {
-			return nil, nil, false, err
-		}
+	// Nothing changed: the client keeps its stoken
+	if len(rows) == 0 {
+		return nil, stokenObj, done, nil
 	}
 
-	return collections, newStoken, done, nil
-}
-
-// ListByTypes lists collections filtered by collection types
-func (r *collectionRepository) ListByTypes(
-	ctx context.Context,
-	userID uint,
-	typeUIDs [][]byte,
-	stokenUID string,
-	limit int,
-) (collections []model.Collection, newStoken *model.Stoken, done bool, err error) {
-	// Similar to ListForUser but with additional type filter
-	if limit <= 0 {
-		limit = 50
+	// Load the page in stoken order
+	ids := make([]uint, len(rows))
+	var pageMax uint
+	for i, row := range rows {
+		ids[i] = row.ID
+		pageMax = max(pageMax, row.MaxStoken)
 	}
 
-	// Get stoken object if provided
-	var stokenObj *model.Stoken
-	if stokenUID != "" {
-		stokenObj, err = r.stokenRepo.GetByUID(ctx, stokenUID)
-		if err != nil {
-			return nil, nil, false, err
-		}
+	var loaded []model.Collection
+	err = dbFromContext(ctx, r.db).
+		Scopes(preloadForUser(userID)).
+		Where("id IN ?", ids).
+		Find(&loaded).Error
+	if err != nil {
+		return nil, nil, false, err
 	}
 
-	// Build query with type filter
-	query := r.db.WithContext(ctx).
-		Model(&model.Collection{}).
-		Joins("JOIN django_collectionmember ON django_collectionmember.collection_id = django_collection.id").
-		Where("django_collectionmember.user_id = ?", userID).
-		Preload("MainItem").
-		Preload("MainItem.Revisions", "current = ?", true)
-
-	// Filter by collection types if provided
-	if len(typeUIDs) > 0 {
-		query = query.
-			Joins("JOIN django_collectiontype ON django_collectiontype.id = django_collectionmember.collection_type_id").
-			Where("django_collectiontype.uid IN ?", typeUIDs)
+	byID := make(map[uint]model.Collection, len(loaded))
+	for _, col := range loaded {
+		byID[col.ID] = col
+	}
+	for _, id := range ids {
+		if col, ok := byID[id]; ok {
+			collections = append(collections, col)
+		}
 	}
 
-	// Add stoken filter
-	if stokenObj != nil {
-		query = query.
-			Joins("LEFT JOIN django_collectionitem ON django_collectionitem.collection_id = django_collection.id").
-			Joins("LEFT JOIN django_collectionitemrevision ON django_collectionitemrevision.item_id = django_collectionitem.id").
-			Group("django_collection.id").
-			Having("COALESCE(MAX(django_collectionitemrevision.stoken_id), 0) > ? OR COALESCE(MAX(django_collectionmember.stoken_id), 0) > ?",
-				stokenObj.ID, stokenObj.ID)
+	newStoken, err = r.stokenFilter.GetNewStoken(ctx, pageMax)
+	if err != nil {
+		return nil, nil, false, err
 	}
+	return collections, newStoken, done, nil
+}
 
-	// Fetch limit+1 to check if done
-	err = query.
-		Order("django_collection.id ASC").
-		Limit(limit + 1).
-		Find(&collections).Error
+// LockForWrite locks the collection row until the end of the transaction in ctx
+func (r *collectionRepository) LockForWrite(ctx context.Context, id uint) error {
+	var collection model.Collection
+	return dbFromContext(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		First(&collection, id).Error
+}
 
+// GetStoken returns the collection's current stoken, the newest stoken among
+// its item revisions and memberships.
+//
+// Django equivalent:
+//
+//	Collection.stoken, annotated with stoken_annotation_builder(["items__revisions__stoken", "members__stoken"])
+func (r *collectionRepository) GetStoken(ctx context.Context, id uint) (*model.Stoken, error) {
+	stokens, err := r.GetStokens(ctx, []uint{id})
 	if err != nil {
-		return nil, nil, false, err
+		return nil, err
 	}
+	return stokens[id], nil
+}
 
-	// Check if we have more results
-	if len(collections) > limit {
-		collections = collections[:limit]
-		done = false
-	} else {
-		done = true
+// GetStokens returns the current stokens of several collections, in two
+// queries however many there are
+func (r *collectionRepository) GetStokens(ctx context.Context, ids []uint) (map[uint]*model.Stoken, error) {
+	stokens := make(map[uint]*model.Stoken, len(ids))
+	if len(ids) == 0 {
+		return stokens, nil
+	}
+	db := dbFromContext(ctx, r.db)
+
+	var rows []collectionStoken
+	err := db.Raw(`SELECT id, COALESCE(MAX(stoken_id), 0) AS max_stoken FROM (
+			SELECT django_collectionitem.collection_id AS id, django_collectionitemrevision.stoken_id
+			FROM django_collectionitemrevision
+			JOIN django_collectionitem ON django_collectionitem.id = django_collectionitemrevision.item_id
+			WHERE django_collectionitem.collection_id IN ?
+			UNION ALL
+			SELECT collection_id AS id, stoken_id FROM django_collectionmember WHERE collection_id IN ?
+		) AS collection_stokens
+		GROUP BY id`, ids, ids).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
 	}
 
-	// Get new stoken
-	if len(collections) > 0 {
-		newStoken, err = r.stokenRepo.Create(ctx)
-		if err != nil {
-			return nil, nil, false, err
+	stokenIDs := make([]uint, 0, len(rows))
+	for _, row := range rows {
+		if row.MaxStoken != 0 {
+			stokenIDs = append(stokenIDs, row.MaxStoken)
 		}
 	}
+	if len(stokenIDs) == 0 {
+		return stokens, nil
+	}
 
-	return collections, newStoken, done, nil
+	var loaded []model.Stoken
+	if err := db.Where("id IN ?", stokenIDs).Find(&loaded).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*model.Stoken, len(loaded))
+	for i := range loaded {
+		byID[loaded[i].ID] = &loaded[i]
+	}
+	for _, row := range rows {
+		if stoken, ok := byID[row.MaxStoken]; ok {
+			stokens[row.ID] = stoken
+		}
+	}
+	return stokens, nil
 }
 
 // Update updates an existing collection
 func (r *collectionRepository) Update(ctx context.Context, collection *model.Collection) error {
-	return r.db.WithContext(ctx).Save(collection).Error
+	return dbFromContext(ctx, r.db).Save(collection).Error
+}
+
+// TransferOwnership makes userID the owner of the collection. Collection
+// types are per user, so the new owner's membership only changes type if it
+// points at someone else's that no other membership uses, which then
+// becomes theirs.
+func (r *collectionRepository) TransferOwnership(ctx context.Context, id, userID uint) error {
+	return dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Collection{}).
+			Where("id = ?", id).
+			Update("owner_id", userID).Error; err != nil {
+			return err
+		}
+
+		typeID := tx.Model(&model.CollectionMember{}).
+			Select("collection_type_id").
+			Where("collection_id = ? AND user_id = ?", id, userID)
+		shared := tx.Model(&model.CollectionMember{}).
+			Select("1").
+			Where("collection_type_id = django_collectiontype.id AND user_id <> ?", userID)
+		return tx.Model(&model.CollectionType{}).
+			Where("id IN (?) AND owner_id <> ?", typeID, userID).
+			Where("NOT EXISTS (?)", shared).
+			Update("owner_id", userID).Error
+	})
+}
+
+// MarkRemoved records that the collection's owner deleted it
+func (r *collectionRepository) MarkRemoved(ctx context.Context, id uint, at time.Time) error {
+	return dbFromContext(ctx, r.db).
+		Model(&model.Collection{}).
+		Where("id = ?", id).
+		Update("removed_at", at).Error
+}
+
+// ListRemoved lists collections removed before the given time. Unfinished
+// uploads keep their collection until the upload cleanup removes them with
+// their data.
+func (r *collectionRepository) ListRemoved(ctx context.Context, before time.Time) ([]model.Collection, error) {
+	var collections []model.Collection
+	err := dbFromContext(ctx, r.db).
+		Where("removed_at < ? AND purged_at IS NULL", before).
+		Where("NOT EXISTS (SELECT 1 FROM goatsync_chunkupload WHERE goatsync_chunkupload.collection_id = django_collection.id)").
+		Order("removed_at ASC, id ASC").
+		Find(&collections).Error
+	return collections, err
+}
+
+// Purge deletes everything left in a removed collection, children first.
+// Stokens are kept, like when revisions are pruned, and so are the collection
+// row and its membership removals, which clients syncing from before the
+// removal still need.
+func (r *collectionRepository) Purge(ctx context.Context, id uint) (int64, error) {
+	var items int64
+	err := dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		var collection model.Collection
+		if err := tx.Select("id", "main_item_id").First(&collection, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&collection).Update("main_item_id", nil).Error; err != nil {
+			return err
+		}
+
+		itemIDs := tx.Model(&model.CollectionItem{}).Select("id").Where("collection_id = ?", id)
+		if err := tx.Where("item_id IN (?)", itemIDs).Delete(&model.CollectionItemRevision{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("collection_id = ?", id).Delete(&model.CollectionItem{})
+		if result.Error != nil {
+			return result.Error
+		}
+		items = result.RowsAffected
+		if collection.MainItemID != nil && items > 0 {
+			items--
+		}
+
+		// Chunks still backed by blobs other collections share are left with
+		// them; the rest of the files are collected as orphans
+		var chunkIDs []uint
+		if err := tx.Model(&model.CollectionItemChunk{}).
+			Where("collection_id = ?", id).
+			Pluck("id", &chunkIDs).Error; err != nil {
+			return err
+		}
+		if err := deleteChunks(tx, chunkIDs); err != nil {
+			return err
+		}
+		if err := deleteUnusedBlobs(tx); err != nil {
+			return err
+		}
+
+		if err := tx.Where("from_member_id IN (?)",
+			tx.Model(&model.CollectionMember{}).Select("id").Where("collection_id = ?", id)).
+			Delete(&model.CollectionInvitation{}).Error; err != nil {
+			return err
+		}
+		for _, rows := range []any{
+			&model.CollectionMember{},
+			&model.CollectionRetention{},
+		} {
+			if err := tx.Where("collection_id = ?", id).Delete(rows).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&collection).Update("purged_at", time.Now()).Error
+	})
+	return items, err
 }
 
 // Delete deletes a collection
 func (r *collectionRepository) Delete(ctx context.Context, id uint) error {
-	return r.db.WithContext(ctx).Delete(&model.Collection{}, id).Error
+	return dbFromContext(ctx, r.db).Delete(&model.Collection{}, id).Error
 }
 
@@ -21,13 +21,13 @@ func NewCollectionTypeRepository(db *gorm.DB) CollectionTypeRepository {
 
 // Create creates a new collection type
 func (r *collectionTypeRepository) Create(ctx context.Context, colType *model.CollectionType) error {
-	return r.db.WithContext(ctx).Create(colType).Error
+	return dbFromContext(ctx, r.db).Create(colType).Error
 }
 
 // GetByUID retrieves a collection type by UID
 func (r *collectionTypeRepository) GetByUID(ctx context.Context, uid []byte) (*model.CollectionType, error) {
 	var colType model.CollectionType
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Where("uid = ?", uid).
 		First(&colType).Error
 
@@ -40,7 +40,7 @@ func (r *collectionTypeRepository) GetByUID(ctx context.Context, uid []byte) (*m
 // GetOrCreate gets an existing type or creates a new one
 func (r *collectionTypeRepository) GetOrCreate(ctx context.Context, ownerID uint, uid []byte) (*model.CollectionType, error) {
 	var colType model.CollectionType
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Where("owner_id = ? AND uid = ?", ownerID, uid).
 		First(&colType).Error
 
@@ -49,7 +49,7 @@ func (r *collectionTypeRepository) GetOrCreate(ctx context.Context, ownerID uint
 			OwnerID: ownerID,
 			UID:     uid,
 		}
-		if err := r.db.WithContext(ctx).Create(&colType).Error; err != nil {
+		if err := dbFromContext(ctx, r.db).Create(&colType).Error; err != nil {
 			return nil, err
 		}
 		return &colType, nil
@@ -5,6 +5,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"goatsync/internal/model"
 )
@@ -71,7 +72,12 @@ type CollectionRepository interface {
 	// GetByUID retrieves a collection by UID
 	GetByUID(ctx context.Context, uid string) (*model.Collection, error)
 
-	// ListForUser lists collections for a user with pagination
+	// GetForUser retrieves a collection by UID with the main item's current
+	// revision and the user's membership (as its only Members entry) preloaded,
+	// or nil if the user isn't a member
+	GetForUser(ctx context.Context, userID uint, uid string) (*model.Collection, error)
+
+	// ListForUser lists collections for a user with pagination, preloaded like GetForUser
 	ListForUser(ctx context.Context, userID uint, stoken string, limit int) (
 		collections []model.Collection,
 		newStoken *model.Stoken,
@@ -79,7 +85,7 @@ type CollectionRepository interface {
 		err error,
 	)
 
-	// ListByTypes lists collections filtered by collection types
+	// ListByTypes lists collections filtered by collection types, preloaded like GetForUser
 	ListByTypes(ctx context.Context, userID uint, typeUIDs [][]byte, stoken string, limit int) (
 		collections []model.Collection,
 		newStoken *model.Stoken,
@@ -87,9 +93,41 @@ type CollectionRepository interface {
 		err error,
 	)
 
+	// LockForWrite locks the collection until the end of the transaction in ctx,
+	// so writers to the same collection take turns
+	LockForWrite(ctx context.Context, id uint) error
+
+	// GetStoken returns the collection's current stoken (the newest stoken of
+	// its item revisions and memberships), or nil if it has none
+	GetStoken(ctx context.Context, id uint) (*model.Stoken, error)
+
+	// GetStokens returns the current stokens of several collections by ID;
+	// collections without one are left out
+	GetStokens(ctx context.Context, ids []uint) (map[uint]*model.Stoken, error)
+
 	// Update updates an existing collection
 	Update(ctx context.Context, collection *model.Collection) error
 
+	// TransferOwnership makes userID the owner of the collection. The new
+	// owner's membership is moved to a collection type of theirs if it was
+	// left with one owned by someone else, unless other members share it.
+	TransferOwnership(ctx context.Context, id, userID uint) error
+
+	// MarkRemoved records that the collection's owner deleted it at the given time
+	MarkRemoved(ctx context.Context, id uint, at time.Time) error
+
+	// ListRemoved lists collections removed before the given time and not yet
+	// purged, oldest first. Collections with unfinished chunk uploads are left
+	// out until those expire.
+	ListRemoved(ctx context.Context, before time.Time) ([]model.Collection, error)
+
+	// Purge deletes everything left in a removed collection and marks it purged.
+	// The collection row and its membership removals are kept, so members'
+	// devices that were offline are still told to drop it.
+	// The chunks of its revisions must have been released first.
+	// Returns the number of items deleted, not counting the main item.
+	Purge(ctx context.Context, id uint) (items int64, err error)
+
 	// Delete deletes a collection
 	Delete(ctx context.Context, id uint) error
 }
@@ -105,7 +143,8 @@ type ItemRepository interface {
 	// GetByUID retrieves an item by UID within a collection
 	GetByUID(ctx context.Context, collectionID uint, uid string) (*model.CollectionItem, error)
 
-	// ListForCollection lists items for a collection with pagination
+	// ListForCollection lists the items of a collection whose current revision
+	// changed after stoken, in stoken order, with the stoken to continue from
 	ListForCollection(ctx context.Context, collectionID uint, stoken string, limit int) (
 		items []model.CollectionItem,
 		newStoken *model.Stoken,
@@ -131,13 +170,37 @@ type RevisionRepository interface {
 	// GetCurrentForItem retrieves the current revision for an item
 	GetCurrentForItem(ctx context.Context, itemID uint) (*model.CollectionItemRevision, error)
 
-	// ListForItem lists all revisions for an item
-	ListForItem(ctx context.Context, itemID uint, limit int) ([]model.CollectionItemRevision, error)
+	// ListForItem lists an item's revisions newest first, starting after the
+	// revision with ID beforeID (0 starts from the newest). done is true when
+	// no older revisions remain.
+	ListForItem(ctx context.Context, itemID uint, beforeID uint, limit int) (revisions []model.CollectionItemRevision, done bool, err error)
+
+	// ListPrunable lists the IDs of non-current revisions that filter lets go, in ID order
+	ListPrunable(ctx context.Context, filter PruneFilter) ([]uint, error)
+
+	// ListIDsForCollection lists the IDs of the collection's revisions after
+	// afterID, in ID order
+	ListIDsForCollection(ctx context.Context, collectionID, afterID uint, limit int) ([]uint, error)
+
+	// DeleteNonCurrent deletes the given revisions, skipping any that are current.
+	// Their stokens are kept, since clients may still hold them.
+	DeleteNonCurrent(ctx context.Context, ids []uint) (int64, error)
+}
+
+// PruneFilter selects revisions a retention policy no longer keeps.
+// A revision is kept if either rule keeps it.
+type PruneFilter struct {
+	CollectionID  uint      // 0 selects every collection without a retention override
+	KeepRevisions int       // Newest revisions kept per item; 0 disables the rule
+	CreatedBefore time.Time // Only revisions created before this are let go; zero disables the rule
+	AfterID       uint      // Only revisions with a larger ID, for paging
+	Limit         int
 }
 
 // MemberRepository defines the interface for collection member data access.
 type MemberRepository interface {
-	// Create creates a new collection member
+	// Create creates a new collection member, deleting the record of the
+	// user's earlier removal from the collection if there is one
 	Create(ctx context.Context, member *model.CollectionMember) error
 
 	// GetByID retrieves a member by ID
@@ -158,13 +221,15 @@ type MemberRepository interface {
 	// Delete removes a member from a collection (also creates MemberRemoved record)
 	Delete(ctx context.Context, id uint) error
 
-	// GetRemovedMemberships lists removed memberships for a user since a stoken
+	// GetRemovedMemberships lists removed memberships for a user since a stoken,
+	// oldest first, with their collection and stoken preloaded
 	GetRemovedMemberships(ctx context.Context, userID uint, stoken string) ([]model.CollectionMemberRemoved, error)
 }
 
 // InvitationRepository defines the interface for invitation data access.
 type InvitationRepository interface {
-	// Create creates a new invitation
+	// Create creates a new invitation. Returns ErrInvitationExists if the
+	// user already has an invitation from the same member.
 	Create(ctx context.Context, invitation *model.CollectionInvitation) error
 
 	// GetByID retrieves an invitation by ID
@@ -173,6 +238,9 @@ type InvitationRepository interface {
 	// GetByUID retrieves an invitation by UID
 	GetByUID(ctx context.Context, uid string) (*model.CollectionInvitation, error)
 
+	// GetPending retrieves the invitation a member sent to a user, if any
+	GetPending(ctx context.Context, userID, fromMemberID uint) (*model.CollectionInvitation, error)
+
 	// ListIncoming lists incoming invitations for a user
 	ListIncoming(ctx context.Context, userID uint) ([]model.CollectionInvitation, error)
 
@@ -182,25 +250,125 @@ type InvitationRepository interface {
 	// ListOutgoingByUser lists all outgoing invitations sent by a user
 	ListOutgoingByUser(ctx context.Context, userID uint) ([]model.CollectionInvitation, error)
 
+	// Update updates an existing invitation
+	Update(ctx context.Context, invitation *model.CollectionInvitation) error
+
 	// Delete deletes an invitation
 	Delete(ctx context.Context, id uint) error
 
 	// DeleteForCollection deletes all invitations for a collection
 	DeleteForCollection(ctx context.Context, collectionID uint) error
+
+	// DeleteFromMember deletes the invitations a member sent
+	DeleteFromMember(ctx context.Context, memberID uint) error
+
+	// DeleteCreatedBefore deletes the invitations sent before the given time
+	DeleteCreatedBefore(ctx context.Context, before time.Time) (int64, error)
 }
 
 // ChunkRepository defines the interface for chunk data access.
+// Deleting chunks refunds their size to the collection owner's chunk quota.
 type ChunkRepository interface {
 	// Create creates a new chunk
 	Create(ctx context.Context, chunk *model.CollectionItemChunk) error
 
-	// GetByUID retrieves a chunk by UID within a collection
+	// GetByUID retrieves a chunk by UID within a collection, along with its blob
 	GetByUID(ctx context.Context, collectionID uint, uid string) (*model.CollectionItemChunk, error)
 
+	// AddRevisionChunks links chunks to a revision in order and bumps their blobs' reference counts
+	AddRevisionChunks(ctx context.Context, revisionID uint, chunkIDs []uint) error
+
+	// ClaimBlob records the blob with blob.Hash if it's new, filling in blob,
+	// and locks it until the end of the transaction in ctx so releasing its
+	// last chunk can't delete it meanwhile. Returns whether it was recorded already.
+	ClaimBlob(ctx context.Context, blob *model.ChunkBlob) (bool, error)
+
+	// ReleaseRevisionChunks unlinks all chunks from the given revisions.
+	// Blobs whose last reference went away are deleted along with their
+	// chunks; their files are left to the chunk garbage collector.
+	ReleaseRevisionChunks(ctx context.Context, revisionIDs []uint) error
+
+	// ListUnreferenced lists chunks uploaded before createdBefore that no revision references
+	ListUnreferenced(ctx context.Context, createdBefore time.Time) ([]model.CollectionItemChunk, error)
+
+	// DeleteUnreferenced deletes the given chunks that are still not referenced by any
+	// revision, along with blobs left without chunks. Returns the number of chunks deleted.
+	DeleteUnreferenced(ctx context.Context, ids []uint) (int64, error)
+
+	// FilesInUse returns which of the given chunk file locations are held by a chunk,
+	// not counting the chunks in ignoreIDs
+	FilesInUse(ctx context.Context, locations []string, ignoreIDs []uint) (map[string]bool, error)
+
+	// BlobsInUse returns which of the given content hashes have a recorded blob,
+	// not counting blobs only held by the chunks in ignoreIDs
+	BlobsInUse(ctx context.Context, hashes []string, ignoreIDs []uint) (map[string]bool, error)
+
 	// Delete deletes a chunk
 	Delete(ctx context.Context, id uint) error
 }
 
+// ChunkUploadRepository defines the interface for resumable chunk upload data access.
+type ChunkUploadRepository interface {
+	// Create creates a new upload.
+	// Returns ErrChunkExists if the chunk already has an upload.
+	Create(ctx context.Context, upload *model.ChunkUpload) error
+
+	// GetByChunk retrieves the upload of a chunk UID within a collection
+	GetByChunk(ctx context.Context, collectionID uint, chunkUID string) (*model.ChunkUpload, error)
+
+	// LockForWrite locks the upload until the end of the transaction in ctx,
+	// so requests for it take turns whichever instance they reach.
+	// Locking an upload that no longer exists is not an error.
+	LockForWrite(ctx context.Context, id uint) error
+
+	// Advance moves an upload's offset to to and pushes back its expiry, if the
+	// offset is still from. Returns false if another request moved it first.
+	Advance(ctx context.Context, id uint, from, to int64, expiresAt time.Time) (bool, error)
+
+	// ListExpired lists uploads that expired before the given time
+	ListExpired(ctx context.Context, before time.Time) ([]model.ChunkUpload, error)
+
+	// Delete deletes an upload
+	Delete(ctx context.Context, id uint) error
+}
+
+// QuotaRepository defines the interface for per-user quota data access.
+type QuotaRepository interface {
+	// Get retrieves a user's quota. A missing quota is created with usage
+	// counted from the user's existing collections, items and chunks.
+	Get(ctx context.Context, userID uint) (*model.UserQuota, error)
+
+	// Charge adds amount to the user's usage of resource unless that would
+	// take it past limit (0 = unlimited), checked atomically.
+	// Returns false if the limit would be exceeded. The quota must exist (see Get).
+	Charge(ctx context.Context, userID uint, resource model.QuotaResource, amount, limit int64) (bool, error)
+
+	// Refund subtracts amount from the user's usage of resource
+	Refund(ctx context.Context, userID uint, resource model.QuotaResource, amount int64) error
+
+	// SetLimits stores the limit overrides of quota.UserID
+	SetLimits(ctx context.Context, quota *model.UserQuota) error
+
+	// Recalculate recomputes a user's usage from their data
+	Recalculate(ctx context.Context, userID uint) (*model.UserQuota, error)
+
+	// CollectionUsage counts what one collection adds to its owner's usage,
+	// returned in the Used fields of a quota without a user
+	CollectionUsage(ctx context.Context, collectionID uint) (*model.UserQuota, error)
+}
+
+// RetentionRepository defines the interface for per-collection retention override data access.
+type RetentionRepository interface {
+	// Get retrieves a collection's override, or nil if it has none
+	Get(ctx context.Context, collectionID uint) (*model.CollectionRetention, error)
+
+	// Set stores a collection's override, removing it if no rule is overridden
+	Set(ctx context.Context, retention *model.CollectionRetention) error
+
+	// List lists all overrides
+	List(ctx context.Context) ([]model.CollectionRetention, error)
+}
+
 // CollectionTypeRepository defines the interface for collection type data access.
 type CollectionTypeRepository interface {
 	// Create creates a new collection type
@@ -213,3 +381,12 @@ type CollectionTypeRepository interface {
 	GetOrCreate(ctx context.Context, ownerID uint, uid []byte) (*model.CollectionType, error)
 }
 
+
+// Transactor runs a unit of work in a single database transaction.
+type Transactor interface {
+	// WithinTransaction runs fn in a transaction that commits if fn returns nil
+	// and rolls back otherwise. Repository calls made with the context passed to
+	// fn join the transaction. Serialization conflicts are retried a bounded
+	// number of times, so fn may run more than once.
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -3,8 +3,10 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"goatsync/internal/model"
+	pkgerrors "goatsync/pkg/errors"
 
 	"gorm.io/gorm"
 )
@@ -19,15 +21,20 @@ func NewInvitationRepository(db *gorm.DB) InvitationRepository {
 	return &invitationRepository{db: db}
 }
 
-// Create creates a new invitation
+// Create creates a new invitation. The unique index on the invitee and the
+// inviting member catches concurrent invitations of the same pair.
 func (r *invitationRepository) Create(ctx context.Context, invitation *model.CollectionInvitation) error {
-	return r.db.WithContext(ctx).Create(invitation).Error
+	err := dbFromContext(ctx, r.db).Create(invitation).Error
+	if isUniqueViolation(err) {
+		return pkgerrors.ErrInvitationExists
+	}
+	return err
 }
 
 // GetByID retrieves an invitation by ID
 func (r *invitationRepository) GetByID(ctx context.Context, id uint) (*model.CollectionInvitation, error) {
 	var inv model.CollectionInvitation
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("FromMember").
 		Preload("FromMember.Collection").
 		Preload("User").
@@ -42,7 +49,7 @@ func (r *invitationRepository) GetByID(ctx context.Context, id uint) (*model.Col
 // GetByUID retrieves an invitation by UID
 func (r *invitationRepository) GetByUID(ctx context.Context, uid string) (*model.CollectionInvitation, error) {
 	var inv model.CollectionInvitation
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("FromMember").
 		Preload("FromMember.Collection").
 		Preload("User").
@@ -58,7 +65,7 @@ func (r *invitationRepository) GetByUID(ctx context.Context, uid string) (*model
 // ListIncoming lists incoming invitations for a user
 func (r *invitationRepository) ListIncoming(ctx context.Context, userID uint) ([]model.CollectionInvitation, error) {
 	var invitations []model.CollectionInvitation
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("FromMember").
 		Preload("FromMember.Collection").
 		Where("user_id = ?", userID).
@@ -66,10 +73,23 @@ func (r *invitationRepository) ListIncoming(ctx context.Context, userID uint) ([
 	return invitations, err
 }
 
+// GetPending retrieves the invitation a member sent to a user
+func (r *invitationRepository) GetPending(ctx context.Context, userID, fromMemberID uint) (*model.CollectionInvitation, error) {
+	var inv model.CollectionInvitation
+	err := dbFromContext(ctx, r.db).
+		Where("user_id = ? AND from_member_id = ?", userID, fromMemberID).
+		First(&inv).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &inv, err
+}
+
 // ListOutgoing lists outgoing invitations from a member
 func (r *invitationRepository) ListOutgoing(ctx context.Context, memberID uint) ([]model.CollectionInvitation, error) {
 	var invitations []model.CollectionInvitation
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("User").
 		Where("from_member_id = ?", memberID).
 		Find(&invitations).Error
@@ -79,7 +99,7 @@ func (r *invitationRepository) ListOutgoing(ctx context.Context, memberID uint)
 // ListOutgoingByUser lists all outgoing invitations sent by a user
 func (r *invitationRepository) ListOutgoingByUser(ctx context.Context, userID uint) ([]model.CollectionInvitation, error) {
 	var invitations []model.CollectionInvitation
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("User").
 		Preload("FromMember").
 		Preload("FromMember.Collection").
@@ -89,15 +109,34 @@ func (r *invitationRepository) ListOutgoingByUser(ctx context.Context, userID ui
 	return invitations, err
 }
 
+// Update updates an existing invitation
+func (r *invitationRepository) Update(ctx context.Context, invitation *model.CollectionInvitation) error {
+	return dbFromContext(ctx, r.db).Save(invitation).Error
+}
+
 // Delete deletes an invitation
 func (r *invitationRepository) Delete(ctx context.Context, id uint) error {
-	return r.db.WithContext(ctx).Delete(&model.CollectionInvitation{}, id).Error
+	return dbFromContext(ctx, r.db).Delete(&model.CollectionInvitation{}, id).Error
 }
 
 // DeleteForCollection deletes all invitations for a collection
 func (r *invitationRepository) DeleteForCollection(ctx context.Context, collectionID uint) error {
-	return r.db.WithContext(ctx).
+	return dbFromContext(ctx, r.db).
 		Where("from_member_id IN (SELECT id FROM django_collectionmember WHERE collection_id = ?)", collectionID).
 		Delete(&model.CollectionInvitation{}).Error
 }
 
+// DeleteFromMember deletes the invitations a member sent
+func (r *invitationRepository) DeleteFromMember(ctx context.Context, memberID uint) error {
+	return dbFromContext(ctx, r.db).
+		Where("from_member_id = ?", memberID).
+		Delete(&model.CollectionInvitation{}).Error
+}
+
+// DeleteCreatedBefore deletes the invitations sent before the given time
+func (r *invitationRepository) DeleteCreatedBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := dbFromContext(ctx, r.db).
+		Where("created_at < ?", before).
+		Delete(&model.CollectionInvitation{})
+	return result.RowsAffected, result.Error
+}
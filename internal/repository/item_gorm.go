@@ -7,32 +7,35 @@ import (
 	"goatsync/internal/model"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // itemRepository implements ItemRepository using GORM
 type itemRepository struct {
-	db         *gorm.DB
-	stokenRepo StokenRepository
+	db           *gorm.DB
+	stokenRepo   StokenRepository
+	stokenFilter *StokenFilter
 }
 
 // NewItemRepository creates a new item repository
 func NewItemRepository(db *gorm.DB) ItemRepository {
 	return &itemRepository{
-		db:         db,
-		stokenRepo: NewStokenRepository(db),
+		db:           db,
+		stokenRepo:   NewStokenRepository(db),
+		stokenFilter: NewStokenFilter(db),
 	}
 }
 
 // Create creates a new collection item
 func (r *itemRepository) Create(ctx context.Context, item *model.CollectionItem) error {
-	return r.db.WithContext(ctx).Create(item).Error
+	return dbFromContext(ctx, r.db).Create(item).Error
 }
 
 // GetByID retrieves an item by ID
 func (r *itemRepository) GetByID(ctx context.Context, id uint) (*model.CollectionItem, error) {
 	var item model.CollectionItem
-	err := r.db.WithContext(ctx).
-		Preload("Revisions", "current = ?", true).
+	err := dbFromContext(ctx, r.db).
+		Scopes(preloadCurrentRevision("Revisions")).
 		First(&item, id).Error
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -44,8 +47,8 @@ func (r *itemRepository) GetByID(ctx context.Context, id uint) (*model.Collectio
 // GetByUID retrieves an item by UID within a collection
 func (r *itemRepository) GetByUID(ctx context.Context, collectionID uint, uid string) (*model.CollectionItem, error) {
 	var item model.CollectionItem
-	err := r.db.WithContext(ctx).
-		Preload("Revisions", "current = ?", true).
+	err := dbFromContext(ctx, r.db).
+		Scopes(preloadCurrentRevision("Revisions")).
 		Where("collection_id = ? AND uid = ?", collectionID, uid).
 		First(&item).Error
 
@@ -55,7 +58,16 @@ func (r *itemRepository) GetByUID(ctx context.Context, collectionID uint, uid st
 	return &item, err
 }
 
-// ListForCollection lists items for a collection with pagination
+// ListForCollection lists items for a collection with pagination using stoken.
+//
+// Items are keyed by the stoken of their current revision. Stoken IDs are
+// unique, so they give a stable order and double as the keyset cursor: only
+// items changed after stoken are returned, and the new stoken is the newest
+// one on the page. Nothing is written.
+//
+// Django equivalent:
+//
+//	filter_by_stoken_and_limit(stoken, limit, queryset, CollectionItem.stoken_annotation)
 func (r *itemRepository) ListForCollection(
 	ctx context.Context,
 	collectionID uint,
@@ -75,21 +87,20 @@ func (r *itemRepository) ListForCollection(
 		}
 	}
 
-	query := r.db.WithContext(ctx).
+	// Served by idx_goatsync_revision_item_current_stoken
+	query := dbFromContext(ctx, r.db).
 		Model(&model.CollectionItem{}).
-		Where("collection_id = ?", collectionID).
-		Preload("Revisions", "current = ?", true)
+		Joins("LEFT JOIN django_collectionitemrevision ON django_collectionitemrevision.item_id = django_collectionitem.id AND django_collectionitemrevision.current = ?", true).
+		Where("django_collectionitem.collection_id = ?", collectionID).
+		Scopes(preloadCurrentRevision("Revisions"))
 
 	// Apply stoken filter
 	if stokenObj != nil {
-		query = query.
-			Joins("LEFT JOIN django_collectionitemrevision ON django_collectionitemrevision.item_id = django_collectionitem.id").
-			Where("django_collectionitemrevision.stoken_id > ?", stokenObj.ID).
-			Group("django_collectionitem.id")
+		query = query.Where("django_collectionitemrevision.stoken_id > ?", stokenObj.ID)
 	}
 
 	err = query.
-		Order("id ASC").
+		Order("django_collectionitemrevision.stoken_id ASC NULLS FIRST, django_collectionitem.id ASC").
 		Limit(limit + 1).
 		Find(&items).Error
 
@@ -105,24 +116,51 @@ func (r *itemRepository) ListForCollection(
 		done = true
 	}
 
-	// Create new stoken
-	if len(items) > 0 {
-		newStoken, err = r.stokenRepo.Create(ctx)
-		if err != nil {
-			return nil, nil, false, err
+	// Nothing changed: the client keeps its stoken
+	if len(items) == 0 {
+		return items, stokenObj, done, nil
+	}
+
+	var pageMax uint
+	for _, item := range items {
+		for _, rev := range item.Revisions {
+			pageMax = max(pageMax, rev.StokenID)
 		}
 	}
+	newStoken, err = r.stokenFilter.GetNewStoken(ctx, pageMax)
+	if err != nil {
+		return nil, nil, false, err
+	}
 
 	return items, newStoken, done, nil
 }
 
-// Update updates an existing item
+// Update updates an existing item. Preloaded revisions are not saved.
 func (r *itemRepository) Update(ctx context.Context, item *model.CollectionItem) error {
-	return r.db.WithContext(ctx).Save(item).Error
+	return dbFromContext(ctx, r.db).Omit(clause.Associations).Save(item).Error
 }
 
 // Delete deletes an item
 func (r *itemRepository) Delete(ctx context.Context, id uint) error {
-	return r.db.WithContext(ctx).Delete(&model.CollectionItem{}, id).Error
+	return dbFromContext(ctx, r.db).Delete(&model.CollectionItem{}, id).Error
 }
 
+
+// preloadCurrentRevision preloads the current revision at path (e.g. "Revisions")
+// together with its chunks in order
+func preloadCurrentRevision(path string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return preloadRevisionChunks(path + ".Chunks")(db.Preload(path, "current = ?", true))
+	}
+}
+
+// preloadRevisionChunks preloads the chunk relations at path with their chunks
+// and blobs, in the order the chunks were listed in the revision
+func preloadRevisionChunks(path string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.
+			Preload(path, func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+			Preload(path + ".Chunk").
+			Preload(path + ".Chunk.Blob")
+	}
+}
@@ -23,9 +23,15 @@ func NewMemberRepository(db *gorm.DB) MemberRepository {
 	}
 }
 
-// Create creates a new collection member
+// Create creates a new collection member, dropping the record of an earlier
+// removal of the same user so their devices don't drop the collection again
 func (r *memberRepository) Create(ctx context.Context, member *model.CollectionMember) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	return dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ? AND user_id = ?", member.CollectionID, member.UserID).
+			Delete(&model.CollectionMemberRemoved{}).Error; err != nil {
+			return err
+		}
+
 		// Create stoken for the member
 		stoken := &model.Stoken{}
 		if err := tx.Create(stoken).Error; err != nil {
@@ -39,7 +45,7 @@ func (r *memberRepository) Create(ctx context.Context, member *model.CollectionM
 // GetByID retrieves a member by ID
 func (r *memberRepository) GetByID(ctx context.Context, id uint) (*model.CollectionMember, error) {
 	var member model.CollectionMember
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("User").
 		Preload("User.UserInfo").
 		First(&member, id).Error
@@ -53,7 +59,7 @@ func (r *memberRepository) GetByID(ctx context.Context, id uint) (*model.Collect
 // GetByUserAndCollection retrieves a member by user ID and collection ID
 func (r *memberRepository) GetByUserAndCollection(ctx context.Context, userID, collectionID uint) (*model.CollectionMember, error) {
 	var member model.CollectionMember
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("User").
 		Where("user_id = ? AND collection_id = ?", userID, collectionID).
 		First(&member).Error
@@ -67,7 +73,7 @@ func (r *memberRepository) GetByUserAndCollection(ctx context.Context, userID, c
 // GetByUsernameAndCollection retrieves a member by username and collection ID
 func (r *memberRepository) GetByUsernameAndCollection(ctx context.Context, username string, collectionID uint) (*model.CollectionMember, error) {
 	var member model.CollectionMember
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("User").
 		Joins("JOIN myauth_user ON myauth_user.id = django_collectionmember.user_id").
 		Where("myauth_user.username = ? AND collection_id = ?", username, collectionID).
@@ -82,7 +88,7 @@ func (r *memberRepository) GetByUsernameAndCollection(ctx context.Context, usern
 // ListForCollection lists all members of a collection
 func (r *memberRepository) ListForCollection(ctx context.Context, collectionID uint) ([]model.CollectionMember, error) {
 	var members []model.CollectionMember
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("User").
 		Where("collection_id = ?", collectionID).
 		Find(&members).Error
@@ -91,7 +97,7 @@ func (r *memberRepository) ListForCollection(ctx context.Context, collectionID u
 
 // Update updates an existing member
 func (r *memberRepository) Update(ctx context.Context, member *model.CollectionMember) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	return dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
 		// Create new stoken on update
 		stoken := &model.Stoken{}
 		if err := tx.Create(stoken).Error; err != nil {
@@ -104,7 +110,7 @@ func (r *memberRepository) Update(ctx context.Context, member *model.CollectionM
 
 // Delete removes a member from a collection
 func (r *memberRepository) Delete(ctx context.Context, id uint) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	return dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
 		var member model.CollectionMember
 		if err := tx.First(&member, id).Error; err != nil {
 			return err
@@ -129,11 +135,15 @@ func (r *memberRepository) Delete(ctx context.Context, id uint) error {
 	})
 }
 
-// GetRemovedMemberships lists removed memberships for a user since a stoken
+// GetRemovedMemberships lists removed memberships for a user since a stoken,
+// oldest first, with their collection and stoken preloaded
 func (r *memberRepository) GetRemovedMemberships(ctx context.Context, userID uint, stoken string) ([]model.CollectionMemberRemoved, error) {
-	query := r.db.WithContext(ctx).
+	query := dbFromContext(ctx, r.db).
 		Model(&model.CollectionMemberRemoved{}).
-		Where("user_id = ?", userID)
+		Preload("Collection").
+		Preload("Stoken").
+		Where("user_id = ?", userID).
+		Order("stoken_id ASC")
 
 	if stoken != "" {
 		stokenObj, err := r.stokenRepo.GetByUID(ctx, stoken)
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"goatsync/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// quotaRepository implements QuotaRepository using GORM
+type quotaRepository struct {
+	db *gorm.DB
+}
+
+// NewQuotaRepository creates a new quota repository
+func NewQuotaRepository(db *gorm.DB) QuotaRepository {
+	return &quotaRepository{db: db}
+}
+
+// Get retrieves a user's quota, creating it from their current usage if missing
+func (r *quotaRepository) Get(ctx context.Context, userID uint) (*model.UserQuota, error) {
+	db := dbFromContext(ctx, r.db)
+
+	var quota model.UserQuota
+	err := db.Where("user_id = ?", userID).First(&quota).Error
+	if err == nil {
+		return &quota, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// First write since quotas were introduced: count what the user already has
+	created, err := computeUsage(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(created).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Where("user_id = ?", userID).First(&quota).Error; err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// Charge adds amount to a user's usage of resource if it stays within limit
+func (r *quotaRepository) Charge(ctx context.Context, userID uint, resource model.QuotaResource, amount, limit int64) (bool, error) {
+	column := usageColumn(resource)
+	query := dbFromContext(ctx, r.db).
+		Model(&model.UserQuota{}).
+		Where("user_id = ?", userID)
+	if limit > 0 && amount > 0 {
+		// Checked in the UPDATE itself so concurrent writes can't overshoot
+		query = query.Where(column+" + ? <= ?", amount, limit)
+	}
+
+	result := query.Update(column, gorm.Expr(column+" + ?", amount))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// Refund subtracts amount from a user's usage of resource
+func (r *quotaRepository) Refund(ctx context.Context, userID uint, resource model.QuotaResource, amount int64) error {
+	column := usageColumn(resource)
+	return dbFromContext(ctx, r.db).
+		Model(&model.UserQuota{}).
+		Where("user_id = ?", userID).
+		Update(column, gorm.Expr("GREATEST("+column+" - ?, 0)", amount)).Error
+}
+
+// SetLimits stores a user's limit overrides
+func (r *quotaRepository) SetLimits(ctx context.Context, quota *model.UserQuota) error {
+	return dbFromContext(ctx, r.db).
+		Model(&model.UserQuota{}).
+		Where("user_id = ?", quota.UserID).
+		Select("max_chunk_bytes", "max_collections", "max_items").
+		Updates(quota).Error
+}
+
+// Recalculate recomputes a user's usage from their data
+func (r *quotaRepository) Recalculate(ctx context.Context, userID uint) (*model.UserQuota, error) {
+	if _, err := r.Get(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	var quota model.UserQuota
+	err := dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		usage, err := computeUsage(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&model.UserQuota{}).
+			Where("user_id = ?", userID).
+			Select("used_chunk_bytes", "used_collections", "used_items").
+			Updates(usage).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).First(&quota).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// usageColumn returns the column holding the usage of resource
+func usageColumn(resource model.QuotaResource) string {
+	return "used_" + string(resource)
+}
+
+// CollectionUsage counts what one collection adds to its owner's usage
+func (r *quotaRepository) CollectionUsage(ctx context.Context, collectionID uint) (*model.UserQuota, error) {
+	return countUsage(dbFromContext(ctx, r.db), "django_collection.id = ?", collectionID)
+}
+
+// computeUsage counts everything a user's quota covers
+func computeUsage(db *gorm.DB, userID uint) (*model.UserQuota, error) {
+	usage, err := countUsage(db, "django_collection.owner_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	usage.UserID = userID
+	return usage, nil
+}
+
+// countUsage counts the collections matching where, with their items and chunk bytes
+func countUsage(db *gorm.DB, where string, arg uint) (*model.UserQuota, error) {
+	usage := &model.UserQuota{}
+
+	// Purged collections are only kept to report their removal
+	if err := db.Model(&model.Collection{}).
+		Where(where, arg).
+		Where("django_collection.purged_at IS NULL").
+		Count(&usage.UsedCollections).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&model.CollectionItem{}).
+		Joins("JOIN django_collection ON django_collection.id = django_collectionitem.collection_id").
+		Where(where, arg).
+		Where("django_collection.main_item_id IS NULL OR django_collection.main_item_id <> django_collectionitem.id").
+		Count(&usage.UsedItems).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&model.CollectionItemChunk{}).
+		Joins("JOIN django_collection ON django_collection.id = django_collectionitemchunk.collection_id").
+		Joins("JOIN goatsync_chunkblob ON goatsync_chunkblob.id = django_collectionitemchunk.blob_id").
+		Where(where, arg).
+		Select("COALESCE(SUM(goatsync_chunkblob.size), 0)").
+		Scan(&usage.UsedChunkBytes).Error; err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// refundChunkUsage gives the size of the given chunks back to the owners of
+// their collections. Must be called before the chunks are deleted.
+func refundChunkUsage(tx *gorm.DB, chunkIDs []uint) error {
+	if len(chunkIDs) == 0 {
+		return nil
+	}
+
+	var freed []struct {
+		OwnerID uint
+		Bytes   int64
+	}
+	if err := tx.Model(&model.CollectionItemChunk{}).
+		Joins("JOIN django_collection ON django_collection.id = django_collectionitemchunk.collection_id").
+		Joins("JOIN goatsync_chunkblob ON goatsync_chunkblob.id = django_collectionitemchunk.blob_id").
+		Where("django_collectionitemchunk.id IN ?", chunkIDs).
+		Group("django_collection.owner_id").
+		Select("django_collection.owner_id, SUM(goatsync_chunkblob.size) AS bytes").
+		Scan(&freed).Error; err != nil {
+		return err
+	}
+
+	column := usageColumn(model.QuotaChunkBytes)
+	for _, f := range freed {
+		if err := tx.Model(&model.UserQuota{}).
+			Where("user_id = ?", f.OwnerID).
+			Update(column, gorm.Expr("GREATEST("+column+" - ?, 0)", f.Bytes)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
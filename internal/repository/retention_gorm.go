@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"goatsync/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// retentionRepository implements RetentionRepository using GORM
+type retentionRepository struct {
+	db *gorm.DB
+}
+
+// NewRetentionRepository creates a new retention repository
+func NewRetentionRepository(db *gorm.DB) RetentionRepository {
+	return &retentionRepository{db: db}
+}
+
+// Get retrieves a collection's retention override
+func (r *retentionRepository) Get(ctx context.Context, collectionID uint) (*model.CollectionRetention, error) {
+	var retention model.CollectionRetention
+	err := dbFromContext(ctx, r.db).
+		Where("collection_id = ?", collectionID).
+		First(&retention).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &retention, err
+}
+
+// Set stores a collection's retention override
+func (r *retentionRepository) Set(ctx context.Context, retention *model.CollectionRetention) error {
+	db := dbFromContext(ctx, r.db)
+
+	// Without overridden rules the collection follows the default again
+	if retention.KeepRevisions == nil && retention.KeepDays == nil {
+		return db.Where("collection_id = ?", retention.CollectionID).
+			Delete(&model.CollectionRetention{}).Error
+	}
+
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "collection_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"keep_revisions", "keep_days", "updated_at"}),
+	}).Create(retention).Error
+}
+
+// List lists all retention overrides
+func (r *retentionRepository) List(ctx context.Context) ([]model.CollectionRetention, error) {
+	var retentions []model.CollectionRetention
+	err := dbFromContext(ctx, r.db).
+		Order("collection_id ASC").
+		Find(&retentions).Error
+	return retentions, err
+}
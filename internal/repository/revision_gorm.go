@@ -25,7 +25,7 @@ func NewRevisionRepository(db *gorm.DB) RevisionRepository {
 
 // Create creates a new revision with associated stoken
 func (r *revisionRepository) Create(ctx context.Context, revision *model.CollectionItemRevision) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	return dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
 		// Create stoken for the revision
 		stoken := &model.Stoken{}
 		if err := tx.Create(stoken).Error; err != nil {
@@ -51,8 +51,8 @@ func (r *revisionRepository) Create(ctx context.Context, revision *model.Collect
 // GetByUID retrieves a revision by UID
 func (r *revisionRepository) GetByUID(ctx context.Context, uid string) (*model.CollectionItemRevision, error) {
 	var rev model.CollectionItemRevision
-	err := r.db.WithContext(ctx).
-		Preload("Chunks").
+	err := dbFromContext(ctx, r.db).
+		Scopes(preloadRevisionChunks("Chunks")).
 		Where("uid = ?", uid).
 		First(&rev).Error
 
@@ -65,8 +65,8 @@ func (r *revisionRepository) GetByUID(ctx context.Context, uid string) (*model.C
 // GetCurrentForItem retrieves the current revision for an item
 func (r *revisionRepository) GetCurrentForItem(ctx context.Context, itemID uint) (*model.CollectionItemRevision, error) {
 	var rev model.CollectionItemRevision
-	err := r.db.WithContext(ctx).
-		Preload("Chunks").
+	err := dbFromContext(ctx, r.db).
+		Scopes(preloadRevisionChunks("Chunks")).
 		Where("item_id = ? AND current = ?", itemID, true).
 		First(&rev).Error
 
@@ -76,20 +76,101 @@ func (r *revisionRepository) GetCurrentForItem(ctx context.Context, itemID uint)
 	return &rev, err
 }
 
-// ListForItem lists all revisions for an item
-func (r *revisionRepository) ListForItem(ctx context.Context, itemID uint, limit int) ([]model.CollectionItemRevision, error) {
+// ListForItem lists an item's revisions newest first, using the revision ID
+// as the keyset cursor.
+//
+// Django equivalent:
+//
+//	item.revisions.order_by("-id").filter(id__lt=iterator_obj.id)[: limit + 1]
+func (r *revisionRepository) ListForItem(
+	ctx context.Context,
+	itemID uint,
+	beforeID uint,
+	limit int,
+) (revisions []model.CollectionItemRevision, done bool, err error) {
 	if limit <= 0 {
 		limit = 50
 	}
 
-	var revisions []model.CollectionItemRevision
-	err := r.db.WithContext(ctx).
-		Preload("Chunks").
-		Where("item_id = ?", itemID).
+	query := dbFromContext(ctx, r.db).
+		Scopes(preloadRevisionChunks("Chunks")).
+		Where("item_id = ?", itemID)
+	if beforeID != 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+
+	err = query.
 		Order("id DESC").
-		Limit(limit).
+		Limit(limit + 1).
 		Find(&revisions).Error
+	if err != nil {
+		return nil, false, err
+	}
 
-	return revisions, err
+	// Check if done
+	if len(revisions) > limit {
+		return revisions[:limit], false, nil
+	}
+	return revisions, true, nil
 }
 
+// ListPrunable lists the IDs of non-current revisions that filter lets go.
+// Revisions are ranked newest first within their item, so the current
+// revision and the ones just before it are never selected by the count rule.
+// Revisions without a creation time count as old.
+func (r *revisionRepository) ListPrunable(ctx context.Context, filter PruneFilter) ([]uint, error) {
+	db := dbFromContext(ctx, r.db)
+
+	ranked := db.Model(&model.CollectionItemRevision{}).
+		Select("django_collectionitemrevision.id, django_collectionitemrevision.current, django_collectionitemrevision.created_at, " +
+			"ROW_NUMBER() OVER (PARTITION BY django_collectionitemrevision.item_id ORDER BY django_collectionitemrevision.id DESC) AS revision_rank").
+		Joins("JOIN django_collectionitem ON django_collectionitem.id = django_collectionitemrevision.item_id")
+	if filter.CollectionID != 0 {
+		ranked = ranked.Where("django_collectionitem.collection_id = ?", filter.CollectionID)
+	} else {
+		ranked = ranked.Where("django_collectionitem.collection_id NOT IN (?)",
+			db.Model(&model.CollectionRetention{}).Select("collection_id"))
+	}
+
+	query := db.Table("(?) AS ranked", ranked).
+		Where("ranked.current IS NOT TRUE").
+		Where("ranked.id > ?", filter.AfterID)
+	if filter.KeepRevisions > 0 {
+		query = query.Where("ranked.revision_rank > ?", filter.KeepRevisions)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("ranked.created_at IS NULL OR ranked.created_at < ?", filter.CreatedBefore)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var ids []uint
+	err := query.Order("ranked.id ASC").Pluck("ranked.id", &ids).Error
+	return ids, err
+}
+
+// ListIDsForCollection lists the IDs of the collection's revisions after afterID
+func (r *revisionRepository) ListIDsForCollection(ctx context.Context, collectionID, afterID uint, limit int) ([]uint, error) {
+	var ids []uint
+	err := dbFromContext(ctx, r.db).
+		Model(&model.CollectionItemRevision{}).
+		Joins("JOIN django_collectionitem ON django_collectionitem.id = django_collectionitemrevision.item_id").
+		Where("django_collectionitem.collection_id = ? AND django_collectionitemrevision.id > ?", collectionID, afterID).
+		Order("django_collectionitemrevision.id ASC").
+		Limit(limit).
+		Pluck("django_collectionitemrevision.id", &ids).Error
+	return ids, err
+}
+
+// DeleteNonCurrent deletes the given revisions unless they are current
+func (r *revisionRepository) DeleteNonCurrent(ctx context.Context, ids []uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result := dbFromContext(ctx, r.db).
+		Where("id IN ? AND current IS NOT TRUE", ids).
+		Delete(&model.CollectionItemRevision{})
+	return result.RowsAffected, result.Error
+}
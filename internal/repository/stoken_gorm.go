@@ -24,7 +24,7 @@ func NewStokenRepository(db *gorm.DB) StokenRepository {
 // The UID is automatically generated in the BeforeCreate hook
 func (r *stokenRepository) Create(ctx context.Context) (*model.Stoken, error) {
 	stoken := &model.Stoken{}
-	if err := r.db.WithContext(ctx).Create(stoken).Error; err != nil {
+	if err := dbFromContext(ctx, r.db).Create(stoken).Error; err != nil {
 		return nil, err
 	}
 	return stoken, nil
@@ -33,7 +33,7 @@ func (r *stokenRepository) Create(ctx context.Context) (*model.Stoken, error) {
 // GetByID retrieves a stoken by ID
 func (r *stokenRepository) GetByID(ctx context.Context, id uint) (*model.Stoken, error) {
 	var stoken model.Stoken
-	err := r.db.WithContext(ctx).First(&stoken, id).Error
+	err := dbFromContext(ctx, r.db).First(&stoken, id).Error
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, nil
@@ -52,7 +52,7 @@ func (r *stokenRepository) GetByUID(ctx context.Context, uid string) (*model.Sto
 	}
 
 	var stoken model.Stoken
-	err := r.db.WithContext(ctx).Where("uid = ?", uid).First(&stoken).Error
+	err := dbFromContext(ctx, r.db).Where("uid = ?", uid).First(&stoken).Error
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, pkgerrors.ErrBadStoken
@@ -113,7 +113,7 @@ func (f *StokenFilter) GetNewStoken(ctx context.Context, maxStokenID uint) (*mod
 	}
 
 	var stoken model.Stoken
-	err := f.db.WithContext(ctx).First(&stoken, maxStokenID).Error
+	err := dbFromContext(ctx, f.db).First(&stoken, maxStokenID).Error
 	if err != nil {
 		return nil, err
 	}
@@ -21,13 +21,13 @@ func NewTokenRepository(db *gorm.DB) TokenRepository {
 
 // Create creates a new auth token
 func (r *tokenRepository) Create(ctx context.Context, token *model.AuthToken) error {
-	return r.db.WithContext(ctx).Create(token).Error
+	return dbFromContext(ctx, r.db).Create(token).Error
 }
 
 // GetByKey retrieves a token by its key
 func (r *tokenRepository) GetByKey(ctx context.Context, key string) (*model.AuthToken, error) {
 	var token model.AuthToken
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("User").
 		Preload("User.UserInfo").
 		Where("key = ?", key).
@@ -44,11 +44,11 @@ func (r *tokenRepository) GetByKey(ctx context.Context, key string) (*model.Auth
 
 // Delete deletes a token by its key
 func (r *tokenRepository) Delete(ctx context.Context, key string) error {
-	return r.db.WithContext(ctx).Where("key = ?", key).Delete(&model.AuthToken{}).Error
+	return dbFromContext(ctx, r.db).Where("key = ?", key).Delete(&model.AuthToken{}).Error
 }
 
 // DeleteAllForUser deletes all tokens for a user
 func (r *tokenRepository) DeleteAllForUser(ctx context.Context, userID uint) error {
-	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&model.AuthToken{}).Error
+	return dbFromContext(ctx, r.db).Where("user_id = ?", userID).Delete(&model.AuthToken{}).Error
 }
 
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxTxAttempts is how many times a transaction is tried before a
+// serialization conflict is returned to the caller
+const maxTxAttempts = 5
+
+// txRetryDelay is the base delay before retrying a conflicting transaction
+const txRetryDelay = 10 * time.Millisecond
+
+// txKey is the context key holding the current transaction
+type txKey struct{}
+
+// gormTransactor implements Transactor using GORM
+type gormTransactor struct {
+	db *gorm.DB
+}
+
+// NewTransactor creates a new transactor
+func NewTransactor(db *gorm.DB) Transactor {
+	return &gormTransactor{db: db}
+}
+
+// WithinTransaction runs fn in a serializable transaction, retrying it on
+// serialization failures and deadlocks.
+// If ctx already carries a transaction, fn joins it instead.
+func (t *gormTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+
+	opts := &sql.TxOptions{Isolation: sql.LevelSerializable}
+	for attempt := 1; ; attempt++ {
+		err := t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(context.WithValue(ctx, txKey{}, tx))
+		}, opts)
+		if err == nil || attempt == maxTxAttempts || !isSerializationFailure(err) {
+			return err
+		}
+
+		// Back off with jitter so conflicting writers don't collide again
+		delay := txRetryDelay*time.Duration(attempt) + rand.N(txRetryDelay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// dbFromContext returns the transaction carried by ctx, or db if there is none
+func dbFromContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
+
+// isSerializationFailure reports whether err is a PostgreSQL serialization
+// failure or deadlock, after which the transaction can safely be retried
+func isSerializationFailure(err error) bool {
+	var pgErr interface{ SQLState() string }
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.SQLState() {
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return true
+	}
+	return false
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == "23505"
+}
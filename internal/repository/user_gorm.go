@@ -22,7 +22,7 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 
 // Create creates a new user with associated UserInfo
 func (r *userRepository) Create(ctx context.Context, user *model.User, userInfo *model.UserInfo) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	return dbFromContext(ctx, r.db).Transaction(func(tx *gorm.DB) error {
 		// Store username lowercase for case-insensitive lookup
 		// Original casing is stored in FirstName
 		user.FirstName = user.Username
@@ -46,7 +46,7 @@ func (r *userRepository) Create(ctx context.Context, user *model.User, userInfo
 // GetByID retrieves a user by their ID
 func (r *userRepository) GetByID(ctx context.Context, id uint) (*model.User, error) {
 	var user model.User
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("UserInfo").
 		First(&user, id).Error
 
@@ -62,7 +62,7 @@ func (r *userRepository) GetByID(ctx context.Context, id uint) (*model.User, err
 // GetByUsername retrieves a user by username (case-insensitive)
 func (r *userRepository) GetByUsername(ctx context.Context, username string) (*model.User, error) {
 	var user model.User
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("UserInfo").
 		Where("username = ?", strings.ToLower(username)).
 		First(&user).Error
@@ -79,7 +79,7 @@ func (r *userRepository) GetByUsername(ctx context.Context, username string) (*m
 // GetByEmail retrieves a user by email (case-insensitive)
 func (r *userRepository) GetByEmail(ctx context.Context, email string) (*model.User, error) {
 	var user model.User
-	err := r.db.WithContext(ctx).
+	err := dbFromContext(ctx, r.db).
 		Preload("UserInfo").
 		Where("email = ?", strings.ToLower(email)).
 		First(&user).Error
@@ -95,16 +95,16 @@ func (r *userRepository) GetByEmail(ctx context.Context, email string) (*model.U
 
 // Update updates an existing user
 func (r *userRepository) Update(ctx context.Context, user *model.User) error {
-	return r.db.WithContext(ctx).Save(user).Error
+	return dbFromContext(ctx, r.db).Save(user).Error
 }
 
 // UpdateUserInfo updates a user's UserInfo
 func (r *userRepository) UpdateUserInfo(ctx context.Context, userInfo *model.UserInfo) error {
-	return r.db.WithContext(ctx).Save(userInfo).Error
+	return dbFromContext(ctx, r.db).Save(userInfo).Error
 }
 
 // Delete deletes a user by ID
 func (r *userRepository) Delete(ctx context.Context, id uint) error {
-	return r.db.WithContext(ctx).Delete(&model.User{}, id).Error
+	return dbFromContext(ctx, r.db).Delete(&model.User{}, id).Error
 }
 
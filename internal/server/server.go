@@ -110,6 +110,8 @@ func (s *Server) RegisterRoutes() {
 		collection.POST("/", s.collectionHandler.Create)
 		collection.POST("/list_multi/", s.collectionHandler.ListMulti)
 		collection.GET("/:collection_uid/", s.collectionHandler.Get)
+		collection.DELETE("/:collection_uid/", s.collectionHandler.Delete)          // GoatSync extension
+		collection.POST("/:collection_uid/transfer/", s.collectionHandler.Transfer) // GoatSync extension
 
 		// Item routes (nested under collection)
 		collection.GET("/:collection_uid/item/", s.itemHandler.List)
@@ -123,6 +125,13 @@ func (s *Server) RegisterRoutes() {
 		collection.PUT("/:collection_uid/item/:item_uid/chunk/:chunk_uid/", s.chunkHandler.Upload)
 		collection.GET("/:collection_uid/item/:item_uid/chunk/:chunk_uid/download/", s.chunkHandler.Download)
 
+		// Resumable chunk uploads (GoatSync extension)
+		collection.POST("/:collection_uid/item/:item_uid/chunk/:chunk_uid/upload/", s.chunkHandler.CreateUpload)
+		collection.GET("/:collection_uid/item/:item_uid/chunk/:chunk_uid/upload/", s.chunkHandler.GetUpload)
+		collection.PATCH("/:collection_uid/item/:item_uid/chunk/:chunk_uid/upload/", s.chunkHandler.AppendUpload)
+		collection.DELETE("/:collection_uid/item/:item_uid/chunk/:chunk_uid/upload/", s.chunkHandler.CancelUpload)
+		collection.POST("/:collection_uid/item/:item_uid/chunk/:chunk_uid/upload/finalize/", s.chunkHandler.FinalizeUpload)
+
 		// Member routes
 		collection.GET("/:collection_uid/member/", s.memberHandler.List)
 		collection.DELETE("/:collection_uid/member/:username/", s.memberHandler.Remove)
@@ -146,6 +155,7 @@ func (s *Server) RegisterRoutes() {
 		outgoing := invitation.Group("/outgoing")
 		{
 			outgoing.GET("/", s.invitationHandler.ListOutgoing)
+			outgoing.POST("/", s.invitationHandler.CreateOutgoing)
 			outgoing.DELETE("/:invitation_uid/", s.invitationHandler.DeleteOutgoing)
 			outgoing.POST("/fetch_user_profile/", s.invitationHandler.FetchUserForInvite)
 		}
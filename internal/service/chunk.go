@@ -1,8 +1,11 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"io"
+	"log"
 
 	"goatsync/internal/model"
 	"goatsync/internal/repository"
@@ -15,7 +18,9 @@ type ChunkService struct {
 	chunkRepo      repository.ChunkRepository
 	collectionRepo repository.CollectionRepository
 	memberRepo     repository.MemberRepository
-	storage        *storage.FileStorage
+	transactor     repository.Transactor
+	store          storage.ChunkStore
+	quotaService   *QuotaService
 }
 
 // NewChunkService creates a new chunk service
@@ -23,22 +28,32 @@ func NewChunkService(
 	chunkRepo repository.ChunkRepository,
 	collectionRepo repository.CollectionRepository,
 	memberRepo repository.MemberRepository,
-	storage *storage.FileStorage,
+	transactor repository.Transactor,
+	store storage.ChunkStore,
+	quotaService *QuotaService,
 ) *ChunkService {
 	return &ChunkService{
 		chunkRepo:      chunkRepo,
 		collectionRepo: collectionRepo,
 		memberRepo:     memberRepo,
-		storage:        storage,
+		transactor:     transactor,
+		store:          store,
+		quotaService:   quotaService,
 	}
 }
 
-// UploadChunk uploads a new chunk
+// UploadChunk uploads a new chunk.
+// size is the length of data in bytes, or -1 if unknown.
+//
+// The chunk is stored under the hash of its content, so re-uploading the
+// same bytes (in another revision or after a client retry) reuses the
+// existing file.
 func (s *ChunkService) UploadChunk(
 	ctx context.Context,
 	collectionUID, itemUID, chunkUID string,
 	userID uint,
 	data io.Reader,
+	size int64,
 ) error {
 	// Get collection
 	col, err := s.collectionRepo.GetByUID(ctx, collectionUID)
@@ -70,27 +85,158 @@ func (s *ChunkService) UploadChunk(
 		return pkgerrors.ErrChunkExists
 	}
 
-	// Save to filesystem
-	chunkPath := s.storage.ChunkPath(col.OwnerID, collectionUID, chunkUID)
-	if err := s.storage.SaveChunkFromReader(col.OwnerID, collectionUID, chunkUID, data); err != nil {
-		return err
+	_, err = s.storeChunk(ctx, col, chunkUID, data)
+	return err
+}
+
+// storeChunk stores a new chunk in col and records it.
+// Access to the collection must already have been checked.
+func (s *ChunkService) storeChunk(
+	ctx context.Context,
+	col *model.Collection,
+	chunkUID string,
+	data io.Reader,
+) (*model.CollectionItemChunk, error) {
+	// Buffer the upload to learn its content hash
+	spool, err := storage.NewSpool(data)
+	if err != nil {
+		return nil, err
 	}
+	defer func() { _ = spool.Close() }()
 
-	// Create database record
+	// Chunks count towards the collection owner's storage quota.
+	// Check before writing anything; the charge itself is made with the
+	// chunk row, so a failed store rolls it back.
+	if err := s.quotaService.Check(ctx, col.OwnerID, model.QuotaChunkBytes, spool.Size); err != nil {
+		return nil, err
+	}
+
+	// Chunks are content-addressed: identical bytes share one file.
+	// Claim the blob first: while this transaction holds it, its last chunk
+	// can't be released, so the file of a recorded blob is reused safely.
+	// A blob that wasn't recorded yet gets its file written, even if a
+	// released chunk left one behind for the garbage collector.
+	chunkKey := storage.BlobKey(spool.Hash)
 	chunk := &model.CollectionItemChunk{
 		UID:          chunkUID,
 		CollectionID: col.ID,
-		ChunkFile:    chunkPath,
+		ChunkFile:    chunkKey,
+	}
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		blob := &model.ChunkBlob{
+			Hash: spool.Hash,
+			Size: spool.Size,
+		}
+		known, err := s.chunkRepo.ClaimBlob(ctx, blob)
+		if err != nil {
+			return err
+		}
+		exists := false
+		if known {
+			if exists, err = s.store.Exists(ctx, chunkKey); err != nil {
+				return err
+			}
+		}
+		if !exists {
+			reader, err := spool.Reader()
+			if err != nil {
+				return err
+			}
+			if err := s.store.Put(ctx, chunkKey, reader, spool.Size); err != nil {
+				return err
+			}
+		}
+
+		if err := s.quotaService.Charge(ctx, col.OwnerID, model.QuotaChunkBytes, spool.Size); err != nil {
+			return err
+		}
+		// If recording the chunk fails, the garbage collector removes the file
+		chunk.BlobID = &blob.ID
+		return s.chunkRepo.Create(ctx, chunk)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// storeRevisionChunks stores the chunks a revision references that haven't
+// been uploaded yet, from the content sent along with them.
+// Item writes call it before opening their transaction, so a retried
+// transaction doesn't store the chunks again. Chunks left unused by a write
+// that fails are removed by the ChunkCollector.
+func (s *ChunkService) storeRevisionChunks(ctx context.Context, col *model.Collection, chunks []ChunkIn) error {
+	for _, chunkIn := range chunks {
+		if chunkIn.Content == nil {
+			continue
+		}
+		chunk, err := s.chunkRepo.GetByUID(ctx, col.ID, chunkIn.UID)
+		if err != nil {
+			return err
+		}
+		if chunk != nil {
+			continue
+		}
+		if _, err := s.storeChunk(ctx, col, chunkIn.UID, bytes.NewReader(chunkIn.Content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveRevisionChunks looks up the chunks a revision references, in order.
+// Like the reference server, a chunk that hasn't been uploaded yet is stored
+// from the content sent along with it; without content it's rejected.
+func (s *ChunkService) resolveRevisionChunks(
+	ctx context.Context,
+	col *model.Collection,
+	chunks []ChunkIn,
+) ([]uint, error) {
+	ids := make([]uint, 0, len(chunks))
+	for _, chunkIn := range chunks {
+		chunk, err := s.chunkRepo.GetByUID(ctx, col.ID, chunkIn.UID)
+		if err != nil {
+			return nil, err
+		}
+		if chunk == nil {
+			if chunkIn.Content == nil {
+				return nil, pkgerrors.ErrChunkNoContent
+			}
+			chunk, err = s.storeChunk(ctx, col, chunkIn.UID, bytes.NewReader(chunkIn.Content))
+			if err != nil {
+				return nil, err
+			}
+		}
+		ids = append(ids, chunk.ID)
 	}
-	return s.chunkRepo.Create(ctx, chunk)
+	return ids, nil
 }
 
-// DownloadChunk downloads a chunk
+// linkRevisionChunks links resolved chunks to a newly created revision
+func (s *ChunkService) linkRevisionChunks(ctx context.Context, revisionID uint, chunkIDs []uint) error {
+	return s.chunkRepo.AddRevisionChunks(ctx, revisionID, chunkIDs)
+}
+
+// ReleaseRevisions drops the chunk references held by the given revisions.
+// Files no chunk holds any more are removed by the ChunkCollector.
+func (s *ChunkService) ReleaseRevisions(ctx context.Context, revisionIDs []uint) error {
+	return s.chunkRepo.ReleaseRevisionChunks(ctx, revisionIDs)
+}
+
+// ChunkDownload is an open chunk ready to be served
+type ChunkDownload struct {
+	Content io.ReadSeekCloser // Seekable so ranges can be served; the caller must close it
+	ETag    string            // Strong validator for If-Range and If-None-Match
+}
+
+// DownloadChunk opens a chunk for download.
+// Chunks with a recorded checksum are verified in full before they're
+// returned, so a corrupted chunk is never served, not even in part.
 func (s *ChunkService) DownloadChunk(
 	ctx context.Context,
 	collectionUID, itemUID, chunkUID string,
 	userID uint,
-) ([]byte, error) {
+) (*ChunkDownload, error) {
 	// Get collection
 	col, err := s.collectionRepo.GetByUID(ctx, collectionUID)
 	if err != nil {
@@ -118,7 +264,35 @@ func (s *ChunkService) DownloadChunk(
 		return nil, pkgerrors.ErrChunkNoContent
 	}
 
-	// Load from filesystem
-	return s.storage.LoadChunk(col.OwnerID, collectionUID, chunkUID)
-}
+	// Open it in the chunk store
+	content, err := s.store.Get(ctx, chunk.ChunkFile)
+	if errors.Is(err, storage.ErrChunkNotFound) {
+		return nil, pkgerrors.ErrChunkNoContent
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunks stored before checksums were recorded have no blob
+	// and are identified by their UID, which never changes content
+	if chunk.Blob == nil {
+		return &ChunkDownload{Content: content, ETag: `"` + chunkUID + `"`}, nil
+	}
+
+	// Check the whole chunk before anything is sent, since a range
+	// request only reads part of it
+	err = storage.Verify(content, chunk.Blob.Hash, chunk.Blob.Size)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = content.Close()
+		if errors.Is(err, storage.ErrChunkCorrupted) {
+			log.Printf("chunk %s of collection %s is corrupted (%s)", chunkUID, collectionUID, chunk.ChunkFile)
+			return nil, pkgerrors.ErrChunkCorrupted
+		}
+		return nil, err
+	}
 
+	return &ChunkDownload{Content: content, ETag: `"` + chunk.Blob.Hash + `"`}, nil
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"goatsync/internal/repository"
+	"goatsync/internal/storage"
+)
+
+// gcBatchSize is the number of stored files checked against the database at once
+const gcBatchSize = 500
+
+// ChunkCollector garbage-collects chunk files and rows that are no longer needed:
+//   - chunk rows no revision references (e.g. uploads whose item batch never arrived)
+//   - files in the chunk store with no chunk row (e.g. chunks of deleted collections)
+//
+// Both are only collected once older than a grace period so in-flight uploads survive.
+type ChunkCollector struct {
+	chunkRepo repository.ChunkRepository
+	store     storage.ChunkStore
+}
+
+// NewChunkCollector creates a new chunk garbage collector
+func NewChunkCollector(chunkRepo repository.ChunkRepository, store storage.ChunkStore) *ChunkCollector {
+	return &ChunkCollector{
+		chunkRepo: chunkRepo,
+		store:     store,
+	}
+}
+
+// ChunkGCOptions controls a garbage collection run
+type ChunkGCOptions struct {
+	DryRun      bool          // Report what would be collected without deleting anything
+	GracePeriod time.Duration // Minimum age of anything collected
+}
+
+// ChunkGCReport summarizes a garbage collection run
+type ChunkGCReport struct {
+	DryRun         bool
+	OrphanRows     int      // Chunk rows not referenced by any revision
+	OrphanFiles    []string // Store keys of files with no chunk row
+	ReclaimedBytes int64    // Total size of OrphanFiles
+}
+
+// String returns a one-line summary of the report
+func (r *ChunkGCReport) String() string {
+	verb := "removed"
+	if r.DryRun {
+		verb = "would remove"
+	}
+	return fmt.Sprintf("chunk gc %s %d unreferenced chunks and %d orphaned files (%d bytes)",
+		verb, r.OrphanRows, len(r.OrphanFiles), r.ReclaimedBytes)
+}
+
+// Run performs a garbage collection pass
+func (c *ChunkCollector) Run(ctx context.Context, opts ChunkGCOptions) (*ChunkGCReport, error) {
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	report := &ChunkGCReport{DryRun: opts.DryRun}
+
+	// 1. Chunk rows no revision references
+	chunks, err := c.chunkRepo.ListUnreferenced(ctx, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	orphanIDs := make([]uint, len(chunks))
+	for i, chunk := range chunks {
+		orphanIDs[i] = chunk.ID
+	}
+	report.OrphanRows = len(chunks)
+
+	// In a dry run the rows stay, so ignore them when looking for orphaned files
+	var ignoreIDs []uint
+	if opts.DryRun {
+		ignoreIDs = orphanIDs
+	} else {
+		deleted, err := c.chunkRepo.DeleteUnreferenced(ctx, orphanIDs)
+		if err != nil {
+			return nil, err
+		}
+		report.OrphanRows = int(deleted)
+	}
+
+	// 2. Files with no chunk row
+	var batch []storage.ObjectInfo
+	flush := func() error {
+		if err := c.collectFiles(ctx, batch, ignoreIDs, opts.DryRun, report); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	err = c.store.Walk(ctx, func(obj storage.ObjectInfo) error {
+		// Uploads in progress have no chunk row yet
+		if obj.ModTime.After(cutoff) || strings.HasPrefix(obj.Key, storage.UploadPrefix) {
+			return nil
+		}
+		batch = append(batch, obj)
+		if len(batch) >= gcBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// collectFiles deletes (or in a dry run, reports) the files in objs that no chunk row holds
+func (c *ChunkCollector) collectFiles(
+	ctx context.Context,
+	objs []storage.ObjectInfo,
+	ignoreIDs []uint,
+	dryRun bool,
+	report *ChunkGCReport,
+) error {
+	if len(objs) == 0 {
+		return nil
+	}
+
+	var locations, hashes []string
+	for _, obj := range objs {
+		locations = append(locations, storage.StoredLocations(c.store, obj.Key)...)
+		if hash, ok := storage.BlobHash(obj.Key); ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	inUse, err := c.chunkRepo.FilesInUse(ctx, locations, ignoreIDs)
+	if err != nil {
+		return err
+	}
+	// The files of recorded blobs are kept too, whatever their chunk rows say
+	blobsInUse, err := c.chunkRepo.BlobsInUse(ctx, hashes, ignoreIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objs {
+		hash, _ := storage.BlobHash(obj.Key)
+		used := blobsInUse[hash]
+		for _, loc := range storage.StoredLocations(c.store, obj.Key) {
+			used = used || inUse[loc]
+		}
+		if used {
+			continue
+		}
+
+		if !dryRun {
+			if err := c.store.Delete(ctx, obj.Key); err != nil {
+				return err
+			}
+		}
+		report.OrphanFiles = append(report.OrphanFiles, obj.Key)
+		report.ReclaimedBytes += obj.Size
+	}
+	return nil
+}
+
+// RunJob performs a garbage collection pass and logs the result, for use as a background job
+func (c *ChunkCollector) RunJob(gracePeriod time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		report, err := c.Run(ctx, ChunkGCOptions{GracePeriod: gracePeriod})
+		if err != nil {
+			return err
+		}
+		log.Println(report)
+		return nil
+	}
+}
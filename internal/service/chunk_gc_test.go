@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"goatsync/internal/model"
+	"goatsync/internal/storage"
+)
+
+// MockChunkRepository is a mock implementation for testing
+type MockChunkRepository struct {
+	chunks    map[uint]*model.CollectionItemChunk
+	blobs     map[string]*model.ChunkBlob
+	revisions map[uint][]uint // chunk ID -> referencing revision IDs
+	relations []model.RevisionChunkRelation
+	nextID    uint
+}
+
+func NewMockChunkRepository() *MockChunkRepository {
+	return &MockChunkRepository{
+		chunks:    make(map[uint]*model.CollectionItemChunk),
+		blobs:     make(map[string]*model.ChunkBlob),
+		revisions: make(map[uint][]uint),
+	}
+}
+
+func (m *MockChunkRepository) Create(ctx context.Context, chunk *model.CollectionItemChunk) error {
+	m.nextID++
+	chunk.ID = m.nextID
+	if chunk.CreatedAt == nil {
+		now := time.Now()
+		chunk.CreatedAt = &now
+	}
+	if chunk.BlobID != nil {
+		for _, b := range m.blobs {
+			if b.ID == *chunk.BlobID {
+				chunk.Blob = b
+			}
+		}
+	}
+	m.chunks[chunk.ID] = chunk
+	return nil
+}
+
+func (m *MockChunkRepository) GetByUID(ctx context.Context, collectionID uint, uid string) (*model.CollectionItemChunk, error) {
+	for _, c := range m.chunks {
+		if c.CollectionID == collectionID && c.UID == uid {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockChunkRepository) AddRevisionChunks(ctx context.Context, revisionID uint, chunkIDs []uint) error {
+	for _, id := range chunkIDs {
+		m.revisions[id] = append(m.revisions[id], revisionID)
+		m.relations = append(m.relations, model.RevisionChunkRelation{
+			ID:         uint(len(m.relations) + 1),
+			ChunkID:    id,
+			RevisionID: revisionID,
+			Chunk:      m.chunks[id],
+		})
+	}
+	return nil
+}
+
+func (m *MockChunkRepository) ClaimBlob(ctx context.Context, blob *model.ChunkBlob) (bool, error) {
+	if known, ok := m.blobs[blob.Hash]; ok {
+		*blob = *known
+		return true, nil
+	}
+	blob.ID = uint(len(m.blobs) + 1)
+	stored := *blob
+	m.blobs[blob.Hash] = &stored
+	return false, nil
+}
+
+func (m *MockChunkRepository) ReleaseRevisionChunks(ctx context.Context, revisionIDs []uint) error {
+	kept := m.relations[:0]
+	for _, rel := range m.relations {
+		if !slices.Contains(revisionIDs, rel.RevisionID) {
+			kept = append(kept, rel)
+		}
+	}
+	m.relations = kept
+	for id, revs := range m.revisions {
+		m.revisions[id] = slices.DeleteFunc(revs, func(rev uint) bool {
+			return slices.Contains(revisionIDs, rev)
+		})
+	}
+	return nil
+}
+
+func (m *MockChunkRepository) ListUnreferenced(ctx context.Context, createdBefore time.Time) ([]model.CollectionItemChunk, error) {
+	var out []model.CollectionItemChunk
+	for id, c := range m.chunks {
+		if len(m.revisions[id]) == 0 && (c.CreatedAt == nil || c.CreatedAt.Before(createdBefore)) {
+			out = append(out, *c)
+		}
+	}
+	return out, nil
+}
+
+func (m *MockChunkRepository) DeleteUnreferenced(ctx context.Context, ids []uint) (int64, error) {
+	var deleted int64
+	for _, id := range ids {
+		if _, ok := m.chunks[id]; ok && len(m.revisions[id]) == 0 {
+			delete(m.chunks, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *MockChunkRepository) FilesInUse(ctx context.Context, locations []string, ignoreIDs []uint) (map[string]bool, error) {
+	ignored := make(map[uint]bool)
+	for _, id := range ignoreIDs {
+		ignored[id] = true
+	}
+	inUse := make(map[string]bool)
+	for _, loc := range locations {
+		for id, c := range m.chunks {
+			if c.ChunkFile == loc && !ignored[id] {
+				inUse[loc] = true
+			}
+		}
+	}
+	return inUse, nil
+}
+
+func (m *MockChunkRepository) BlobsInUse(ctx context.Context, hashes []string, ignoreIDs []uint) (map[string]bool, error) {
+	inUse := make(map[string]bool)
+	for _, hash := range hashes {
+		if _, ok := m.blobs[hash]; ok {
+			inUse[hash] = true
+		}
+	}
+	return inUse, nil
+}
+
+func (m *MockChunkRepository) Delete(ctx context.Context, id uint) error {
+	delete(m.chunks, id)
+	return nil
+}
+
+func TestChunkCollector_Run(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	store := storage.NewFileStorage(tempDir)
+	repo := NewMockChunkRepository()
+	old := time.Now().Add(-48 * time.Hour)
+
+	put := func(key string, modTime time.Time) {
+		t.Helper()
+		if err := store.Put(ctx, key, strings.NewReader("data"), 4); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := os.Chtimes(filepath.Join(tempDir, filepath.FromSlash(key)), modTime, modTime); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+
+	// Referenced chunk: kept
+	put(storage.BlobKey("aa11"), old)
+	_ = repo.Create(ctx, &model.CollectionItemChunk{UID: "used", ChunkFile: storage.BlobKey("aa11"), CreatedAt: &old})
+	_ = repo.AddRevisionChunks(ctx, 1, []uint{1})
+
+	// Unreferenced chunk past the grace period: row and file collected
+	put(storage.BlobKey("bb22"), old)
+	_ = repo.Create(ctx, &model.CollectionItemChunk{UID: "unused", ChunkFile: storage.BlobKey("bb22"), CreatedAt: &old})
+
+	// Orphaned file: collected
+	put(storage.ChunkKey(1, "deleted-collection", "chunk123"), old)
+
+	// Fresh file without a row (upload in flight): kept
+	put(storage.BlobKey("cc33"), time.Now())
+
+	// Data of a resumable upload in progress: kept
+	put(storage.UploadKey(7), old)
+
+	// File of a recorded blob whose chunk isn't visible yet: kept
+	put(storage.BlobKey("dd44"), old)
+	repo.blobs["dd44"] = &model.ChunkBlob{ID: 1, Hash: "dd44"}
+
+	collector := NewChunkCollector(repo, store)
+	expected := []string{storage.BlobKey("bb22"), storage.ChunkKey(1, "deleted-collection", "chunk123")}
+
+	// Dry run reports without deleting
+	report, err := collector.Run(ctx, ChunkGCOptions{DryRun: true, GracePeriod: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	sort.Strings(report.OrphanFiles)
+	if report.OrphanRows != 1 || !equalStrings(report.OrphanFiles, expected) || report.ReclaimedBytes != 8 {
+		t.Errorf("Unexpected dry run report: %+v", report)
+	}
+	if len(repo.chunks) != 2 {
+		t.Errorf("Dry run deleted chunk rows")
+	}
+	if exists, _ := store.Exists(ctx, storage.BlobKey("bb22")); !exists {
+		t.Errorf("Dry run deleted files")
+	}
+
+	// Real run
+	report, err = collector.Run(ctx, ChunkGCOptions{GracePeriod: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	sort.Strings(report.OrphanFiles)
+	if report.OrphanRows != 1 || !equalStrings(report.OrphanFiles, expected) {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	for _, key := range expected {
+		if exists, _ := store.Exists(ctx, key); exists {
+			t.Errorf("%s was not collected", key)
+		}
+	}
+	for _, key := range []string{storage.BlobKey("aa11"), storage.BlobKey("cc33")} {
+		if exists, _ := store.Exists(ctx, key); !exists {
+			t.Errorf("%s was collected", key)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
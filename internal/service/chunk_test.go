@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/storage"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// SHA-256 of "hello world"
+const helloWorldHash = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+// newTestChunkService returns a chunk service backed by a temporary
+// filesystem store, with user 1 admin of the collection "col"
+func newTestChunkService(t *testing.T) (*ChunkService, storage.ChunkStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	_ = collectionRepo.Create(ctx, &model.Collection{UID: "col", OwnerID: 1})
+	_ = memberRepo.Create(ctx, &model.CollectionMember{CollectionID: 1, UserID: 1, AccessLevel: model.AccessLevelAdmin})
+
+	store := storage.NewFileStorage(t.TempDir())
+	quotaService := NewQuotaService(NewMockQuotaRepository(), &config.Config{})
+	return NewChunkService(NewMockChunkRepository(), collectionRepo, memberRepo, MockTransactor{}, store, quotaService), store
+}
+
+func TestChunkService_DownloadCorrupted(t *testing.T) {
+	ctx := context.Background()
+	svc, store := newTestChunkService(t)
+
+	if err := svc.UploadChunk(ctx, "col", "item", "chunk", 1, strings.NewReader("hello world"), 11); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	download, err := svc.DownloadChunk(ctx, "col", "item", "chunk", 1)
+	if err != nil {
+		t.Fatalf("DownloadChunk failed: %v", err)
+	}
+	data, err := io.ReadAll(download.Content)
+	_ = download.Content.Close()
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("Expected %q, got %q, %v", "hello world", data, err)
+	}
+
+	// Damage the file without changing its size; it's refused before
+	// anything is served, whatever range is asked for
+	key := storage.BlobKey(helloWorldHash)
+	if err := store.Put(ctx, key, strings.NewReader("hello w0rld"), 11); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := svc.DownloadChunk(ctx, "col", "item", "chunk", 1); !errors.Is(err, pkgerrors.ErrChunkCorrupted) {
+		t.Errorf("Expected ErrChunkCorrupted, got %v", err)
+	}
+}
+
+func TestChunkService_EmptyChunk(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestChunkService(t)
+
+	// Stock clients may upload zero-length chunks
+	if err := svc.UploadChunk(ctx, "col", "item", "empty", 1, strings.NewReader(""), 0); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	download, err := svc.DownloadChunk(ctx, "col", "item", "empty", 1)
+	if err != nil {
+		t.Fatalf("DownloadChunk failed: %v", err)
+	}
+	data, err := io.ReadAll(download.Content)
+	_ = download.Content.Close()
+	if err != nil || len(data) != 0 {
+		t.Errorf("Expected an empty chunk, got %q, %v", data, err)
+	}
+}
+
+func TestChunkService_Dedup(t *testing.T) {
+	ctx := context.Background()
+	svc, store := newTestChunkService(t)
+
+	if err := svc.UploadChunk(ctx, "col", "item", "first", 1, strings.NewReader("hello world"), 11); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+
+	// A file left behind without a recorded blob is written again
+	key := storage.BlobKey(helloWorldHash)
+	chunkRepo := svc.chunkRepo.(*MockChunkRepository)
+	delete(chunkRepo.blobs, helloWorldHash)
+	if err := store.Put(ctx, key, strings.NewReader("stale"), 5); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := svc.UploadChunk(ctx, "col", "item", "second", 1, strings.NewReader("hello world"), 11); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	download, err := svc.DownloadChunk(ctx, "col", "item", "second", 1)
+	if err != nil {
+		t.Fatalf("DownloadChunk failed: %v", err)
+	}
+	_ = download.Content.Close()
+
+	// The file of a recorded blob is shared
+	if err := svc.UploadChunk(ctx, "col", "item", "third", 1, strings.NewReader("hello world"), 11); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	third, _ := chunkRepo.GetByUID(ctx, 1, "third")
+	second, _ := chunkRepo.GetByUID(ctx, 1, "second")
+	if third.ChunkFile != key || *third.BlobID != *second.BlobID {
+		t.Errorf("Expected the chunks to share a blob, got %+v and %+v", second, third)
+	}
+}
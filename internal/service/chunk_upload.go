@@ -0,0 +1,399 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/storage"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// ChunkUploadService handles resumable chunk uploads, a GoatSync extension
+// for clients on unreliable connections:
+//
+//  1. create an upload session for a chunk
+//  2. send the data in one or more pieces, each at the offset received so far
+//  3. after an interruption, query the offset and continue from there
+//  4. finalize, which stores the chunk just like a one-shot upload
+//
+// The data received so far is kept in the chunk store and requests for an
+// upload take turns through a lock on its row, so they may reach any instance.
+// Sessions that receive no data for the configured expiry are removed by
+// CleanupExpired.
+type ChunkUploadService struct {
+	uploadRepo     repository.ChunkUploadRepository
+	chunkRepo      repository.ChunkRepository
+	collectionRepo repository.CollectionRepository
+	memberRepo     repository.MemberRepository
+	transactor     repository.Transactor
+	chunkService   *ChunkService
+	quotaService   *QuotaService
+	store          storage.ChunkStore
+	cfg            *config.Config
+}
+
+// NewChunkUploadService creates a new resumable upload service
+func NewChunkUploadService(
+	uploadRepo repository.ChunkUploadRepository,
+	chunkRepo repository.ChunkRepository,
+	collectionRepo repository.CollectionRepository,
+	memberRepo repository.MemberRepository,
+	transactor repository.Transactor,
+	chunkService *ChunkService,
+	quotaService *QuotaService,
+	store storage.ChunkStore,
+	cfg *config.Config,
+) *ChunkUploadService {
+	return &ChunkUploadService{
+		uploadRepo:     uploadRepo,
+		chunkRepo:      chunkRepo,
+		collectionRepo: collectionRepo,
+		memberRepo:     memberRepo,
+		transactor:     transactor,
+		chunkService:   chunkService,
+		quotaService:   quotaService,
+		store:          store,
+		cfg:            cfg,
+	}
+}
+
+// ChunkUploadOut represents a resumable upload in API responses
+type ChunkUploadOut struct {
+	Offset int64 `msgpack:"offset"` // Bytes received so far; the next piece starts here
+}
+
+// CreateUpload starts a resumable upload of a chunk.
+// If the user already has an upload of the chunk in progress, it's returned instead.
+func (s *ChunkUploadService) CreateUpload(
+	ctx context.Context,
+	collectionUID, itemUID, chunkUID string,
+	userID uint,
+) (*ChunkUploadOut, error) {
+	col, err := s.writableCollection(ctx, collectionUID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.chunkRepo.GetByUID(ctx, col.ID, chunkUID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, pkgerrors.ErrChunkExists
+	}
+
+	upload, err := s.uploadRepo.GetByChunk(ctx, col.ID, chunkUID)
+	if err != nil {
+		return nil, err
+	}
+	if upload != nil && upload.ExpiresAt.After(time.Now()) {
+		if upload.UserID != userID {
+			return nil, pkgerrors.ErrChunkExists.WithDetail("Chunk is already being uploaded.")
+		}
+		return &ChunkUploadOut{Offset: upload.Offset}, nil
+	}
+	if upload != nil {
+		if err := s.remove(ctx, upload.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	upload = &model.ChunkUpload{
+		CollectionID: col.ID,
+		ChunkUID:     chunkUID,
+		UserID:       userID,
+		ExpiresAt:    time.Now().Add(s.cfg.ChunkUploadExpiry),
+	}
+	if err := s.uploadRepo.Create(ctx, upload); err != nil {
+		return nil, err
+	}
+	return &ChunkUploadOut{Offset: 0}, nil
+}
+
+// GetUpload returns the state of a resumable upload
+func (s *ChunkUploadService) GetUpload(
+	ctx context.Context,
+	collectionUID, itemUID, chunkUID string,
+	userID uint,
+) (*ChunkUploadOut, error) {
+	col, err := s.writableCollection(ctx, collectionUID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := s.activeUpload(ctx, col.ID, chunkUID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &ChunkUploadOut{Offset: upload.Offset}, nil
+}
+
+// AppendUpload adds data to a resumable upload at offset, which must be the
+// number of bytes received so far. size is the length of data, or -1 if unknown.
+//
+// If the connection drops part-way, the bytes that did arrive are kept and the
+// upload can be continued from its new offset.
+func (s *ChunkUploadService) AppendUpload(
+	ctx context.Context,
+	collectionUID, itemUID, chunkUID string,
+	userID uint,
+	offset int64,
+	data io.Reader,
+	size int64,
+) (*ChunkUploadOut, error) {
+	col, err := s.writableCollection(ctx, collectionUID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := s.activeUpload(ctx, col.ID, chunkUID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return nil, offsetMismatch(upload.Offset)
+	}
+
+	// Don't accept more partial data than the owner could store,
+	// even when the length of the piece isn't known up front
+	if size > 0 {
+		if err := s.quotaService.Check(ctx, col.OwnerID, model.QuotaChunkBytes, offset+size); err != nil {
+			return nil, err
+		}
+	}
+	remaining, err := s.quotaService.Remaining(ctx, col.OwnerID, model.QuotaChunkBytes)
+	if err != nil {
+		return nil, err
+	}
+	if remaining >= 0 {
+		data = io.LimitReader(data, max(remaining-offset, 0)+1)
+	}
+
+	// Buffer the piece first, keeping what arrived if the connection drops
+	body := &partialReader{r: data}
+	spool, err := storage.NewSpool(body)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = spool.Close() }()
+	if err := s.quotaService.Check(ctx, col.OwnerID, model.QuotaChunkBytes, offset+spool.Size); err != nil {
+		return nil, err
+	}
+
+	if spool.Size > 0 {
+		err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			if err := s.uploadRepo.LockForWrite(ctx, upload.ID); err != nil {
+				return err
+			}
+			// Another request may have moved the upload on while this one waited
+			current, err := s.activeUpload(ctx, col.ID, chunkUID, userID)
+			if err != nil {
+				return err
+			}
+			if current.ID != upload.ID || current.Offset != offset {
+				return offsetMismatch(current.Offset)
+			}
+
+			if err := s.writePartial(ctx, upload.ID, offset, spool); err != nil {
+				return err
+			}
+			advanced, err := s.uploadRepo.Advance(ctx, upload.ID, offset, offset+spool.Size, time.Now().Add(s.cfg.ChunkUploadExpiry))
+			if err != nil {
+				return err
+			}
+			if !advanced {
+				return pkgerrors.ErrUploadOffsetMismatch
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if body.err != nil {
+		return nil, fmt.Errorf("failed to read chunk data: %w", body.err)
+	}
+
+	return &ChunkUploadOut{Offset: offset + spool.Size}, nil
+}
+
+// FinalizeUpload stores the data of a resumable upload as the chunk and ends the upload
+func (s *ChunkUploadService) FinalizeUpload(
+	ctx context.Context,
+	collectionUID, itemUID, chunkUID string,
+	userID uint,
+) error {
+	col, err := s.writableCollection(ctx, collectionUID, userID)
+	if err != nil {
+		return err
+	}
+
+	upload, err := s.activeUpload(ctx, col.ID, chunkUID, userID)
+	if err != nil {
+		return err
+	}
+
+	// The data up to the offset doesn't change any more, whatever other
+	// requests for the upload do meanwhile
+	partial, err := s.store.Get(ctx, storage.UploadKey(upload.ID))
+	if errors.Is(err, storage.ErrChunkNotFound) {
+		return pkgerrors.ErrChunkNoContent
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = partial.Close() }()
+
+	// Ignore anything past the offset left by an interrupted write
+	data := io.LimitReader(partial, upload.Offset)
+	if err := s.chunkService.UploadChunk(ctx, collectionUID, itemUID, chunkUID, userID, data, upload.Offset); err != nil {
+		return err
+	}
+
+	return s.remove(ctx, upload.ID)
+}
+
+// CancelUpload abandons a resumable upload and discards its data
+func (s *ChunkUploadService) CancelUpload(
+	ctx context.Context,
+	collectionUID, itemUID, chunkUID string,
+	userID uint,
+) error {
+	col, err := s.writableCollection(ctx, collectionUID, userID)
+	if err != nil {
+		return err
+	}
+
+	upload, err := s.activeUpload(ctx, col.ID, chunkUID, userID)
+	if err != nil {
+		return err
+	}
+	return s.remove(ctx, upload.ID)
+}
+
+// CleanupExpired removes expired uploads and their data.
+// Returns the number of uploads removed.
+func (s *ChunkUploadService) CleanupExpired(ctx context.Context) (int, error) {
+	uploads, err := s.uploadRepo.ListExpired(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range uploads {
+		if err := s.remove(ctx, uploads[i].ID); err != nil {
+			return i, err
+		}
+	}
+	return len(uploads), nil
+}
+
+// CleanupJob removes expired uploads and logs the result, for use as a background job
+func (s *ChunkUploadService) CleanupJob() func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		removed, err := s.CleanupExpired(ctx)
+		if removed > 0 {
+			log.Printf("chunk upload cleanup removed %d expired uploads", removed)
+		}
+		return err
+	}
+}
+
+// writableCollection returns the collection if the user may write to it
+func (s *ChunkUploadService) writableCollection(ctx context.Context, collectionUID string, userID uint) (*model.Collection, error) {
+	col, err := s.collectionRepo.GetByUID(ctx, collectionUID)
+	if err != nil {
+		return nil, err
+	}
+	if col == nil {
+		return nil, pkgerrors.ErrNotMember
+	}
+
+	member, err := s.memberRepo.GetByUserAndCollection(ctx, userID, col.ID)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		return nil, pkgerrors.ErrNotMember
+	}
+	if !member.CanWrite() {
+		return nil, pkgerrors.ErrNoWriteAccess
+	}
+	return col, nil
+}
+
+// activeUpload returns the user's unexpired upload of a chunk
+func (s *ChunkUploadService) activeUpload(ctx context.Context, collectionID uint, chunkUID string, userID uint) (*model.ChunkUpload, error) {
+	upload, err := s.uploadRepo.GetByChunk(ctx, collectionID, chunkUID)
+	if err != nil {
+		return nil, err
+	}
+	if upload == nil || upload.UserID != userID || !upload.ExpiresAt.After(time.Now()) {
+		return nil, pkgerrors.ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// writePartial stores the upload's data up to offset followed by the spooled piece
+func (s *ChunkUploadService) writePartial(ctx context.Context, uploadID uint, offset int64, spool *storage.Spool) error {
+	piece, err := spool.Reader()
+	if err != nil {
+		return err
+	}
+	key := storage.UploadKey(uploadID)
+	if offset == 0 {
+		return s.store.Put(ctx, key, piece, spool.Size)
+	}
+
+	partial, err := s.store.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to open upload data: %w", err)
+	}
+	defer func() { _ = partial.Close() }()
+
+	// Ignore anything past the offset left by an interrupted write
+	return s.store.Put(ctx, key, io.MultiReader(io.LimitReader(partial, offset), piece), offset+spool.Size)
+}
+
+// remove deletes an upload and its data
+func (s *ChunkUploadService) remove(ctx context.Context, id uint) error {
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// An append in progress finishes first, so it can't write the data back
+		if err := s.uploadRepo.LockForWrite(ctx, id); err != nil {
+			return err
+		}
+		if err := s.store.Delete(ctx, storage.UploadKey(id)); err != nil {
+			return err
+		}
+		return s.uploadRepo.Delete(ctx, id)
+	})
+}
+
+// offsetMismatch returns ErrUploadOffsetMismatch reporting the current offset
+func offsetMismatch(offset int64) error {
+	return pkgerrors.ErrUploadOffsetMismatch.WithDetail(
+		fmt.Sprintf("Upload offset doesn't match the data received so far (%d bytes)", offset))
+}
+
+// partialReader reads r until it fails, then reports the end of the data so
+// the bytes received before the failure can be kept. The failure is kept in err.
+type partialReader struct {
+	r   io.Reader
+	err error
+}
+
+// Read implements io.Reader
+func (p *partialReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		p.err = err
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,445 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/storage"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// MockCollectionRepository is a mock implementation for testing
+type MockCollectionRepository struct {
+	collections map[uint]*model.Collection
+	stokens     map[uint]*model.Stoken
+	nextID      uint
+
+	// Memberships GetForUser attaches, when set
+	members *MockMemberRepository
+
+	// Items and revisions Purge deletes, when set
+	items *MockItemRepository
+
+	// The page both list methods return
+	listed       []model.Collection
+	listedStoken *model.Stoken
+	listedMore   bool
+}
+
+func NewMockCollectionRepository() *MockCollectionRepository {
+	return &MockCollectionRepository{
+		collections: make(map[uint]*model.Collection),
+		stokens:     make(map[uint]*model.Stoken),
+	}
+}
+
+func (m *MockCollectionRepository) Create(ctx context.Context, collection *model.Collection) error {
+	m.nextID++
+	collection.ID = m.nextID
+	m.collections[collection.ID] = collection
+	return nil
+}
+
+func (m *MockCollectionRepository) GetByID(ctx context.Context, id uint) (*model.Collection, error) {
+	return m.collections[id], nil
+}
+
+func (m *MockCollectionRepository) GetByUID(ctx context.Context, uid string) (*model.Collection, error) {
+	for _, c := range m.collections {
+		if c.UID == uid {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockCollectionRepository) GetForUser(ctx context.Context, userID uint, uid string) (*model.Collection, error) {
+	col, _ := m.GetByUID(ctx, uid)
+	if col == nil || m.members == nil {
+		return nil, nil
+	}
+	member, _ := m.members.GetByUserAndCollection(ctx, userID, col.ID)
+	if member == nil {
+		return nil, nil
+	}
+	out := *col
+	out.Members = []model.CollectionMember{*member}
+	return &out, nil
+}
+
+func (m *MockCollectionRepository) ListForUser(ctx context.Context, userID uint, stoken string, limit int) ([]model.Collection, *model.Stoken, bool, error) {
+	return m.listed, m.listedStoken, !m.listedMore, nil
+}
+
+func (m *MockCollectionRepository) ListByTypes(ctx context.Context, userID uint, typeUIDs [][]byte, stoken string, limit int) ([]model.Collection, *model.Stoken, bool, error) {
+	return m.listed, m.listedStoken, !m.listedMore, nil
+}
+
+func (m *MockCollectionRepository) LockForWrite(ctx context.Context, id uint) error {
+	return nil
+}
+
+func (m *MockCollectionRepository) GetStoken(ctx context.Context, id uint) (*model.Stoken, error) {
+	return m.stokens[id], nil
+}
+
+func (m *MockCollectionRepository) GetStokens(ctx context.Context, ids []uint) (map[uint]*model.Stoken, error) {
+	stokens := make(map[uint]*model.Stoken)
+	for _, id := range ids {
+		if stoken, ok := m.stokens[id]; ok {
+			stokens[id] = stoken
+		}
+	}
+	return stokens, nil
+}
+
+func (m *MockCollectionRepository) Update(ctx context.Context, collection *model.Collection) error {
+	m.collections[collection.ID] = collection
+	return nil
+}
+
+func (m *MockCollectionRepository) Delete(ctx context.Context, id uint) error {
+	delete(m.collections, id)
+	return nil
+}
+
+func (m *MockCollectionRepository) TransferOwnership(ctx context.Context, id, userID uint) error {
+	if col := m.collections[id]; col != nil {
+		col.OwnerID = userID
+	}
+	return nil
+}
+
+func (m *MockCollectionRepository) MarkRemoved(ctx context.Context, id uint, at time.Time) error {
+	if col := m.collections[id]; col != nil {
+		col.RemovedAt = &at
+	}
+	return nil
+}
+
+func (m *MockCollectionRepository) ListRemoved(ctx context.Context, before time.Time) ([]model.Collection, error) {
+	var out []model.Collection
+	for _, col := range m.collections {
+		if col.RemovedAt != nil && col.RemovedAt.Before(before) && col.PurgedAt == nil {
+			out = append(out, *col)
+		}
+	}
+	return out, nil
+}
+
+func (m *MockCollectionRepository) Purge(ctx context.Context, id uint) (int64, error) {
+	col := m.collections[id]
+	if col == nil {
+		return 0, nil
+	}
+	now := time.Now()
+	col.PurgedAt = &now
+	if m.items == nil {
+		return 0, nil
+	}
+
+	var items int64
+	kept := m.items.items[:0]
+	for _, item := range m.items.items {
+		if item.CollectionID != id {
+			kept = append(kept, item)
+			continue
+		}
+		if col.MainItemID == nil || item.ID != *col.MainItemID {
+			items++
+		}
+		revisions := m.items.revisionRepo.revisions[:0]
+		for _, rev := range m.items.revisionRepo.revisions {
+			if rev.ItemID != item.ID {
+				revisions = append(revisions, rev)
+			}
+		}
+		m.items.revisionRepo.revisions = revisions
+	}
+	m.items.items = kept
+	return items, nil
+}
+
+// MockMemberRepository is a mock implementation for testing
+type MockMemberRepository struct {
+	members map[uint]*model.CollectionMember
+	removed []model.CollectionMemberRemoved
+	nextID  uint
+}
+
+func NewMockMemberRepository() *MockMemberRepository {
+	return &MockMemberRepository{members: make(map[uint]*model.CollectionMember)}
+}
+
+func (m *MockMemberRepository) Create(ctx context.Context, member *model.CollectionMember) error {
+	m.removed = slices.DeleteFunc(m.removed, func(rm model.CollectionMemberRemoved) bool {
+		return rm.CollectionID == member.CollectionID && rm.UserID == member.UserID
+	})
+	m.nextID++
+	member.ID = m.nextID
+	m.members[member.ID] = member
+	return nil
+}
+
+func (m *MockMemberRepository) GetByID(ctx context.Context, id uint) (*model.CollectionMember, error) {
+	return m.members[id], nil
+}
+
+func (m *MockMemberRepository) GetByUserAndCollection(ctx context.Context, userID, collectionID uint) (*model.CollectionMember, error) {
+	for _, member := range m.members {
+		if member.UserID == userID && member.CollectionID == collectionID {
+			return member, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetByUsernameAndCollection matches members created with their User set
+func (m *MockMemberRepository) GetByUsernameAndCollection(ctx context.Context, username string, collectionID uint) (*model.CollectionMember, error) {
+	for _, member := range m.members {
+		if member.User != nil && member.User.Username == username && member.CollectionID == collectionID {
+			return member, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockMemberRepository) ListForCollection(ctx context.Context, collectionID uint) ([]model.CollectionMember, error) {
+	var out []model.CollectionMember
+	for _, member := range m.members {
+		if member.CollectionID == collectionID {
+			out = append(out, *member)
+		}
+	}
+	return out, nil
+}
+
+func (m *MockMemberRepository) Update(ctx context.Context, member *model.CollectionMember) error {
+	m.members[member.ID] = member
+	return nil
+}
+
+func (m *MockMemberRepository) Delete(ctx context.Context, id uint) error {
+	if member := m.members[id]; member != nil {
+		m.removed = append(m.removed, model.CollectionMemberRemoved{CollectionID: member.CollectionID, UserID: member.UserID})
+	}
+	delete(m.members, id)
+	return nil
+}
+
+// GetRemovedMemberships returns all of the user's removals; tests only
+// record removals newer than the stoken they list from
+func (m *MockMemberRepository) GetRemovedMemberships(ctx context.Context, userID uint, stoken string) ([]model.CollectionMemberRemoved, error) {
+	var out []model.CollectionMemberRemoved
+	for _, rm := range m.removed {
+		if rm.UserID == userID && stoken != "" {
+			out = append(out, rm)
+		}
+	}
+	return out, nil
+}
+
+// MockChunkUploadRepository is a mock implementation for testing
+type MockChunkUploadRepository struct {
+	uploads map[uint]*model.ChunkUpload
+	nextID  uint
+}
+
+func NewMockChunkUploadRepository() *MockChunkUploadRepository {
+	return &MockChunkUploadRepository{uploads: make(map[uint]*model.ChunkUpload)}
+}
+
+func (m *MockChunkUploadRepository) Create(ctx context.Context, upload *model.ChunkUpload) error {
+	m.nextID++
+	upload.ID = m.nextID
+	stored := *upload
+	m.uploads[upload.ID] = &stored
+	return nil
+}
+
+func (m *MockChunkUploadRepository) GetByChunk(ctx context.Context, collectionID uint, chunkUID string) (*model.ChunkUpload, error) {
+	for _, u := range m.uploads {
+		if u.CollectionID == collectionID && u.ChunkUID == chunkUID {
+			upload := *u
+			return &upload, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockChunkUploadRepository) LockForWrite(ctx context.Context, id uint) error {
+	return nil
+}
+
+func (m *MockChunkUploadRepository) Advance(ctx context.Context, id uint, from, to int64, expiresAt time.Time) (bool, error) {
+	u, ok := m.uploads[id]
+	if !ok || u.Offset != from {
+		return false, nil
+	}
+	u.Offset = to
+	u.ExpiresAt = expiresAt
+	return true, nil
+}
+
+func (m *MockChunkUploadRepository) ListExpired(ctx context.Context, before time.Time) ([]model.ChunkUpload, error) {
+	var out []model.ChunkUpload
+	for _, u := range m.uploads {
+		if u.ExpiresAt.Before(before) {
+			out = append(out, *u)
+		}
+	}
+	return out, nil
+}
+
+func (m *MockChunkUploadRepository) Delete(ctx context.Context, id uint) error {
+	delete(m.uploads, id)
+	return nil
+}
+
+func TestChunkUploadService_Resume(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{ChunkUploadExpiry: time.Hour}
+
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	chunkRepo := NewMockChunkRepository()
+	uploadRepo := NewMockChunkUploadRepository()
+	quotaService := NewQuotaService(NewMockQuotaRepository(), cfg)
+	store := storage.NewFileStorage(t.TempDir())
+
+	_ = collectionRepo.Create(ctx, &model.Collection{UID: "col", OwnerID: 1})
+	_ = memberRepo.Create(ctx, &model.CollectionMember{CollectionID: 1, UserID: 1, AccessLevel: model.AccessLevelAdmin})
+
+	chunkService := NewChunkService(chunkRepo, collectionRepo, memberRepo, MockTransactor{}, store, quotaService)
+	svc := NewChunkUploadService(uploadRepo, chunkRepo, collectionRepo, memberRepo, MockTransactor{}, chunkService, quotaService, store, cfg)
+
+	out, err := svc.CreateUpload(ctx, "col", "item", "chunk", 1)
+	if err != nil || out.Offset != 0 {
+		t.Fatalf("CreateUpload: got %+v, %v", out, err)
+	}
+
+	// First piece arrives in full
+	out, err = svc.AppendUpload(ctx, "col", "item", "chunk", 1, 0, strings.NewReader("hello "), 6)
+	if err != nil || out.Offset != 6 {
+		t.Fatalf("AppendUpload: got %+v, %v", out, err)
+	}
+
+	// Second piece is cut off; what arrived is kept
+	cut := io.MultiReader(strings.NewReader("wo"), iotest.ErrReader(errors.New("connection reset")))
+	if _, err := svc.AppendUpload(ctx, "col", "item", "chunk", 1, 6, cut, 5); err == nil {
+		t.Fatal("Expected interrupted AppendUpload to fail")
+	}
+	out, err = svc.GetUpload(ctx, "col", "item", "chunk", 1)
+	if err != nil || out.Offset != 8 {
+		t.Fatalf("GetUpload after interruption: got %+v, %v", out, err)
+	}
+
+	// Resending from the wrong offset is rejected
+	_, err = svc.AppendUpload(ctx, "col", "item", "chunk", 1, 6, strings.NewReader("world"), 5)
+	var etebaseErr *pkgerrors.EtebaseError
+	if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrUploadOffsetMismatch.Code {
+		t.Fatalf("Expected upload_offset_mismatch, got %v", err)
+	}
+
+	// Resuming from the reported offset completes the chunk
+	if _, err := svc.AppendUpload(ctx, "col", "item", "chunk", 1, 8, strings.NewReader("rld"), 3); err != nil {
+		t.Fatalf("Resumed AppendUpload failed: %v", err)
+	}
+	if err := svc.FinalizeUpload(ctx, "col", "item", "chunk", 1); err != nil {
+		t.Fatalf("FinalizeUpload failed: %v", err)
+	}
+
+	download, err := chunkService.DownloadChunk(ctx, "col", "item", "chunk", 1)
+	if err != nil {
+		t.Fatalf("DownloadChunk failed: %v", err)
+	}
+	data, err := io.ReadAll(download.Content)
+	_ = download.Content.Close()
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("Expected %q, got %q, %v", "hello world", data, err)
+	}
+
+	// The upload is gone once finalized
+	if len(uploadRepo.uploads) != 0 {
+		t.Errorf("Upload not removed after finalizing")
+	}
+	if _, err := svc.GetUpload(ctx, "col", "item", "chunk", 1); !errors.Is(err, pkgerrors.ErrUploadNotFound) {
+		t.Errorf("Expected ErrUploadNotFound, got %v", err)
+	}
+}
+
+func TestChunkUploadService_Quota(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{ChunkUploadExpiry: time.Hour, QuotaChunkBytes: 8}
+
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	quotaService := NewQuotaService(NewMockQuotaRepository(), cfg)
+	store := storage.NewFileStorage(t.TempDir())
+
+	_ = collectionRepo.Create(ctx, &model.Collection{UID: "col", OwnerID: 1})
+	_ = memberRepo.Create(ctx, &model.CollectionMember{CollectionID: 1, UserID: 1, AccessLevel: model.AccessLevelAdmin})
+
+	chunkService := NewChunkService(NewMockChunkRepository(), collectionRepo, memberRepo, MockTransactor{}, store, quotaService)
+	svc := NewChunkUploadService(NewMockChunkUploadRepository(), NewMockChunkRepository(), collectionRepo, memberRepo,
+		MockTransactor{}, chunkService, quotaService, store, cfg)
+
+	if _, err := svc.CreateUpload(ctx, "col", "item", "chunk", 1); err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+	if _, err := svc.AppendUpload(ctx, "col", "item", "chunk", 1, 0, strings.NewReader("hello "), -1); err != nil {
+		t.Fatalf("AppendUpload failed: %v", err)
+	}
+
+	// A piece of unknown length is cut off once past the owner's quota
+	_, err := svc.AppendUpload(ctx, "col", "item", "chunk", 1, 6, strings.NewReader("world"), -1)
+	var etebaseErr *pkgerrors.EtebaseError
+	if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrQuotaExceeded.Code {
+		t.Fatalf("Expected quota_exceeded, got %v", err)
+	}
+	if out, err := svc.GetUpload(ctx, "col", "item", "chunk", 1); err != nil || out.Offset != 6 {
+		t.Errorf("Expected the upload to stay at offset 6, got %+v, %v", out, err)
+	}
+}
+
+func TestChunkUploadService_CleanupExpired(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{ChunkUploadExpiry: time.Hour}
+
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	uploadRepo := NewMockChunkUploadRepository()
+	store := storage.NewFileStorage(t.TempDir())
+	svc := NewChunkUploadService(uploadRepo, NewMockChunkRepository(), collectionRepo, memberRepo, MockTransactor{}, nil, nil, store, cfg)
+
+	stale := &model.ChunkUpload{CollectionID: 1, ChunkUID: "stale", UserID: 1, Offset: 4, ExpiresAt: time.Now().Add(-time.Minute)}
+	fresh := &model.ChunkUpload{CollectionID: 1, ChunkUID: "fresh", UserID: 1, Offset: 4, ExpiresAt: time.Now().Add(time.Hour)}
+	for _, u := range []*model.ChunkUpload{stale, fresh} {
+		_ = uploadRepo.Create(ctx, u)
+		if err := store.Put(ctx, storage.UploadKey(u.ID), strings.NewReader("data"), 4); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	removed, err := svc.CleanupExpired(ctx)
+	if err != nil || removed != 1 {
+		t.Fatalf("CleanupExpired: got %d, %v", removed, err)
+	}
+	if _, ok := uploadRepo.uploads[fresh.ID]; !ok {
+		t.Error("Fresh upload was removed")
+	}
+	if exists, _ := store.Exists(ctx, storage.UploadKey(stale.ID)); exists {
+		t.Error("Expired upload data was not removed")
+	}
+	if exists, _ := store.Exists(ctx, storage.UploadKey(fresh.ID)); !exists {
+		t.Error("Fresh upload data was removed")
+	}
+}
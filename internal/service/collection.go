@@ -2,26 +2,54 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"goatsync/internal/config"
 	"goatsync/internal/model"
 	"goatsync/internal/repository"
+	pkgerrors "goatsync/pkg/errors"
+
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 // CollectionService handles collection business logic
 type CollectionService struct {
-	collectionRepo repository.CollectionRepository
-	cfg            *config.Config
+	collectionRepo     repository.CollectionRepository
+	itemRepo           repository.ItemRepository
+	revisionRepo       repository.RevisionRepository
+	memberRepo         repository.MemberRepository
+	collectionTypeRepo repository.CollectionTypeRepository
+	invitationRepo     repository.InvitationRepository
+	transactor         repository.Transactor
+	chunkService       *ChunkService
+	quotaService       *QuotaService
+	cfg                *config.Config
 }
 
 // NewCollectionService creates a new collection service
 func NewCollectionService(
 	collectionRepo repository.CollectionRepository,
+	itemRepo repository.ItemRepository,
+	revisionRepo repository.RevisionRepository,
+	memberRepo repository.MemberRepository,
+	collectionTypeRepo repository.CollectionTypeRepository,
+	invitationRepo repository.InvitationRepository,
+	transactor repository.Transactor,
+	chunkService *ChunkService,
+	quotaService *QuotaService,
 	cfg *config.Config,
 ) *CollectionService {
 	return &CollectionService{
-		collectionRepo: collectionRepo,
-		cfg:            cfg,
+		collectionRepo:     collectionRepo,
+		itemRepo:           itemRepo,
+		revisionRepo:       revisionRepo,
+		memberRepo:         memberRepo,
+		collectionTypeRepo: collectionTypeRepo,
+		invitationRepo:     invitationRepo,
+		transactor:         transactor,
+		chunkService:       chunkService,
+		quotaService:       quotaService,
+		cfg:                cfg,
 	}
 }
 
@@ -33,11 +61,14 @@ type CollectionListResponse struct {
 	RemovedMemberships []RemovedOut    `msgpack:"removedMemberships,omitempty"`
 }
 
-// CollectionOut represents a collection in API responses
+// CollectionOut represents a collection in API responses, as seen by the
+// requesting member
 type CollectionOut struct {
-	Item        ItemOut `msgpack:"item"`
-	AccessLevel string  `msgpack:"accessLevel"`
-	Stoken      string  `msgpack:"stoken"`
+	CollectionKey  []byte  `msgpack:"collectionKey"`
+	CollectionType []byte  `msgpack:"collectionType"`
+	AccessLevel    string  `msgpack:"accessLevel"`
+	Stoken         string  `msgpack:"stoken"` // The collection's newest revision or membership stoken
+	Item           ItemOut `msgpack:"item"`
 }
 
 // RemovedOut represents a removed membership
@@ -47,10 +78,10 @@ type RemovedOut struct {
 
 // ItemOut represents an item in API responses
 type ItemOut struct {
-	UID     string      `msgpack:"uid"`
-	Version uint16      `msgpack:"version"`
-	Etag    string      `msgpack:"etag"`
-	Content ContentOut  `msgpack:"content"`
+	UID     string     `msgpack:"uid"`
+	Version uint16     `msgpack:"version"`
+	Etag    string     `msgpack:"etag"`
+	Content ContentOut `msgpack:"content"`
 }
 
 // ContentOut represents item content in API responses
@@ -58,12 +89,22 @@ type ContentOut struct {
 	UID     string     `msgpack:"uid"`
 	Meta    []byte     `msgpack:"meta"`
 	Deleted bool       `msgpack:"deleted"`
-	Chunks  []ChunkOut `msgpack:"chunks,omitempty"`
+	Chunks  []ChunkOut `msgpack:"chunks"`
 }
 
-// ChunkOut represents a chunk reference in API responses
+// ChunkOut represents a chunk reference in API responses.
+// Like the reference server, it's sent as a [uid] array, or as
+// [uid, content] when the content is prefetched.
 type ChunkOut struct {
-	UID string `msgpack:"uid"`
+	UID     string
+	Content []byte
+
+	chunk *model.CollectionItemChunk // Where Content is read from when prefetching
+}
+
+// EncodeMsgpack encodes the chunk as a [uid] or [uid, content] array
+func (c ChunkOut) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encodeChunk(enc, c.UID, c.Content)
 }
 
 // ListCollections lists collections for a user
@@ -72,63 +113,53 @@ func (s *CollectionService) ListCollections(
 	userID uint,
 	stoken string,
 	limit int,
+	prefetch Prefetch,
 ) (*CollectionListResponse, error) {
 	collections, newStoken, done, err := s.collectionRepo.ListForUser(ctx, userID, stoken, limit)
 	if err != nil {
 		return nil, err
 	}
-
-	// Convert to API response format
-	data := make([]CollectionOut, len(collections))
-	for i, col := range collections {
-		data[i] = s.collectionToOut(&col)
-	}
-
-	var stokenStr *string
-	if newStoken != nil {
-		stokenStr = &newStoken.UID
-	}
-
-	return &CollectionListResponse{
-		Data:   data,
-		Stoken: stokenStr,
-		Done:   done,
-	}, nil
+	return s.listResponse(ctx, userID, stoken, collections, newStoken, done, prefetch)
 }
 
-// GetCollection retrieves a single collection by UID
+// GetCollection retrieves a single collection the user is a member of
 func (s *CollectionService) GetCollection(
 	ctx context.Context,
 	uid string,
-) (*model.Collection, error) {
-	return s.collectionRepo.GetByUID(ctx, uid)
-}
-
-// CollectionCreateRequest is the request for creating a collection
-type CollectionCreateRequest struct {
-	Collection CollectionIn `msgpack:"collection"`
-	Item       ItemIn       `msgpack:"item"`
-}
+	userID uint,
+	prefetch Prefetch,
+) (*CollectionOut, error) {
+	col, err := s.collectionRepo.GetForUser(ctx, userID, uid)
+	if err != nil {
+		return nil, err
+	}
+	if col == nil {
+		return nil, pkgerrors.ErrNotMember
+	}
 
-// CollectionIn represents collection data in requests
-type CollectionIn struct {
-	UID            string `msgpack:"uid"`
-	CollectionType string `msgpack:"collectionType,omitempty"`
+	stoken, err := s.collectionRepo.GetStoken(ctx, col.ID)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.collectionToOut(ctx, col, stoken, prefetch)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
 }
 
-// ItemIn represents item data in requests
-type ItemIn struct {
-	UID     string          `msgpack:"uid"`
-	Version uint16          `msgpack:"version"`
-	Etag    *string         `msgpack:"etag,omitempty"`
-	Content CollectionContent `msgpack:"content"`
+// CollectionCreateRequest is the request for creating a collection.
+// The collection takes the UID of its main item.
+type CollectionCreateRequest struct {
+	CollectionType []byte      `msgpack:"collectionType"` // Encrypted type UID, resolved per owner
+	CollectionKey  []byte      `msgpack:"collectionKey"`  // The owner's encrypted collection key
+	Item           ItemBatchIn `msgpack:"item"`
 }
 
-// CollectionContent represents collection item content
-type CollectionContent struct {
-	UID     string   `msgpack:"uid"`
-	Meta    []byte   `msgpack:"meta"`
-	Chunks  []string `msgpack:"chunks,omitempty"`
+// CollectionTransferRequest is the request for transferring a collection
+// to another of its admins (GoatSync extension)
+type CollectionTransferRequest struct {
+	Username string `msgpack:"username"` // The new owner
 }
 
 // ListMultiRequest is the request for listing collections by types
@@ -143,73 +174,381 @@ func (s *CollectionService) ListMultiCollections(
 	typeUIDs [][]byte,
 	stoken string,
 	limit int,
+	prefetch Prefetch,
 ) (*CollectionListResponse, error) {
 	collections, newStoken, done, err := s.collectionRepo.ListByTypes(ctx, userID, typeUIDs, stoken, limit)
 	if err != nil {
 		return nil, err
 	}
+	return s.listResponse(ctx, userID, stoken, collections, newStoken, done, prefetch)
+}
 
-	data := make([]CollectionOut, len(collections))
+// listResponse builds a collection list response from a page of collections.
+//
+// Following a stoken, the response also names the collections the user was
+// removed from since, so their other devices drop them. Removals past an
+// unfinished page wait for a later one; on the last page, the newest removal
+// moves the returned stoken on, so it isn't reported again.
+func (s *CollectionService) listResponse(
+	ctx context.Context,
+	userID uint,
+	stoken string,
+	collections []model.Collection,
+	newStoken *model.Stoken,
+	done bool,
+	prefetch Prefetch,
+) (*CollectionListResponse, error) {
+	ids := make([]uint, len(collections))
 	for i, col := range collections {
-		data[i] = s.collectionToOut(&col)
+		ids[i] = col.ID
+	}
+	stokens, err := s.collectionRepo.GetStokens(ctx, ids)
+	if err != nil {
+		return nil, err
 	}
 
-	var stokenStr *string
-	if newStoken != nil {
-		stokenStr = &newStoken.UID
+	resp := &CollectionListResponse{
+		Data: make([]CollectionOut, len(collections)),
+		Done: done,
+	}
+	for i, col := range collections {
+		if resp.Data[i], err = s.collectionToOut(ctx, &col, stokens[col.ID], prefetch); err != nil {
+			return nil, err
+		}
+	}
+
+	// A first sync has nothing to drop
+	if stoken != "" {
+		removed, err := s.memberRepo.GetRemovedMemberships(ctx, userID, stoken)
+		if err != nil {
+			return nil, err
+		}
+		for _, rm := range removed {
+			if rm.Stoken == nil || rm.Collection == nil {
+				continue
+			}
+			if newStoken == nil || rm.Stoken.ID > newStoken.ID {
+				if !done {
+					break
+				}
+				newStoken = rm.Stoken
+			}
+			resp.RemovedMemberships = append(resp.RemovedMemberships, RemovedOut{UID: rm.Collection.UID})
+		}
 	}
 
-	return &CollectionListResponse{
-		Data:   data,
-		Stoken: stokenStr,
-		Done:   done,
-	}, nil
+	if newStoken != nil {
+		resp.Stoken = &newStoken.UID
+	}
+	return resp, nil
 }
 
-// CreateCollection creates a new collection
+// CreateCollection creates a collection along with its main item and first
+// revision, and makes the creator its admin member
 func (s *CollectionService) CreateCollection(
 	ctx context.Context,
 	userID uint,
 	req *CollectionCreateRequest,
 ) (*CollectionOut, error) {
-	// Create the main item for the collection
-	mainItem := &model.CollectionItem{
-		UID:     req.Item.UID,
-		Version: req.Item.Version,
+	// A new main item has no revision to depend on
+	if req.Item.Etag != nil {
+		return nil, pkgerrors.NewValidationError("etag", "etag is not null")
 	}
 
-	// Create the collection
-	col := &model.Collection{
-		UID:      req.Collection.UID,
-		OwnerID:  userID,
-		MainItem: mainItem,
+	var col *model.Collection
+	var stoken *model.Stoken
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if col, err = s.createCollection(ctx, userID, req); err != nil {
+			return err
+		}
+		stoken, err = s.collectionRepo.GetStoken(ctx, col.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A new collection's chunks were just sent by the client
+	out, err := s.collectionToOut(ctx, col, stoken, PrefetchMedium)
+	if err != nil {
+		return nil, err
 	}
+	return &out, nil
+}
 
+// createCollection stores a new collection within the transaction in ctx
+func (s *CollectionService) createCollection(
+	ctx context.Context,
+	userID uint,
+	req *CollectionCreateRequest,
+) (*model.Collection, error) {
+	existing, err := s.collectionRepo.GetByUID(ctx, req.Item.UID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, pkgerrors.ErrUniqueUID
+	}
+
+	// Revision UIDs are unique across all items
+	existingRev, err := s.revisionRepo.GetByUID(ctx, req.Item.Content.UID)
+	if err != nil {
+		return nil, err
+	}
+	if existingRev != nil {
+		return nil, pkgerrors.ErrUniqueUID.WithDetail("Revision with this uid already exists")
+	}
+
+	// A failed charge rolls back with the transaction
+	if err := s.quotaService.Charge(ctx, userID, model.QuotaCollections, 1); err != nil {
+		return nil, err
+	}
+
+	col := &model.Collection{
+		UID:     req.Item.UID,
+		OwnerID: userID,
+	}
 	if err := s.collectionRepo.Create(ctx, col); err != nil {
 		return nil, err
 	}
 
-	// Create membership for the owner (admin access)
-	// Note: This would be handled by the repository or a separate call
+	// The main item belongs to the collection it describes, so it can
+	// only be stored once the collection has an ID
+	mainItem := &model.CollectionItem{
+		UID:          req.Item.UID,
+		CollectionID: col.ID,
+		Version:      req.Item.Version,
+	}
+	if err := s.itemRepo.Create(ctx, mainItem); err != nil {
+		return nil, err
+	}
+	col.MainItemID = &mainItem.ID
+	if err := s.collectionRepo.Update(ctx, col); err != nil {
+		return nil, err
+	}
+	col.MainItem = mainItem
+
+	chunkIDs, err := s.chunkService.resolveRevisionChunks(ctx, col, req.Item.Content.Chunks)
+	if err != nil {
+		return nil, err
+	}
+	revision := &model.CollectionItemRevision{
+		UID:     req.Item.Content.UID,
+		ItemID:  mainItem.ID,
+		Meta:    req.Item.Content.Meta,
+		Deleted: req.Item.Content.Deleted,
+	}
+	if err := s.revisionRepo.Create(ctx, revision); err != nil {
+		return nil, err
+	}
+	if err := s.chunkService.linkRevisionChunks(ctx, revision.ID, chunkIDs); err != nil {
+		return nil, err
+	}
+	mainItem.Revisions = []model.CollectionItemRevision{*revision}
 
-	out := s.collectionToOut(col)
-	return &out, nil
+	colType, err := s.collectionTypeRepo.GetOrCreate(ctx, userID, req.CollectionType)
+	if err != nil {
+		return nil, err
+	}
+
+	// The member's stoken is minted with it, marking the collection as new
+	// in the owner's collection list
+	member := &model.CollectionMember{
+		CollectionID:     col.ID,
+		UserID:           userID,
+		AccessLevel:      model.AccessLevelAdmin,
+		EncryptionKey:    req.CollectionKey,
+		CollectionTypeID: &colType.ID,
+	}
+	if err := s.memberRepo.Create(ctx, member); err != nil {
+		return nil, err
+	}
+	member.CollectionType = colType
+	col.Members = []model.CollectionMember{*member}
+
+	return col, nil
 }
 
-// collectionToOut converts a model.Collection to CollectionOut
-func (s *CollectionService) collectionToOut(col *model.Collection) CollectionOut {
+// RemoveCollection deletes a collection on its owner's behalf.
+//
+// Every membership is removed, so each member's devices drop the collection
+// on their next sync, and pending invitations are withdrawn. The collection's
+// items and chunk files are purged by the CollectionPurger once the grace
+// period has passed.
+func (s *CollectionService) RemoveCollection(ctx context.Context, uid string, userID uint) error {
+	col, err := s.collectionRepo.GetByUID(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if col == nil || col.RemovedAt != nil {
+		return pkgerrors.ErrNotMember
+	}
+
+	member, err := s.memberRepo.GetByUserAndCollection(ctx, userID, col.ID)
+	if err != nil {
+		return err
+	}
+	if member == nil {
+		return pkgerrors.ErrNotMember
+	}
+	if col.OwnerID != userID {
+		return pkgerrors.ErrAdminRequired.WithDetail("Only the owner can delete a collection")
+	}
+
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Writers to the collection finish first, or see it gone
+		if err := s.collectionRepo.LockForWrite(ctx, col.ID); err != nil {
+			return err
+		}
+
+		// Invitations go first, as they point at the inviting member
+		if err := s.invitationRepo.DeleteForCollection(ctx, col.ID); err != nil {
+			return err
+		}
+
+		members, err := s.memberRepo.ListForCollection(ctx, col.ID)
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			if err := s.memberRepo.Delete(ctx, m.ID); err != nil {
+				return err
+			}
+		}
+
+		return s.collectionRepo.MarkRemoved(ctx, col.ID, time.Now())
+	})
+}
+
+// TransferCollection hands a collection over to another of its admins on
+// its owner's behalf. See TransferOwnership.
+func (s *CollectionService) TransferCollection(ctx context.Context, uid string, userID uint, req *CollectionTransferRequest) error {
+	col, err := s.collectionRepo.GetByUID(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if col == nil || col.RemovedAt != nil {
+		return pkgerrors.ErrNotMember
+	}
+
+	member, err := s.memberRepo.GetByUserAndCollection(ctx, userID, col.ID)
+	if err != nil {
+		return err
+	}
+	if member == nil {
+		return pkgerrors.ErrNotMember
+	}
+	if col.OwnerID != userID {
+		return pkgerrors.ErrAdminRequired.WithDetail("Only the owner can transfer a collection")
+	}
+
+	return s.transferOwnership(ctx, col, req.Username)
+}
+
+// TransferOwnership makes an admin member the owner of a collection, for
+// server admins handing over the collections of a user who is leaving.
+//
+// The collection's usage moves to the new owner's quota. Memberships,
+// stokens and chunk files are left alone, so every member keeps syncing
+// from where they were: chunk rows record their own store keys, which for
+// content-addressed blobs don't depend on the owner at all.
+func (s *CollectionService) TransferOwnership(ctx context.Context, uid, username string) error {
+	col, err := s.collectionRepo.GetByUID(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if col == nil || col.RemovedAt != nil {
+		return pkgerrors.ErrDoesNotExist.WithDetail("Collection not found")
+	}
+	return s.transferOwnership(ctx, col, username)
+}
+
+// transferOwnership makes the member called username the owner of col
+func (s *CollectionService) transferOwnership(ctx context.Context, col *model.Collection, username string) error {
+	if username == "" {
+		return pkgerrors.NewValidationError("username", "username is required")
+	}
+
+	target, err := s.memberRepo.GetByUsernameAndCollection(ctx, username, col.ID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return pkgerrors.ErrDoesNotExist.WithDetail("Member not found")
+	}
+	if target.UserID == col.OwnerID {
+		return pkgerrors.NewValidationError("username", "The user already owns the collection")
+	}
+	if !target.IsAdmin() {
+		return pkgerrors.ErrAdminRequired.WithDetail("The new owner must be an admin of the collection")
+	}
+
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Writers charge the owner's quota, so they finish first
+		if err := s.collectionRepo.LockForWrite(ctx, col.ID); err != nil {
+			return err
+		}
+
+		// Another transfer or a removal may have won the race
+		locked, err := s.collectionRepo.GetByID(ctx, col.ID)
+		if err != nil {
+			return err
+		}
+		if locked == nil || locked.RemovedAt != nil {
+			return pkgerrors.ErrNotMember
+		}
+		if locked.OwnerID != col.OwnerID {
+			return pkgerrors.ErrAdminRequired.WithDetail("Only the owner can transfer a collection")
+		}
+
+		// The new owner may have been demoted or removed meanwhile
+		current, err := s.memberRepo.GetByUserAndCollection(ctx, target.UserID, col.ID)
+		if err != nil {
+			return err
+		}
+		if current == nil || !current.IsAdmin() {
+			return pkgerrors.ErrAdminRequired.WithDetail("The new owner must be an admin of the collection")
+		}
+
+		// Usage is counted while the collection still has its old owner
+		if err := s.quotaService.MoveCollection(ctx, col.ID, col.OwnerID, target.UserID); err != nil {
+			return err
+		}
+		return s.collectionRepo.TransferOwnership(ctx, col.ID, target.UserID)
+	})
+}
+
+// collectionToOut converts a collection, preloaded like by GetForUser, to
+// CollectionOut, with the main item's chunk contents as prefetch asks
+func (s *CollectionService) collectionToOut(
+	ctx context.Context,
+	col *model.Collection,
+	stoken *model.Stoken,
+	prefetch Prefetch,
+) (CollectionOut, error) {
+	if len(col.Members) == 0 {
+		return CollectionOut{}, pkgerrors.ErrNotMember
+	}
+	member := col.Members[0]
+
 	out := CollectionOut{
-		AccessLevel: "readWrite", // TODO: Get from membership
-		Stoken:      "",          // TODO: Calculate from annotations
+		CollectionKey: member.EncryptionKey,
+		AccessLevel:   accessLevelToString(member.AccessLevel),
+	}
+	if member.CollectionType != nil {
+		out.CollectionType = member.CollectionType.UID
+	}
+	if stoken != nil {
+		out.Stoken = stoken.UID
 	}
 
 	if col.MainItem != nil {
-		out.Item = ItemOut{
-			UID:     col.MainItem.UID,
-			Version: col.MainItem.Version,
+		out.Item = newItemOut(col.MainItem)
+		if err := s.chunkService.prefetchContent(ctx, &out.Item.Content, prefetch); err != nil {
+			return out, err
 		}
 	}
 
-	return out
+	return out, nil
 }
-
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+)
+
+// CollectionPurger permanently deletes the contents of the collections their
+// owners removed, once the grace period has passed. Only the collection row
+// and its membership removals are kept. Chunks left without references are
+// released, and their files left to the ChunkCollector once no chunk holds them.
+type CollectionPurger struct {
+	collectionRepo repository.CollectionRepository
+	revisionRepo   repository.RevisionRepository
+	transactor     repository.Transactor
+	chunkService   *ChunkService
+	quotaService   *QuotaService
+	cfg            *config.Config
+}
+
+// NewCollectionPurger creates a new collection purger
+func NewCollectionPurger(
+	collectionRepo repository.CollectionRepository,
+	revisionRepo repository.RevisionRepository,
+	transactor repository.Transactor,
+	chunkService *ChunkService,
+	quotaService *QuotaService,
+	cfg *config.Config,
+) *CollectionPurger {
+	return &CollectionPurger{
+		collectionRepo: collectionRepo,
+		revisionRepo:   revisionRepo,
+		transactor:     transactor,
+		chunkService:   chunkService,
+		quotaService:   quotaService,
+		cfg:            cfg,
+	}
+}
+
+// CollectionPurgeReport summarizes a purge run
+type CollectionPurgeReport struct {
+	DryRun      bool
+	Collections []string // UIDs of the collections purged, or that would be in a dry run
+}
+
+// String returns a one-line summary of the report
+func (r *CollectionPurgeReport) String() string {
+	verb := "purged"
+	if r.DryRun {
+		verb = "would purge"
+	}
+	return fmt.Sprintf("collection purge %s %d deleted collections", verb, len(r.Collections))
+}
+
+// Run purges every collection removed longer than the grace period ago.
+// In a dry run, collections are only listed.
+func (p *CollectionPurger) Run(ctx context.Context, dryRun bool) (*CollectionPurgeReport, error) {
+	report := &CollectionPurgeReport{DryRun: dryRun}
+
+	collections, err := p.collectionRepo.ListRemoved(ctx, time.Now().Add(-p.cfg.CollectionPurgeGracePeriod))
+	if err != nil {
+		return nil, err
+	}
+	for i := range collections {
+		if !dryRun {
+			if err := p.purge(ctx, &collections[i]); err != nil {
+				return nil, err
+			}
+		}
+		report.Collections = append(report.Collections, collections[i].UID)
+	}
+	return report, nil
+}
+
+// purge deletes one removed collection and gives its usage back to the owner
+func (p *CollectionPurger) purge(ctx context.Context, col *model.Collection) error {
+	// Chunk references go first, like when pruning revisions: if the purge
+	// fails, the next run finds the collection again and finishes the job
+	var afterID uint
+	for {
+		ids, err := p.revisionRepo.ListIDsForCollection(ctx, col.ID, afterID, pruneBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		if err := p.chunkService.ReleaseRevisions(ctx, ids); err != nil {
+			return err
+		}
+		afterID = ids[len(ids)-1]
+	}
+
+	return p.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		items, err := p.collectionRepo.Purge(ctx, col.ID)
+		if err != nil {
+			return err
+		}
+		if err := p.quotaService.Refund(ctx, col.OwnerID, model.QuotaItems, items); err != nil {
+			return err
+		}
+		return p.quotaService.Refund(ctx, col.OwnerID, model.QuotaCollections, 1)
+	})
+}
+
+// RunJob performs a purge pass and logs the result, for use as a background job
+func (p *CollectionPurger) RunJob() func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		report, err := p.Run(ctx, false)
+		if err != nil {
+			return err
+		}
+		log.Println(report)
+		return nil
+	}
+}
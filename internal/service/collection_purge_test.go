@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCollectionPurger_Run(t *testing.T) {
+	ctx := context.Background()
+	env := newTestCollectionService(t)
+	env.svc.cfg.CollectionPurgeGracePeriod = 24 * time.Hour
+
+	for _, uid := range []string{"old", "recent", "kept"} {
+		if _, err := env.svc.CreateCollection(ctx, 1, newCollectionRequest(uid, "rev-"+uid)); err != nil {
+			t.Fatalf("CreateCollection failed: %v", err)
+		}
+	}
+	old, _ := env.collections.GetByUID(ctx, "old")
+	itemService := NewItemService(env.items, env.revisions, env.collections, env.members, MockTransactor{},
+		env.svc.chunkService, env.svc.quotaService, env.svc.cfg)
+	req := &ItemBatchRequest{Items: []ItemBatchIn{{UID: "item", Content: ContentIn{UID: "rev-item"}}}}
+	if err := itemService.BatchItems(ctx, "old", 1, "", req); err != nil {
+		t.Fatalf("BatchItems failed: %v", err)
+	}
+	for _, uid := range []string{"old", "recent"} {
+		if err := env.svc.RemoveCollection(ctx, uid, 1); err != nil {
+			t.Fatalf("RemoveCollection failed: %v", err)
+		}
+	}
+	// Only the first was removed before the grace period
+	longAgo := time.Now().AddDate(0, 0, -2)
+	old.RemovedAt = &longAgo
+
+	purger := NewCollectionPurger(env.collections, env.revisions, MockTransactor{}, env.svc.chunkService, env.svc.quotaService, env.svc.cfg)
+
+	// A dry run only lists
+	report, err := purger.Run(ctx, true)
+	if err != nil || !equalStrings(report.Collections, []string{"old"}) {
+		t.Fatalf("Dry run: expected old listed, got %v, %v", report, err)
+	}
+	if len(env.collections.collections) != 3 {
+		t.Fatalf("Dry run: expected 3 collections kept, got %d", len(env.collections.collections))
+	}
+
+	report, err = purger.Run(ctx, false)
+	if err != nil || !equalStrings(report.Collections, []string{"old"}) {
+		t.Fatalf("Expected old purged, got %v, %v", report, err)
+	}
+	// The collection itself stays to report its removal
+	if col, _ := env.collections.GetByUID(ctx, "old"); col == nil || col.PurgedAt == nil {
+		t.Errorf("Expected old to be kept as purged, got %+v", col)
+	}
+	if col, _ := env.collections.GetByUID(ctx, "recent"); col == nil {
+		t.Error("Expected recent to wait for the grace period")
+	}
+
+	// The purged revisions' chunks are released, and so is the owner's quota
+	for _, rev := range env.revisions.revisions {
+		if rev.UID == "rev-old" || rev.UID == "rev-item" {
+			t.Errorf("Revision %s was not purged", rev.UID)
+		}
+	}
+	if len(env.chunks.relations) != 2 {
+		t.Errorf("Expected the 2 other collections' chunk references left, got %d", len(env.chunks.relations))
+	}
+	quota := env.quotas.quotas[1]
+	if quota.UsedCollections != 2 || quota.UsedItems != 0 {
+		t.Errorf("Expected 2 collections and no items charged, got %d and %d", quota.UsedCollections, quota.UsedItems)
+	}
+
+	// A purged collection isn't purged again
+	if report, err := purger.Run(ctx, false); err != nil || len(report.Collections) != 0 {
+		t.Errorf("Expected nothing left to purge, got %v, %v", report, err)
+	}
+}
@@ -0,0 +1,418 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/storage"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// MockCollectionTypeRepository is a mock implementation for testing
+type MockCollectionTypeRepository struct {
+	types  []*model.CollectionType
+	nextID uint
+}
+
+func NewMockCollectionTypeRepository() *MockCollectionTypeRepository {
+	return &MockCollectionTypeRepository{}
+}
+
+func (m *MockCollectionTypeRepository) Create(ctx context.Context, colType *model.CollectionType) error {
+	m.nextID++
+	colType.ID = m.nextID
+	m.types = append(m.types, colType)
+	return nil
+}
+
+func (m *MockCollectionTypeRepository) GetByUID(ctx context.Context, uid []byte) (*model.CollectionType, error) {
+	for _, colType := range m.types {
+		if bytes.Equal(colType.UID, uid) {
+			return colType, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockCollectionTypeRepository) GetOrCreate(ctx context.Context, ownerID uint, uid []byte) (*model.CollectionType, error) {
+	for _, colType := range m.types {
+		if colType.OwnerID == ownerID && bytes.Equal(colType.UID, uid) {
+			return colType, nil
+		}
+	}
+	colType := &model.CollectionType{OwnerID: ownerID, UID: uid}
+	return colType, m.Create(ctx, colType)
+}
+
+// collectionTestEnv is a collection service wired to mock repositories
+type collectionTestEnv struct {
+	svc         *CollectionService
+	collections *MockCollectionRepository
+	items       *MockItemRepository
+	revisions   *MockRevisionRepository
+	members     *MockMemberRepository
+	types       *MockCollectionTypeRepository
+	invitations *MockInvitationRepository
+	quotas      *MockQuotaRepository
+	chunks      *MockChunkRepository
+}
+
+func newTestCollectionService(t *testing.T) *collectionTestEnv {
+	t.Helper()
+	cfg := &config.Config{}
+
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	chunkRepo := NewMockChunkRepository()
+	revisionRepo := NewMockRevisionRepository(chunkRepo)
+	itemRepo := NewMockItemRepository(revisionRepo)
+	typeRepo := NewMockCollectionTypeRepository()
+	invitationRepo := NewMockInvitationRepository(memberRepo)
+	quotaRepo := NewMockQuotaRepository()
+	quotaService := NewQuotaService(quotaRepo, cfg)
+	chunkService := NewChunkService(chunkRepo, collectionRepo, memberRepo, MockTransactor{}, storage.NewFileStorage(t.TempDir()), quotaService)
+	collectionRepo.members = memberRepo
+	collectionRepo.items = itemRepo
+
+	return &collectionTestEnv{
+		svc: NewCollectionService(collectionRepo, itemRepo, revisionRepo, memberRepo, typeRepo, invitationRepo,
+			MockTransactor{}, chunkService, quotaService, cfg),
+		collections: collectionRepo,
+		items:       itemRepo,
+		revisions:   revisionRepo,
+		members:     memberRepo,
+		types:       typeRepo,
+		invitations: invitationRepo,
+		quotas:      quotaRepo,
+		chunks:      chunkRepo,
+	}
+}
+
+func newCollectionRequest(uid, revisionUID string) *CollectionCreateRequest {
+	return &CollectionCreateRequest{
+		CollectionType: []byte("calendar"),
+		CollectionKey:  []byte("key-" + uid),
+		Item: ItemBatchIn{
+			UID:     uid,
+			Version: 1,
+			Content: ContentIn{
+				UID:    revisionUID,
+				Meta:   []byte("meta"),
+				Chunks: []ChunkIn{{UID: "chunk-" + uid, Content: []byte("content")}},
+			},
+		},
+	}
+}
+
+func TestCollectionService_Create(t *testing.T) {
+	ctx := context.Background()
+	env := newTestCollectionService(t)
+
+	out, err := env.svc.CreateCollection(ctx, 1, newCollectionRequest("col1", "rev1"))
+	if err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	if out.Item.UID != "col1" || out.Item.Etag != "rev1" || string(out.Item.Content.Meta) != "meta" {
+		t.Errorf("Unexpected main item: %+v", out.Item)
+	}
+	if out.AccessLevel != "admin" || string(out.CollectionKey) != "key-col1" || string(out.CollectionType) != "calendar" {
+		t.Errorf("Expected the creator's admin view, got %+v", out)
+	}
+
+	col, _ := env.collections.GetByUID(ctx, "col1")
+	if col == nil || col.OwnerID != 1 || col.MainItemID == nil {
+		t.Fatalf("Expected collection col1 with a main item, got %+v", col)
+	}
+	mainItem, _ := env.items.GetByID(ctx, *col.MainItemID)
+	if mainItem == nil || mainItem.CollectionID != col.ID || mainItem.UID != "col1" {
+		t.Fatalf("Expected the main item in the collection, got %+v", mainItem)
+	}
+	if got := env.chunks.relations; len(got) != 1 {
+		t.Errorf("Expected the first revision to reference 1 chunk, got %d", len(got))
+	}
+
+	// The creator is an admin with the key they sent and the resolved type
+	member, _ := env.members.GetByUserAndCollection(ctx, 1, col.ID)
+	if member == nil || !member.IsAdmin() || string(member.EncryptionKey) != "key-col1" {
+		t.Fatalf("Expected an admin member with the collection key, got %+v", member)
+	}
+	if member.CollectionTypeID == nil || len(env.types.types) != 1 || *member.CollectionTypeID != env.types.types[0].ID {
+		t.Errorf("Expected the member to have the new collection type, got %+v", member.CollectionTypeID)
+	}
+
+	// Another collection of the same type reuses it
+	if _, err := env.svc.CreateCollection(ctx, 1, newCollectionRequest("col2", "rev2")); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	if len(env.types.types) != 1 {
+		t.Errorf("Expected the collection type to be reused, got %d types", len(env.types.types))
+	}
+	if used := env.quotas.quotas[1].UsedCollections; used != 2 {
+		t.Errorf("Expected 2 collections charged, got %d", used)
+	}
+}
+
+func TestCollectionService_CreateConflicts(t *testing.T) {
+	ctx := context.Background()
+	env := newTestCollectionService(t)
+
+	if _, err := env.svc.CreateCollection(ctx, 1, newCollectionRequest("col", "rev")); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+
+	etag := "rev"
+	withEtag := newCollectionRequest("other", "rev-other")
+	withEtag.Item.Etag = &etag
+
+	tests := []struct {
+		name string
+		req  *CollectionCreateRequest
+		code string
+	}{
+		{"duplicate collection", newCollectionRequest("col", "rev-new"), pkgerrors.ErrUniqueUID.Code},
+		{"duplicate revision", newCollectionRequest("other", "rev"), pkgerrors.ErrUniqueUID.Code},
+		{"etag", withEtag, "validation_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.svc.CreateCollection(ctx, 1, tt.req)
+			var etebaseErr *pkgerrors.EtebaseError
+			if !errors.As(err, &etebaseErr) || etebaseErr.Code != tt.code {
+				t.Errorf("Expected %s, got %v", tt.code, err)
+			}
+		})
+	}
+
+	if len(env.members.members) != 1 || len(env.revisions.revisions) != 1 {
+		t.Errorf("Expected only the first collection to be stored, got %d members and %d revisions",
+			len(env.members.members), len(env.revisions.revisions))
+	}
+}
+
+func TestCollectionService_ListRemovedMemberships(t *testing.T) {
+	ctx := context.Background()
+	env := newTestCollectionService(t)
+
+	stoken := func(id uint) *model.Stoken {
+		return &model.Stoken{ID: id, UID: fmt.Sprintf("stoken-%d", id)}
+	}
+	env.members.removed = []model.CollectionMemberRemoved{
+		{UserID: 1, Collection: &model.Collection{UID: "gone1"}, Stoken: stoken(5)},
+		{UserID: 1, Collection: &model.Collection{UID: "gone2"}, Stoken: stoken(9)},
+		{UserID: 2, Collection: &model.Collection{UID: "other"}, Stoken: stoken(6)},
+	}
+
+	tests := []struct {
+		name       string
+		stoken     string
+		pageStoken *model.Stoken
+		more       bool
+		removed    []string
+		expected   string
+	}{
+		{"first sync", "", stoken(7), false, nil, "stoken-7"},
+		{"unfinished page", "stoken-3", stoken(7), true, []string{"gone1"}, "stoken-7"},
+		{"last page", "stoken-3", stoken(7), false, []string{"gone1", "gone2"}, "stoken-9"},
+		{"only removals", "stoken-3", stoken(3), false, []string{"gone1", "gone2"}, "stoken-9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env.collections.listedStoken, env.collections.listedMore = tt.pageStoken, tt.more
+
+			resp, err := env.svc.ListCollections(ctx, 1, tt.stoken, 10, PrefetchMedium)
+			if err != nil {
+				t.Fatalf("ListCollections failed: %v", err)
+			}
+			var removed []string
+			for _, rm := range resp.RemovedMemberships {
+				removed = append(removed, rm.UID)
+			}
+			if !equalStrings(removed, tt.removed) {
+				t.Errorf("Expected removed %v, got %v", tt.removed, removed)
+			}
+			if resp.Stoken == nil || *resp.Stoken != tt.expected {
+				t.Errorf("Expected stoken %s, got %v", tt.expected, resp.Stoken)
+			}
+		})
+	}
+}
+
+func TestCollectionService_Get(t *testing.T) {
+	ctx := context.Background()
+	env := newTestCollectionService(t)
+
+	if _, err := env.svc.CreateCollection(ctx, 1, newCollectionRequest("col", "rev")); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	col, _ := env.collections.GetByUID(ctx, "col")
+	env.collections.stokens[col.ID] = &model.Stoken{ID: 3, UID: "stoken-3"}
+
+	// Each member sees the collection with their own key and access level
+	_ = env.members.Create(ctx, &model.CollectionMember{
+		CollectionID:   col.ID,
+		UserID:         2,
+		AccessLevel:    model.AccessLevelReadOnly,
+		EncryptionKey:  []byte("key-2"),
+		CollectionType: &model.CollectionType{UID: []byte("type-2")},
+	})
+
+	out, err := env.svc.GetCollection(ctx, "col", 2, PrefetchMedium)
+	if err != nil {
+		t.Fatalf("GetCollection failed: %v", err)
+	}
+	if out.AccessLevel != "readOnly" || string(out.CollectionKey) != "key-2" || string(out.CollectionType) != "type-2" {
+		t.Errorf("Expected the read-only member's view, got %+v", out)
+	}
+	if out.Stoken != "stoken-3" || out.Item.Etag != "rev" {
+		t.Errorf("Expected stoken-3 and etag rev, got %s and %s", out.Stoken, out.Item.Etag)
+	}
+
+	_, err = env.svc.GetCollection(ctx, "col", 3, PrefetchMedium)
+	var etebaseErr *pkgerrors.EtebaseError
+	if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrNotMember.Code {
+		t.Errorf("Expected not_member for a non-member, got %v", err)
+	}
+}
+
+func TestCollectionService_Remove(t *testing.T) {
+	ctx := context.Background()
+	env := newTestCollectionService(t)
+
+	if _, err := env.svc.CreateCollection(ctx, 1, newCollectionRequest("col", "rev")); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	col, _ := env.collections.GetByUID(ctx, "col")
+	owner, _ := env.members.GetByUserAndCollection(ctx, 1, col.ID)
+	_ = env.members.Create(ctx, &model.CollectionMember{CollectionID: col.ID, UserID: 2, AccessLevel: model.AccessLevelAdmin})
+	_ = env.invitations.Create(ctx, &model.CollectionInvitation{UID: "invite", FromMemberID: owner.ID, UserID: 3})
+
+	notMember := func(err error) bool {
+		var etebaseErr *pkgerrors.EtebaseError
+		return errors.As(err, &etebaseErr) && etebaseErr.Code == pkgerrors.ErrNotMember.Code
+	}
+
+	// Only the owner may delete, even among admins
+	err := env.svc.RemoveCollection(ctx, "col", 2)
+	var etebaseErr *pkgerrors.EtebaseError
+	if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrAdminRequired.Code {
+		t.Fatalf("Expected admin_required for another admin, got %v", err)
+	}
+	if err := env.svc.RemoveCollection(ctx, "col", 3); !notMember(err) {
+		t.Fatalf("Expected not_member for a non-member, got %v", err)
+	}
+
+	if err := env.svc.RemoveCollection(ctx, "col", 1); err != nil {
+		t.Fatalf("RemoveCollection failed: %v", err)
+	}
+	if col.RemovedAt == nil {
+		t.Error("Expected the collection to be marked removed")
+	}
+	if len(env.members.members) != 0 || len(env.invitations.invitations) != 0 {
+		t.Errorf("Expected no members or invitations left, got %d and %d",
+			len(env.members.members), len(env.invitations.invitations))
+	}
+	// Every member is told on their next sync
+	var removed []uint
+	for _, rm := range env.members.removed {
+		removed = append(removed, rm.UserID)
+	}
+	if !slices.Equal(removed, []uint{1, 2}) && !slices.Equal(removed, []uint{2, 1}) {
+		t.Errorf("Expected removals for users 1 and 2, got %v", removed)
+	}
+
+	if _, err := env.svc.GetCollection(ctx, "col", 1, PrefetchMedium); !notMember(err) {
+		t.Errorf("Expected not_member once removed, got %v", err)
+	}
+	if err := env.svc.RemoveCollection(ctx, "col", 1); !notMember(err) {
+		t.Errorf("Expected not_member when removing twice, got %v", err)
+	}
+}
+
+func TestCollectionService_Transfer(t *testing.T) {
+	ctx := context.Background()
+	env := newTestCollectionService(t)
+
+	if _, err := env.svc.CreateCollection(ctx, 1, newCollectionRequest("col", "rev")); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	col, _ := env.collections.GetByUID(ctx, "col")
+	_ = env.members.Create(ctx, &model.CollectionMember{CollectionID: col.ID, UserID: 2,
+		AccessLevel: model.AccessLevelAdmin, User: &model.User{ID: 2, Username: "admin"}})
+	_ = env.members.Create(ctx, &model.CollectionMember{CollectionID: col.ID, UserID: 3,
+		AccessLevel: model.AccessLevelReadWrite, User: &model.User{ID: 3, Username: "writer"}})
+	env.quotas.usage[col.ID] = &model.UserQuota{UsedCollections: 1, UsedItems: 2, UsedChunkBytes: 7}
+	env.quotas.quotas[1] = &model.UserQuota{UserID: 1, UsedCollections: 1, UsedItems: 2, UsedChunkBytes: 7}
+
+	hasCode := func(err error, code string) bool {
+		var etebaseErr *pkgerrors.EtebaseError
+		return errors.As(err, &etebaseErr) && etebaseErr.Code == code
+	}
+	transfer := func(userID uint, username string) error {
+		return env.svc.TransferCollection(ctx, "col", userID, &CollectionTransferRequest{Username: username})
+	}
+
+	// Only the owner may transfer, and only to another admin
+	if err := transfer(2, "admin"); !hasCode(err, pkgerrors.ErrAdminRequired.Code) {
+		t.Fatalf("Expected admin_required for another admin, got %v", err)
+	}
+	if err := transfer(1, "writer"); !hasCode(err, pkgerrors.ErrAdminRequired.Code) {
+		t.Fatalf("Expected admin_required for a non-admin target, got %v", err)
+	}
+	if err := transfer(1, "stranger"); !hasCode(err, pkgerrors.ErrDoesNotExist.Code) {
+		t.Fatalf("Expected does_not_exist for a non-member, got %v", err)
+	}
+	if err := transfer(1, ""); !hasCode(err, "validation_error") {
+		t.Fatalf("Expected validation_error without a username, got %v", err)
+	}
+
+	// The new owner needs room for the collection
+	one := int64(1)
+	env.quotas.quotas[2] = &model.UserQuota{UserID: 2, MaxItems: &one}
+	if err := transfer(1, "admin"); !hasCode(err, pkgerrors.ErrQuotaExceeded.Code) {
+		t.Fatalf("Expected quota_exceeded, got %v", err)
+	}
+	env.quotas.quotas[2] = &model.UserQuota{UserID: 2}
+	env.quotas.quotas[1] = &model.UserQuota{UserID: 1, UsedCollections: 1, UsedItems: 2, UsedChunkBytes: 7}
+
+	if err := transfer(1, "admin"); err != nil {
+		t.Fatalf("TransferCollection failed: %v", err)
+	}
+	if col.OwnerID != 2 {
+		t.Errorf("Expected user 2 to own the collection, got %d", col.OwnerID)
+	}
+	if from, to := env.quotas.quotas[1], env.quotas.quotas[2]; from.UsedCollections != 0 || from.UsedItems != 0 ||
+		from.UsedChunkBytes != 0 || to.UsedCollections != 1 || to.UsedItems != 2 || to.UsedChunkBytes != 7 {
+		t.Errorf("Expected the usage to move to the new owner, got %+v and %+v", from, to)
+	}
+
+	// Memberships are untouched
+	if len(env.members.members) != 3 || len(env.members.removed) != 0 {
+		t.Errorf("Expected 3 members and no removals, got %d and %d", len(env.members.members), len(env.members.removed))
+	}
+
+	// The former owner has no say any more
+	if err := transfer(1, "admin"); !hasCode(err, pkgerrors.ErrAdminRequired.Code) {
+		t.Errorf("Expected admin_required for the former owner, got %v", err)
+	}
+	if err := transfer(2, "admin"); !hasCode(err, "validation_error") {
+		t.Errorf("Expected validation_error when transferring to oneself, got %v", err)
+	}
+
+	// Server admins can transfer any collection, but not removed ones
+	if err := env.svc.TransferOwnership(ctx, "missing", "admin"); !hasCode(err, pkgerrors.ErrDoesNotExist.Code) {
+		t.Errorf("Expected does_not_exist for an unknown collection, got %v", err)
+	}
+	_ = env.collections.MarkRemoved(ctx, col.ID, time.Now())
+	if err := env.svc.TransferOwnership(ctx, "col", "admin"); !hasCode(err, pkgerrors.ErrDoesNotExist.Code) {
+		t.Errorf("Expected does_not_exist for a removed collection, got %v", err)
+	}
+}
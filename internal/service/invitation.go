@@ -2,7 +2,10 @@ package service
 
 import (
 	"context"
+	"log"
+	"time"
 
+	"goatsync/internal/config"
 	"goatsync/internal/model"
 	"goatsync/internal/repository"
 	pkgerrors "goatsync/pkg/errors"
@@ -13,6 +16,9 @@ type InvitationService struct {
 	invitationRepo repository.InvitationRepository
 	memberRepo     repository.MemberRepository
 	userRepo       repository.UserRepository
+	collectionRepo repository.CollectionRepository
+	transactor     repository.Transactor
+	cfg            *config.Config
 }
 
 // NewInvitationService creates a new invitation service
@@ -20,14 +26,26 @@ func NewInvitationService(
 	invitationRepo repository.InvitationRepository,
 	memberRepo repository.MemberRepository,
 	userRepo repository.UserRepository,
+	collectionRepo repository.CollectionRepository,
+	transactor repository.Transactor,
+	cfg *config.Config,
 ) *InvitationService {
 	return &InvitationService{
 		invitationRepo: invitationRepo,
 		memberRepo:     memberRepo,
 		userRepo:       userRepo,
+		collectionRepo: collectionRepo,
+		transactor:     transactor,
+		cfg:            cfg,
 	}
 }
 
+// expired reports whether an invitation is past the configured expiry.
+// Expired invitations are treated as gone until CleanupExpired deletes them.
+func (s *InvitationService) expired(inv *model.CollectionInvitation) bool {
+	return s.cfg.InvitationExpiry > 0 && time.Since(inv.CreatedAt) > s.cfg.InvitationExpiry
+}
+
 // InvitationOut represents an invitation in API responses
 type InvitationOut struct {
 	UID                 string `msgpack:"uid"`
@@ -52,19 +70,23 @@ func (s *InvitationService) ListIncoming(ctx context.Context, userID uint) (*Inv
 		return nil, err
 	}
 
-	data := make([]InvitationOut, len(invitations))
-	for i, inv := range invitations {
-		data[i] = InvitationOut{
+	data := make([]InvitationOut, 0, len(invitations))
+	for _, inv := range invitations {
+		if s.expired(&inv) {
+			continue
+		}
+		out := InvitationOut{
 			UID:                 inv.UID,
 			SignedEncryptionKey: inv.SignedEncryptionKey,
 			AccessLevel:         accessLevelToString(inv.AccessLevel),
 		}
 		if inv.FromMember != nil && inv.FromMember.User != nil {
-			data[i].FromUsername = inv.FromMember.User.Username
+			out.FromUsername = inv.FromMember.User.Username
 			if inv.FromMember.User.UserInfo != nil {
-				data[i].FromPubkey = inv.FromMember.User.UserInfo.Pubkey
+				out.FromPubkey = inv.FromMember.User.UserInfo.Pubkey
 			}
 		}
+		data = append(data, out)
 	}
 
 	return &InvitationListResponse{
@@ -80,19 +102,23 @@ func (s *InvitationService) ListOutgoing(ctx context.Context, userID uint) (*Inv
 		return nil, err
 	}
 
-	data := make([]InvitationOut, len(invitations))
-	for i, inv := range invitations {
-		data[i] = InvitationOut{
+	data := make([]InvitationOut, 0, len(invitations))
+	for _, inv := range invitations {
+		if s.expired(&inv) {
+			continue
+		}
+		out := InvitationOut{
 			UID:                 inv.UID,
 			SignedEncryptionKey: inv.SignedEncryptionKey,
 			AccessLevel:         accessLevelToString(inv.AccessLevel),
 		}
 		if inv.User != nil {
-			data[i].Username = inv.User.Username
+			out.Username = inv.User.Username
 		}
 		if inv.FromMember != nil && inv.FromMember.Collection != nil {
-			data[i].CollectionUID = inv.FromMember.Collection.UID
+			out.CollectionUID = inv.FromMember.Collection.UID
 		}
+		data = append(data, out)
 	}
 
 	return &InvitationListResponse{
@@ -101,6 +127,103 @@ func (s *InvitationService) ListOutgoing(ctx context.Context, userID uint) (*Inv
 	}, nil
 }
 
+// InvitationCreateRequest is the request body for inviting a user to a collection
+type InvitationCreateRequest struct {
+	UID                 string `msgpack:"uid"`
+	Version             uint16 `msgpack:"version"`
+	AccessLevel         string `msgpack:"accessLevel"`
+	Username            string `msgpack:"username"`
+	Collection          string `msgpack:"collection"`
+	SignedEncryptionKey []byte `msgpack:"signedEncryptionKey"`
+}
+
+// CreateOutgoing invites a user to a collection the sender administers.
+//
+// A member has at most one pending invitation per user: inviting the same
+// user again replaces the pending invitation's key, access level and UID,
+// and gives it a new expiry period.
+func (s *InvitationService) CreateOutgoing(ctx context.Context, userID uint, req *InvitationCreateRequest) error {
+	if req.UID == "" {
+		return pkgerrors.NewValidationError("uid", "uid is required")
+	}
+	if len(req.SignedEncryptionKey) == 0 {
+		return pkgerrors.NewValidationError("signedEncryptionKey", "signedEncryptionKey is required")
+	}
+	accessLevel, err := parseAccessLevel(req.AccessLevel)
+	if err != nil {
+		return err
+	}
+
+	// Get collection
+	col, err := s.collectionRepo.GetByUID(ctx, req.Collection)
+	if err != nil {
+		return err
+	}
+	if col == nil || col.RemovedAt != nil {
+		return pkgerrors.ErrNotMember
+	}
+
+	// Check if user is admin
+	fromMember, err := s.memberRepo.GetByUserAndCollection(ctx, userID, col.ID)
+	if err != nil {
+		return err
+	}
+	if fromMember == nil {
+		return pkgerrors.ErrNotMember
+	}
+	if !fromMember.IsAdmin() {
+		return pkgerrors.ErrAdminRequired
+	}
+
+	toUser, err := s.userRepo.GetByUsername(ctx, req.Username)
+	if err != nil {
+		return err
+	}
+	if toUser == nil {
+		return pkgerrors.ErrUserNotFound
+	}
+	if toUser.ID == userID {
+		return pkgerrors.ErrNoSelfInvite
+	}
+
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		existing, err := s.memberRepo.GetByUserAndCollection(ctx, toUser.ID, col.ID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return pkgerrors.ErrAlreadyMember
+		}
+
+		pending, err := s.invitationRepo.GetPending(ctx, toUser.ID, fromMember.ID)
+		if err != nil {
+			return err
+		}
+		clash, err := s.invitationRepo.GetByUID(ctx, req.UID)
+		if err != nil {
+			return err
+		}
+		if clash != nil && (pending == nil || clash.ID != pending.ID) {
+			return pkgerrors.ErrInvitationExists
+		}
+
+		inv := pending
+		if inv == nil {
+			inv = &model.CollectionInvitation{UserID: toUser.ID, FromMemberID: fromMember.ID}
+		}
+		inv.UID = req.UID
+		inv.Version = req.Version
+		inv.CreatedAt = time.Now() // A re-invite starts a new expiry period
+		inv.AccessLevel = accessLevel
+		inv.SignedEncryptionKey = req.SignedEncryptionKey
+
+		if pending != nil {
+			return s.invitationRepo.Update(ctx, inv)
+		}
+		return s.invitationRepo.Create(ctx, inv)
+	})
+}
+
 // DeleteOutgoing deletes an outgoing invitation
 func (s *InvitationService) DeleteOutgoing(ctx context.Context, uid string, userID uint) error {
 	inv, err := s.invitationRepo.GetByUID(ctx, uid)
@@ -145,7 +268,7 @@ func (s *InvitationService) GetIncoming(ctx context.Context, uid string, userID
 	if err != nil {
 		return nil, err
 	}
-	if inv == nil || inv.UserID != userID {
+	if inv == nil || inv.UserID != userID || s.expired(inv) {
 		return nil, pkgerrors.ErrNotMember
 	}
 
@@ -167,7 +290,7 @@ func (s *InvitationService) RejectInvitation(ctx context.Context, uid string, us
 	if err != nil {
 		return err
 	}
-	if inv == nil || inv.UserID != userID {
+	if inv == nil || inv.UserID != userID || s.expired(inv) {
 		return pkgerrors.ErrNotMember
 	}
 
@@ -180,22 +303,42 @@ func (s *InvitationService) AcceptInvitation(ctx context.Context, uid string, us
 	if err != nil {
 		return err
 	}
-	if inv == nil || inv.UserID != userID {
+	if inv == nil || inv.UserID != userID || s.expired(inv) {
 		return pkgerrors.ErrNotMember
 	}
 
-	// Create membership
-	member := &model.CollectionMember{
-		CollectionID:  inv.FromMember.CollectionID,
-		UserID:        userID,
-		EncryptionKey: encryptionKey,
-		AccessLevel:   inv.AccessLevel,
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Create membership, which clears any earlier removal of the user
+		member := &model.CollectionMember{
+			CollectionID:  inv.FromMember.CollectionID,
+			UserID:        userID,
+			EncryptionKey: encryptionKey,
+			AccessLevel:   inv.AccessLevel,
+		}
+		if err := s.memberRepo.Create(ctx, member); err != nil {
+			return err
+		}
+
+		// Delete the invitation
+		return s.invitationRepo.Delete(ctx, inv.ID)
+	})
+}
+
+// CleanupExpired deletes the invitations past the configured expiry
+func (s *InvitationService) CleanupExpired(ctx context.Context) (int64, error) {
+	if s.cfg.InvitationExpiry <= 0 {
+		return 0, nil
 	}
-	if err := s.memberRepo.Create(ctx, member); err != nil {
+	return s.invitationRepo.DeleteCreatedBefore(ctx, time.Now().Add(-s.cfg.InvitationExpiry))
+}
+
+// CleanupJob deletes expired invitations and logs the result, for use as a background job
+func (s *InvitationService) CleanupJob() func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		removed, err := s.CleanupExpired(ctx)
+		if removed > 0 {
+			log.Printf("invitation cleanup removed %d expired invitations", removed)
+		}
 		return err
 	}
-
-	// Delete the invitation
-	return s.invitationRepo.Delete(ctx, inv.ID)
 }
-
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// MockInvitationRepository is a mock implementation for testing
+type MockInvitationRepository struct {
+	invitations map[uint]*model.CollectionInvitation
+	members     *MockMemberRepository // Tells the inviting members' collections apart
+	nextID      uint
+}
+
+func NewMockInvitationRepository(members *MockMemberRepository) *MockInvitationRepository {
+	return &MockInvitationRepository{invitations: make(map[uint]*model.CollectionInvitation), members: members}
+}
+
+func (m *MockInvitationRepository) Create(ctx context.Context, invitation *model.CollectionInvitation) error {
+	for _, existing := range m.invitations {
+		if existing.UserID == invitation.UserID && existing.FromMemberID == invitation.FromMemberID {
+			return pkgerrors.ErrInvitationExists
+		}
+	}
+	m.nextID++
+	invitation.ID = m.nextID
+	if invitation.CreatedAt.IsZero() {
+		invitation.CreatedAt = time.Now()
+	}
+	m.invitations[invitation.ID] = invitation
+	return nil
+}
+
+func (m *MockInvitationRepository) GetByID(ctx context.Context, id uint) (*model.CollectionInvitation, error) {
+	return m.invitations[id], nil
+}
+
+func (m *MockInvitationRepository) GetByUID(ctx context.Context, uid string) (*model.CollectionInvitation, error) {
+	for _, invitation := range m.invitations {
+		if invitation.UID == uid {
+			return invitation, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockInvitationRepository) GetPending(ctx context.Context, userID, fromMemberID uint) (*model.CollectionInvitation, error) {
+	for _, invitation := range m.invitations {
+		if invitation.UserID == userID && invitation.FromMemberID == fromMemberID {
+			return invitation, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockInvitationRepository) ListIncoming(ctx context.Context, userID uint) ([]model.CollectionInvitation, error) {
+	var out []model.CollectionInvitation
+	for _, invitation := range m.invitations {
+		if invitation.UserID == userID {
+			out = append(out, *invitation)
+		}
+	}
+	return out, nil
+}
+
+func (m *MockInvitationRepository) ListOutgoing(ctx context.Context, memberID uint) ([]model.CollectionInvitation, error) {
+	var out []model.CollectionInvitation
+	for _, invitation := range m.invitations {
+		if invitation.FromMemberID == memberID {
+			out = append(out, *invitation)
+		}
+	}
+	return out, nil
+}
+
+func (m *MockInvitationRepository) ListOutgoingByUser(ctx context.Context, userID uint) ([]model.CollectionInvitation, error) {
+	var out []model.CollectionInvitation
+	for _, invitation := range m.invitations {
+		if from := m.members.members[invitation.FromMemberID]; from != nil && from.UserID == userID {
+			out = append(out, *invitation)
+		}
+	}
+	return out, nil
+}
+
+func (m *MockInvitationRepository) Update(ctx context.Context, invitation *model.CollectionInvitation) error {
+	m.invitations[invitation.ID] = invitation
+	return nil
+}
+
+func (m *MockInvitationRepository) Delete(ctx context.Context, id uint) error {
+	delete(m.invitations, id)
+	return nil
+}
+
+func (m *MockInvitationRepository) DeleteFromMember(ctx context.Context, memberID uint) error {
+	for id, invitation := range m.invitations {
+		if invitation.FromMemberID == memberID {
+			delete(m.invitations, id)
+		}
+	}
+	return nil
+}
+
+func (m *MockInvitationRepository) DeleteForCollection(ctx context.Context, collectionID uint) error {
+	for id, invitation := range m.invitations {
+		if from := m.members.members[invitation.FromMemberID]; from != nil && from.CollectionID == collectionID {
+			delete(m.invitations, id)
+		}
+	}
+	return nil
+}
+
+func (m *MockInvitationRepository) DeleteCreatedBefore(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	for id, invitation := range m.invitations {
+		if invitation.CreatedAt.Before(before) {
+			delete(m.invitations, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func TestInvitationService_CreateOutgoing(t *testing.T) {
+	ctx := context.Background()
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	invitationRepo := NewMockInvitationRepository(memberRepo)
+	userRepo := NewMockUserRepository()
+	svc := NewInvitationService(invitationRepo, memberRepo, userRepo, collectionRepo, MockTransactor{}, &config.Config{})
+
+	for id, username := range []string{"owner", "reader", "friend", "member"} {
+		_ = userRepo.Create(ctx, &model.User{ID: uint(id + 1), Username: username}, &model.UserInfo{})
+	}
+	col := &model.Collection{UID: "col", OwnerID: 1}
+	_ = collectionRepo.Create(ctx, col)
+	_ = memberRepo.Create(ctx, &model.CollectionMember{CollectionID: col.ID, UserID: 1, AccessLevel: model.AccessLevelAdmin})
+	_ = memberRepo.Create(ctx, &model.CollectionMember{CollectionID: col.ID, UserID: 2, AccessLevel: model.AccessLevelReadOnly})
+	_ = memberRepo.Create(ctx, &model.CollectionMember{CollectionID: col.ID, UserID: 4, AccessLevel: model.AccessLevelReadWrite})
+
+	invite := func(uid, username string) *InvitationCreateRequest {
+		return &InvitationCreateRequest{
+			UID:                 uid,
+			Version:             1,
+			AccessLevel:         "readWrite",
+			Username:            username,
+			Collection:          "col",
+			SignedEncryptionKey: []byte("signed-" + uid),
+		}
+	}
+	if err := svc.CreateOutgoing(ctx, 1, invite("inv1", "friend")); err != nil {
+		t.Fatalf("CreateOutgoing failed: %v", err)
+	}
+	_ = invitationRepo.Create(ctx, &model.CollectionInvitation{UID: "taken", FromMemberID: 2, UserID: 3})
+
+	tests := []struct {
+		name   string
+		userID uint
+		req    *InvitationCreateRequest
+		code   string
+	}{
+		{"not an admin", 2, invite("inv2", "friend"), pkgerrors.ErrAdminRequired.Code},
+		{"not a member", 3, invite("inv2", "owner"), pkgerrors.ErrNotMember.Code},
+		{"unknown user", 1, invite("inv2", "nobody"), pkgerrors.ErrUserNotFound.Code},
+		{"self", 1, invite("inv2", "owner"), pkgerrors.ErrNoSelfInvite.Code},
+		{"already a member", 1, invite("inv2", "member"), pkgerrors.ErrAlreadyMember.Code},
+		{"UID in use", 1, invite("taken", "friend"), pkgerrors.ErrInvitationExists.Code},
+		{"no key", 1, &InvitationCreateRequest{UID: "inv2", Username: "friend", Collection: "col"}, "validation_error"},
+		{"unknown access level", 1, &InvitationCreateRequest{UID: "inv2", AccessLevel: "owner", Username: "friend", Collection: "col", SignedEncryptionKey: []byte("key")}, "validation_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.CreateOutgoing(ctx, tt.userID, tt.req)
+			var etebaseErr *pkgerrors.EtebaseError
+			if !errors.As(err, &etebaseErr) || etebaseErr.Code != tt.code {
+				t.Errorf("Expected %s, got %v", tt.code, err)
+			}
+		})
+	}
+
+	inv, _ := invitationRepo.GetByUID(ctx, "inv1")
+	if inv == nil || inv.UserID != 3 || inv.AccessLevel != model.AccessLevelReadWrite || string(inv.SignedEncryptionKey) != "signed-inv1" {
+		t.Fatalf("Expected a read-write invitation for friend, got %+v", inv)
+	}
+
+	// Inviting the same user again replaces the pending invitation
+	again := invite("inv3", "friend")
+	again.AccessLevel = "readOnly"
+	if err := svc.CreateOutgoing(ctx, 1, again); err != nil {
+		t.Fatalf("CreateOutgoing failed: %v", err)
+	}
+	if len(invitationRepo.invitations) != 2 {
+		t.Errorf("Expected the pending invitation to be reused, got %d invitations", len(invitationRepo.invitations))
+	}
+	if updated, _ := invitationRepo.GetByUID(ctx, "inv3"); updated == nil || updated.ID != inv.ID ||
+		updated.AccessLevel != model.AccessLevelReadOnly || string(updated.SignedEncryptionKey) != "signed-inv3" {
+		t.Errorf("Expected the invitation to be updated in place, got %+v", updated)
+	}
+}
+
+func TestInvitationService_Expiry(t *testing.T) {
+	ctx := context.Background()
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	invitationRepo := NewMockInvitationRepository(memberRepo)
+	userRepo := NewMockUserRepository()
+	cfg := &config.Config{InvitationExpiry: 24 * time.Hour}
+	svc := NewInvitationService(invitationRepo, memberRepo, userRepo, collectionRepo, MockTransactor{}, cfg)
+
+	_ = userRepo.Create(ctx, &model.User{ID: 1, Username: "owner"}, &model.UserInfo{})
+	_ = userRepo.Create(ctx, &model.User{ID: 2, Username: "friend"}, &model.UserInfo{})
+	for _, uid := range []string{"fresh", "stale"} {
+		col := &model.Collection{UID: uid, OwnerID: 1}
+		_ = collectionRepo.Create(ctx, col)
+		_ = memberRepo.Create(ctx, &model.CollectionMember{CollectionID: col.ID, UserID: 1, AccessLevel: model.AccessLevelAdmin})
+		req := &InvitationCreateRequest{UID: "inv-" + uid, AccessLevel: "readOnly", Username: "friend", Collection: uid, SignedEncryptionKey: []byte("key")}
+		if err := svc.CreateOutgoing(ctx, 1, req); err != nil {
+			t.Fatalf("CreateOutgoing failed: %v", err)
+		}
+	}
+	stale, _ := invitationRepo.GetByUID(ctx, "inv-stale")
+	stale.CreatedAt = time.Now().Add(-48 * time.Hour)
+
+	listed := func(resp *InvitationListResponse, err error) []string {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Listing failed: %v", err)
+		}
+		var uids []string
+		for _, inv := range resp.Data {
+			uids = append(uids, inv.UID)
+		}
+		return uids
+	}
+
+	// Expired invitations are hidden on both ends and can't be accepted
+	if got := listed(svc.ListIncoming(ctx, 2)); !equalStrings(got, []string{"inv-fresh"}) {
+		t.Errorf("Expected only inv-fresh incoming, got %v", got)
+	}
+	if got := listed(svc.ListOutgoing(ctx, 1)); !equalStrings(got, []string{"inv-fresh"}) {
+		t.Errorf("Expected only inv-fresh outgoing, got %v", got)
+	}
+	err := svc.AcceptInvitation(ctx, "inv-stale", 2, []byte("key"))
+	var etebaseErr *pkgerrors.EtebaseError
+	if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrNotMember.Code {
+		t.Errorf("Expected not_member accepting an expired invitation, got %v", err)
+	}
+
+	// The cleanup deletes them
+	removed, err := svc.CleanupExpired(ctx)
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected 1 invitation removed, got %d, %v", removed, err)
+	}
+	if inv, _ := invitationRepo.GetByUID(ctx, "inv-stale"); inv != nil {
+		t.Error("Expected inv-stale to be deleted")
+	}
+
+	// Re-inviting starts a new expiry period
+	fresh, _ := invitationRepo.GetByUID(ctx, "inv-fresh")
+	fresh.CreatedAt = time.Now().Add(-48 * time.Hour)
+	req := &InvitationCreateRequest{UID: "inv-again", AccessLevel: "readOnly", Username: "friend", Collection: "fresh", SignedEncryptionKey: []byte("key")}
+	if err := svc.CreateOutgoing(ctx, 1, req); err != nil {
+		t.Fatalf("CreateOutgoing failed: %v", err)
+	}
+	if got := listed(svc.ListIncoming(ctx, 2)); !equalStrings(got, []string{"inv-again"}) {
+		t.Errorf("Expected the renewed invitation, got %v", got)
+	}
+}
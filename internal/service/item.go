@@ -2,10 +2,14 @@ package service
 
 import (
 	"context"
+	"fmt"
 
+	"goatsync/internal/config"
 	"goatsync/internal/model"
 	"goatsync/internal/repository"
 	pkgerrors "goatsync/pkg/errors"
+
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 // ItemService handles item business logic
@@ -14,6 +18,10 @@ type ItemService struct {
 	revisionRepo   repository.RevisionRepository
 	collectionRepo repository.CollectionRepository
 	memberRepo     repository.MemberRepository
+	transactor     repository.Transactor
+	chunkService   *ChunkService
+	quotaService   *QuotaService
+	cfg            *config.Config
 }
 
 // NewItemService creates a new item service
@@ -22,12 +30,20 @@ func NewItemService(
 	revisionRepo repository.RevisionRepository,
 	collectionRepo repository.CollectionRepository,
 	memberRepo repository.MemberRepository,
+	transactor repository.Transactor,
+	chunkService *ChunkService,
+	quotaService *QuotaService,
+	cfg *config.Config,
 ) *ItemService {
 	return &ItemService{
 		itemRepo:       itemRepo,
 		revisionRepo:   revisionRepo,
 		collectionRepo: collectionRepo,
 		memberRepo:     memberRepo,
+		transactor:     transactor,
+		chunkService:   chunkService,
+		quotaService:   quotaService,
+		cfg:            cfg,
 	}
 }
 
@@ -45,6 +61,7 @@ func (s *ItemService) ListItems(
 	userID uint,
 	stoken string,
 	limit int,
+	prefetch Prefetch,
 ) (*ItemListResponse, error) {
 	// Get collection and verify access
 	col, err := s.collectionRepo.GetByUID(ctx, collectionUID)
@@ -72,7 +89,9 @@ func (s *ItemService) ListItems(
 
 	data := make([]ItemOut, len(items))
 	for i, item := range items {
-		data[i] = s.itemToOut(&item)
+		if data[i], err = s.itemToOut(ctx, &item, prefetch); err != nil {
+			return nil, err
+		}
 	}
 
 	var stokenStr *string
@@ -92,6 +111,7 @@ func (s *ItemService) GetItem(
 	ctx context.Context,
 	collectionUID, itemUID string,
 	userID uint,
+	prefetch Prefetch,
 ) (*ItemOut, error) {
 	// Get collection and verify access
 	col, err := s.collectionRepo.GetByUID(ctx, collectionUID)
@@ -120,24 +140,80 @@ func (s *ItemService) GetItem(
 		return nil, pkgerrors.ErrNotMember
 	}
 
-	out := s.itemToOut(item)
+	out, err := s.itemToOut(ctx, item, prefetch)
+	if err != nil {
+		return nil, err
+	}
 	return &out, nil
 }
 
 // ItemBatchIn represents an item in a batch request
 type ItemBatchIn struct {
-	UID     string     `msgpack:"uid"`
-	Version uint16     `msgpack:"version"`
-	Etag    *string    `msgpack:"etag,omitempty"`
-	Content ContentIn  `msgpack:"content"`
+	UID     string    `msgpack:"uid"`
+	Version uint16    `msgpack:"version"`
+	Etag    *string   `msgpack:"etag,omitempty"`
+	Content ContentIn `msgpack:"content"`
 }
 
 // ContentIn represents item content in a batch request
 type ContentIn struct {
-	UID     string   `msgpack:"uid"`
-	Meta    []byte   `msgpack:"meta"`
-	Deleted bool     `msgpack:"deleted"`
-	Chunks  []string `msgpack:"chunks,omitempty"`
+	UID     string    `msgpack:"uid"`
+	Meta    []byte    `msgpack:"meta"`
+	Deleted bool      `msgpack:"deleted"`
+	Chunks  []ChunkIn `msgpack:"chunks,omitempty"`
+}
+
+// ChunkIn represents a chunk reference in a batch request.
+// On the wire it's a [uid] or [uid, content] array; content is only needed
+// for chunks that haven't been uploaded yet.
+type ChunkIn struct {
+	UID     string
+	Content []byte
+}
+
+// EncodeMsgpack encodes the chunk as a [uid] or [uid, content] array
+func (c ChunkIn) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return encodeChunk(enc, c.UID, c.Content)
+}
+
+// DecodeMsgpack decodes a [uid] or [uid, content] array
+func (c *ChunkIn) DecodeMsgpack(dec *msgpack.Decoder) error {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > 2 {
+		return fmt.Errorf("chunk must have 1 or 2 elements, got %d", n)
+	}
+
+	if c.UID, err = dec.DecodeString(); err != nil {
+		return err
+	}
+	c.Content = nil
+	if n == 2 {
+		if c.Content, err = dec.DecodeBytes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeChunk writes a chunk as [uid], or [uid, content] if content is set
+func encodeChunk(enc *msgpack.Encoder, uid string, content []byte) error {
+	n := 1
+	if content != nil {
+		n = 2
+	}
+	if err := enc.EncodeArrayLen(n); err != nil {
+		return err
+	}
+	if err := enc.EncodeString(uid); err != nil {
+		return err
+	}
+	if content != nil {
+		return enc.EncodeBytes(content)
+	}
+	return nil
 }
 
 // ItemBatchRequest is the request for batch item operations
@@ -156,11 +232,14 @@ type Dependencies struct {
 	Stoken string `msgpack:"stoken,omitempty"`
 }
 
-// BatchItems processes a batch of item updates (no etag validation)
+// BatchItems processes a batch of item updates (no etag validation).
+// The batch is applied atomically: either every item is written or none is.
+// If stoken is set, the batch is rejected unless it's the collection's current stoken.
 func (s *ItemService) BatchItems(
 	ctx context.Context,
 	collectionUID string,
 	userID uint,
+	stoken string,
 	req *ItemBatchRequest,
 ) error {
 	// Get collection and verify write access
@@ -183,21 +262,34 @@ func (s *ItemService) BatchItems(
 		return pkgerrors.ErrNoWriteAccess
 	}
 
+	if err := s.storeChunks(ctx, col, req.Items); err != nil {
+		return err
+	}
+
 	// Process each item
-	for _, itemIn := range req.Items {
-		if err := s.processItemUpdate(ctx, col.ID, &itemIn); err != nil {
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.lockCollection(ctx, col, stoken); err != nil {
 			return err
 		}
-	}
 
-	return nil
+		for _, itemIn := range req.Items {
+			if err := s.processItemUpdate(ctx, col, &itemIn, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-// TransactionItems processes a batch of item updates with etag validation
+// TransactionItems processes a batch of item updates with etag validation.
+// The batch is applied atomically: a failed etag check rolls back every item.
+// The dependency stoken (stoken, or else req.Deps.Stoken) must be the
+// collection's current stoken if set, so writes based on stale data are rejected.
 func (s *ItemService) TransactionItems(
 	ctx context.Context,
 	collectionUID string,
 	userID uint,
+	stoken string,
 	req *ItemTransactionRequest,
 ) error {
 	// Get collection and verify write access
@@ -220,28 +312,27 @@ func (s *ItemService) TransactionItems(
 		return pkgerrors.ErrNoWriteAccess
 	}
 
-	// Process each item with etag validation
-	for _, itemIn := range req.Items {
-		// Validate etag if provided
-		if itemIn.Etag != nil {
-			existing, err := s.itemRepo.GetByUID(ctx, col.ID, itemIn.UID)
-			if err != nil {
-				return err
-			}
-			if existing != nil {
-				currentEtag := s.getItemEtag(existing)
-				if currentEtag != *itemIn.Etag {
-					return pkgerrors.NewWrongEtagError(*itemIn.Etag, currentEtag)
-				}
-			}
-		}
+	if stoken == "" && req.Deps != nil {
+		stoken = req.Deps.Stoken
+	}
 
-		if err := s.processItemUpdate(ctx, col.ID, &itemIn); err != nil {
+	if err := s.storeChunks(ctx, col, req.Items); err != nil {
+		return err
+	}
+
+	// Process each item with etag validation
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.lockCollection(ctx, col, stoken); err != nil {
 			return err
 		}
-	}
 
-	return nil
+		for _, itemIn := range req.Items {
+			if err := s.processItemUpdate(ctx, col, &itemIn, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // FetchUpdatesRequest is the request for fetching item updates
@@ -267,20 +358,20 @@ type RevisionListResponse struct {
 	Done     bool          `msgpack:"done"`
 }
 
-// RevisionOut represents a revision in API responses
-type RevisionOut struct {
-	UID     string `msgpack:"uid"`
-	Meta    []byte `msgpack:"meta"`
-	Deleted bool   `msgpack:"deleted"`
-}
+// RevisionOut represents a revision in API responses.
+// Revisions have the same shape as item content.
+type RevisionOut = ContentOut
 
-// GetItemRevisions retrieves revisions for an item
+// GetItemRevisions retrieves an item's revisions, newest first.
+// A page resumes after the revision whose UID is given as iterator, and
+// limit is capped at the configured maximum.
 func (s *ItemService) GetItemRevisions(
 	ctx context.Context,
 	collectionUID, itemUID string,
 	userID uint,
 	iterator string,
 	limit int,
+	prefetch Prefetch,
 ) (*RevisionListResponse, error) {
 	// Get collection and verify access
 	col, err := s.collectionRepo.GetByUID(ctx, collectionUID)
@@ -309,25 +400,40 @@ func (s *ItemService) GetItemRevisions(
 		return nil, pkgerrors.ErrNotMember
 	}
 
+	if maxLimit := s.cfg.RevisionListMaxLimit; maxLimit > 0 && limit > maxLimit {
+		limit = maxLimit
+	}
+
+	// Resolve the iterator, which must be one of this item's revisions
+	var beforeID uint
+	if iterator != "" {
+		iterRev, err := s.revisionRepo.GetByUID(ctx, iterator)
+		if err != nil {
+			return nil, err
+		}
+		if iterRev == nil || iterRev.ItemID != item.ID {
+			return nil, pkgerrors.ErrDoesNotExist.WithDetail("Revision not found")
+		}
+		beforeID = iterRev.ID
+	}
+
 	// Get revisions
-	revisions, err := s.revisionRepo.ListForItem(ctx, item.ID, limit)
+	revisions, done, err := s.revisionRepo.ListForItem(ctx, item.ID, beforeID, limit)
 	if err != nil {
 		return nil, err
 	}
 
 	data := make([]RevisionOut, len(revisions))
 	for i, rev := range revisions {
-		data[i] = RevisionOut{
-			UID:     rev.UID,
-			Meta:    rev.Meta,
-			Deleted: rev.Deleted,
+		data[i] = revisionToContent(&rev)
+		if err := s.chunkService.prefetchContent(ctx, &data[i], prefetch); err != nil {
+			return nil, err
 		}
 	}
 
-	// Set iterator if there are more results
+	// The iterator is the last revision returned, like the reference server
 	var iteratorStr *string
-	done := len(revisions) < limit
-	if !done && len(revisions) > 0 {
+	if len(revisions) > 0 {
 		lastUID := revisions[len(revisions)-1].UID
 		iteratorStr = &lastUID
 	}
@@ -345,6 +451,7 @@ func (s *ItemService) FetchUpdates(
 	collectionUID string,
 	userID uint,
 	req *FetchUpdatesRequest,
+	prefetch Prefetch,
 ) (*FetchUpdatesResponse, error) {
 	// Get collection and verify access
 	col, err := s.collectionRepo.GetByUID(ctx, collectionUID)
@@ -376,31 +483,110 @@ func (s *ItemService) FetchUpdates(
 
 		currentEtag := s.getItemEtag(item)
 		if currentEtag != itemIn.Etag {
-			changed = append(changed, s.itemToOut(item))
+			out, err := s.itemToOut(ctx, item, prefetch)
+			if err != nil {
+				return nil, err
+			}
+			changed = append(changed, out)
 		}
 	}
 
 	return &FetchUpdatesResponse{Data: changed}, nil
 }
 
-func (s *ItemService) processItemUpdate(ctx context.Context, collectionID uint, itemIn *ItemBatchIn) error {
+// lockCollection makes concurrent writers to col take turns and, if stoken is
+// set, rejects the write with ErrStaleStoken unless stoken is the collection's
+// current stoken, i.e. the client has seen every change made so far.
+// It must be called inside a transaction.
+func (s *ItemService) lockCollection(ctx context.Context, col *model.Collection, stoken string) error {
+	if err := s.collectionRepo.LockForWrite(ctx, col.ID); err != nil {
+		return err
+	}
+	if stoken == "" {
+		return nil
+	}
+
+	current, err := s.collectionRepo.GetStoken(ctx, col.ID)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.UID != stoken {
+		return pkgerrors.ErrStaleStoken
+	}
+	return nil
+}
+
+// storeChunks stores the new chunks sent along with a batch of items.
+// It must be called before the batch's transaction is opened.
+func (s *ItemService) storeChunks(ctx context.Context, col *model.Collection, items []ItemBatchIn) error {
+	for i := range items {
+		if err := s.chunkService.storeRevisionChunks(ctx, col, items[i].Content.Chunks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processItemUpdate writes one item of a batch: the item is created or its
+// version updated, and its content stored as a new current revision.
+// If validateEtag is set, a given etag must match the item's current revision.
+func (s *ItemService) processItemUpdate(
+	ctx context.Context,
+	col *model.Collection,
+	itemIn *ItemBatchIn,
+	validateEtag bool,
+) error {
 	// Get or create item
-	item, err := s.itemRepo.GetByUID(ctx, collectionID, itemIn.UID)
+	item, err := s.itemRepo.GetByUID(ctx, col.ID, itemIn.UID)
+	if err != nil {
+		return err
+	}
+
+	if item != nil {
+		// Resending the current revision is a no-op, like in the reference server
+		currentEtag := s.getItemEtag(item)
+		if currentEtag == itemIn.Content.UID {
+			return nil
+		}
+
+		// Validate etag if provided
+		if validateEtag && itemIn.Etag != nil && currentEtag != *itemIn.Etag {
+			return pkgerrors.NewWrongEtagError(*itemIn.Etag, currentEtag)
+		}
+	}
+
+	// Revision UIDs are unique across all items
+	existing, err := s.revisionRepo.GetByUID(ctx, itemIn.Content.UID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return pkgerrors.ErrUniqueUID.WithDetail("Revision with this uid already exists")
+	}
+
+	// Resolve the revision's chunks, stored by storeChunks, before touching the item
+	chunkIDs, err := s.chunkService.resolveRevisionChunks(ctx, col, itemIn.Content.Chunks)
 	if err != nil {
 		return err
 	}
 
 	if item == nil {
+		// New items count towards the collection owner's quota.
+		// A failed write rolls the charge back with the transaction.
+		if err := s.quotaService.Charge(ctx, col.OwnerID, model.QuotaItems, 1); err != nil {
+			return err
+		}
+
 		// Create new item
 		item = &model.CollectionItem{
 			UID:          itemIn.UID,
-			CollectionID: collectionID,
+			CollectionID: col.ID,
 			Version:      itemIn.Version,
 		}
 		if err := s.itemRepo.Create(ctx, item); err != nil {
 			return err
 		}
-	} else {
+	} else if item.Version != itemIn.Version {
 		// Update version
 		item.Version = itemIn.Version
 		if err := s.itemRepo.Update(ctx, item); err != nil {
@@ -408,20 +594,17 @@ func (s *ItemService) processItemUpdate(ctx context.Context, collectionID uint,
 		}
 	}
 
-	// Create new revision
-	if s.revisionRepo != nil {
-		revision := &model.CollectionItemRevision{
-			UID:     itemIn.Content.UID,
-			ItemID:  item.ID,
-			Meta:    itemIn.Content.Meta,
-			Deleted: itemIn.Content.Deleted,
-		}
-		if err := s.revisionRepo.Create(ctx, revision); err != nil {
-			return err
-		}
+	// Create the new current revision; the previous one stops being current
+	revision := &model.CollectionItemRevision{
+		UID:     itemIn.Content.UID,
+		ItemID:  item.ID,
+		Meta:    itemIn.Content.Meta,
+		Deleted: itemIn.Content.Deleted,
 	}
-
-	return nil
+	if err := s.revisionRepo.Create(ctx, revision); err != nil {
+		return err
+	}
+	return s.chunkService.linkRevisionChunks(ctx, revision.ID, chunkIDs)
 }
 
 func (s *ItemService) getItemEtag(item *model.CollectionItem) string {
@@ -435,7 +618,16 @@ func (s *ItemService) getItemEtag(item *model.CollectionItem) string {
 	return ""
 }
 
-func (s *ItemService) itemToOut(item *model.CollectionItem) ItemOut {
+// itemToOut converts an item with its current revision preloaded to ItemOut,
+// with chunk contents as prefetch asks
+func (s *ItemService) itemToOut(ctx context.Context, item *model.CollectionItem, prefetch Prefetch) (ItemOut, error) {
+	out := newItemOut(item)
+	err := s.chunkService.prefetchContent(ctx, &out.Content, prefetch)
+	return out, err
+}
+
+// newItemOut converts an item with its current revision preloaded to ItemOut
+func newItemOut(item *model.CollectionItem) ItemOut {
 	out := ItemOut{
 		UID:     item.UID,
 		Version: item.Version,
@@ -446,11 +638,7 @@ func (s *ItemService) itemToOut(item *model.CollectionItem) ItemOut {
 		for _, rev := range item.Revisions {
 			if rev.Current != nil && *rev.Current {
 				out.Etag = rev.UID
-				out.Content = ContentOut{
-					UID:     rev.UID,
-					Meta:    rev.Meta,
-					Deleted: rev.Deleted,
-				}
+				out.Content = revisionToContent(&rev)
 				break
 			}
 		}
@@ -459,3 +647,19 @@ func (s *ItemService) itemToOut(item *model.CollectionItem) ItemOut {
 	return out
 }
 
+// revisionToContent converts a revision, with its chunks preloaded, to ContentOut
+func revisionToContent(rev *model.CollectionItemRevision) ContentOut {
+	chunks := make([]ChunkOut, 0, len(rev.Chunks))
+	for _, rel := range rev.Chunks {
+		if rel.Chunk != nil {
+			chunks = append(chunks, ChunkOut{UID: rel.Chunk.UID, chunk: rel.Chunk})
+		}
+	}
+
+	return ContentOut{
+		UID:     rev.UID,
+		Meta:    rev.Meta,
+		Deleted: rev.Deleted,
+		Chunks:  chunks,
+	}
+}
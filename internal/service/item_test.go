@@ -0,0 +1,628 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	"goatsync/internal/storage"
+	pkgerrors "goatsync/pkg/errors"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MockTransactor runs functions directly, without a transaction
+type MockTransactor struct{}
+
+func (MockTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// MockRevisionRepository is a mock implementation for testing
+type MockRevisionRepository struct {
+	revisions []*model.CollectionItemRevision
+	chunkRepo *MockChunkRepository
+	itemRepo  *MockItemRepository // Set by NewMockItemRepository, to tell revisions' collections apart
+	nextID    uint
+}
+
+func NewMockRevisionRepository(chunkRepo *MockChunkRepository) *MockRevisionRepository {
+	return &MockRevisionRepository{chunkRepo: chunkRepo}
+}
+
+func (m *MockRevisionRepository) Create(ctx context.Context, revision *model.CollectionItemRevision) error {
+	for _, rev := range m.revisions {
+		if rev.ItemID == revision.ItemID {
+			rev.Current = nil
+		}
+	}
+	current := true
+	now := time.Now()
+	m.nextID++
+	revision.ID = m.nextID
+	revision.StokenID = revision.ID
+	revision.Current = &current
+	revision.CreatedAt = &now
+	m.revisions = append(m.revisions, revision)
+	return nil
+}
+
+// withChunks returns a copy of rev with its chunk relations attached, like a preload
+func (m *MockRevisionRepository) withChunks(rev *model.CollectionItemRevision) model.CollectionItemRevision {
+	out := *rev
+	out.Chunks = nil
+	for _, rel := range m.chunkRepo.relations {
+		if rel.RevisionID == rev.ID {
+			out.Chunks = append(out.Chunks, rel)
+		}
+	}
+	return out
+}
+
+func (m *MockRevisionRepository) GetByUID(ctx context.Context, uid string) (*model.CollectionItemRevision, error) {
+	for _, rev := range m.revisions {
+		if rev.UID == uid {
+			out := m.withChunks(rev)
+			return &out, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockRevisionRepository) GetCurrentForItem(ctx context.Context, itemID uint) (*model.CollectionItemRevision, error) {
+	for _, rev := range m.revisions {
+		if rev.ItemID == itemID && rev.Current != nil && *rev.Current {
+			out := m.withChunks(rev)
+			return &out, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockRevisionRepository) ListForItem(ctx context.Context, itemID uint, beforeID uint, limit int) ([]model.CollectionItemRevision, bool, error) {
+	var out []model.CollectionItemRevision
+	for i := len(m.revisions) - 1; i >= 0; i-- {
+		rev := m.revisions[i]
+		if rev.ItemID != itemID || (beforeID != 0 && rev.ID >= beforeID) {
+			continue
+		}
+		if len(out) == limit {
+			return out, false, nil
+		}
+		out = append(out, m.withChunks(rev))
+	}
+	return out, true, nil
+}
+
+// ListPrunable applies the count and age rules; collections are not told apart
+func (m *MockRevisionRepository) ListPrunable(ctx context.Context, filter repository.PruneFilter) ([]uint, error) {
+	newer := map[uint]int{} // Revisions seen so far per item, newest first
+	var ids []uint
+	for i := len(m.revisions) - 1; i >= 0; i-- {
+		rev := m.revisions[i]
+		newer[rev.ItemID]++
+		if rev.Current != nil && *rev.Current || rev.ID <= filter.AfterID {
+			continue
+		}
+		if filter.KeepRevisions > 0 && newer[rev.ItemID] <= filter.KeepRevisions {
+			continue
+		}
+		if !filter.CreatedBefore.IsZero() && rev.CreatedAt != nil && !rev.CreatedAt.Before(filter.CreatedBefore) {
+			continue
+		}
+		ids = append([]uint{rev.ID}, ids...)
+	}
+	if filter.Limit > 0 && len(ids) > filter.Limit {
+		ids = ids[:filter.Limit]
+	}
+	return ids, nil
+}
+
+func (m *MockRevisionRepository) ListIDsForCollection(ctx context.Context, collectionID, afterID uint, limit int) ([]uint, error) {
+	var ids []uint
+	for _, rev := range m.revisions {
+		item, _ := m.itemRepo.GetByID(ctx, rev.ItemID)
+		if item == nil || item.CollectionID != collectionID || rev.ID <= afterID {
+			continue
+		}
+		if len(ids) == limit {
+			break
+		}
+		ids = append(ids, rev.ID)
+	}
+	return ids, nil
+}
+
+func (m *MockRevisionRepository) DeleteNonCurrent(ctx context.Context, ids []uint) (int64, error) {
+	var deleted int64
+	kept := m.revisions[:0]
+	for _, rev := range m.revisions {
+		if slices.Contains(ids, rev.ID) && (rev.Current == nil || !*rev.Current) {
+			deleted++
+			continue
+		}
+		kept = append(kept, rev)
+	}
+	m.revisions = kept
+	return deleted, nil
+}
+
+// MockItemRepository is a mock implementation for testing
+type MockItemRepository struct {
+	items        []*model.CollectionItem
+	revisionRepo *MockRevisionRepository
+}
+
+func NewMockItemRepository(revisionRepo *MockRevisionRepository) *MockItemRepository {
+	m := &MockItemRepository{revisionRepo: revisionRepo}
+	revisionRepo.itemRepo = m
+	return m
+}
+
+func (m *MockItemRepository) Create(ctx context.Context, item *model.CollectionItem) error {
+	item.ID = uint(len(m.items) + 1)
+	m.items = append(m.items, item)
+	return nil
+}
+
+// withCurrentRevision returns a copy of item with its current revision attached, like a preload
+func (m *MockItemRepository) withCurrentRevision(item *model.CollectionItem) *model.CollectionItem {
+	out := *item
+	out.Revisions = nil
+	if rev, _ := m.revisionRepo.GetCurrentForItem(context.Background(), item.ID); rev != nil {
+		out.Revisions = []model.CollectionItemRevision{*rev}
+	}
+	return &out
+}
+
+func (m *MockItemRepository) GetByID(ctx context.Context, id uint) (*model.CollectionItem, error) {
+	for _, item := range m.items {
+		if item.ID == id {
+			return m.withCurrentRevision(item), nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockItemRepository) GetByUID(ctx context.Context, collectionID uint, uid string) (*model.CollectionItem, error) {
+	for _, item := range m.items {
+		if item.CollectionID == collectionID && item.UID == uid {
+			return m.withCurrentRevision(item), nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockItemRepository) ListForCollection(ctx context.Context, collectionID uint, stoken string, limit int) ([]model.CollectionItem, *model.Stoken, bool, error) {
+	var out []model.CollectionItem
+	for _, item := range m.items {
+		if item.CollectionID == collectionID {
+			out = append(out, *m.withCurrentRevision(item))
+		}
+	}
+	return out, nil, true, nil
+}
+
+func (m *MockItemRepository) Update(ctx context.Context, item *model.CollectionItem) error {
+	for i, existing := range m.items {
+		if existing.ID == item.ID {
+			updated := *item
+			updated.Revisions = nil
+			m.items[i] = &updated
+		}
+	}
+	return nil
+}
+
+func (m *MockItemRepository) Delete(ctx context.Context, id uint) error {
+	for i, item := range m.items {
+		if item.ID == id {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func newTestItemService(t *testing.T) (*ItemService, *ChunkService, *MockItemRepository) {
+	t.Helper()
+	ctx := context.Background()
+	cfg := &config.Config{}
+
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	chunkRepo := NewMockChunkRepository()
+	revisionRepo := NewMockRevisionRepository(chunkRepo)
+	itemRepo := NewMockItemRepository(revisionRepo)
+	quotaService := NewQuotaService(NewMockQuotaRepository(), cfg)
+	store := storage.NewFileStorage(t.TempDir())
+
+	_ = collectionRepo.Create(ctx, &model.Collection{UID: "col", OwnerID: 1})
+	_ = memberRepo.Create(ctx, &model.CollectionMember{CollectionID: 1, UserID: 1, AccessLevel: model.AccessLevelAdmin})
+
+	chunkService := NewChunkService(chunkRepo, collectionRepo, memberRepo, MockTransactor{}, store, quotaService)
+	itemService := NewItemService(itemRepo, revisionRepo, collectionRepo, memberRepo, MockTransactor{}, chunkService, quotaService, cfg)
+	return itemService, chunkService, itemRepo
+}
+
+func TestItemService_RevisionChunks(t *testing.T) {
+	ctx := context.Background()
+	svc, chunkService, _ := newTestItemService(t)
+
+	if err := chunkService.UploadChunk(ctx, "col", "item", "uploaded", 1, strings.NewReader("first"), 5); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+
+	// Chunks keep their order; new ones may be sent inline
+	req := &ItemBatchRequest{Items: []ItemBatchIn{{
+		UID: "item",
+		Content: ContentIn{
+			UID:  "rev1",
+			Meta: []byte("meta"),
+			Chunks: []ChunkIn{
+				{UID: "uploaded"},
+				{UID: "inline", Content: []byte("second")},
+				{UID: "uploaded"},
+			},
+		},
+	}}}
+	if err := svc.BatchItems(ctx, "col", 1, "", req); err != nil {
+		t.Fatalf("BatchItems failed: %v", err)
+	}
+
+	expected := []string{"uploaded", "inline", "uploaded"}
+	chunkUIDs := func(chunks []ChunkOut) []string {
+		uids := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			uids[i] = chunk.UID
+		}
+		return uids
+	}
+
+	item, err := svc.GetItem(ctx, "col", "item", 1, PrefetchMedium)
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if got := chunkUIDs(item.Content.Chunks); !equalStrings(got, expected) {
+		t.Errorf("GetItem chunks: expected %v, got %v", expected, got)
+	}
+
+	list, err := svc.ListItems(ctx, "col", 1, "", 50, PrefetchMedium)
+	if err != nil || len(list.Data) != 1 {
+		t.Fatalf("ListItems: got %+v, %v", list, err)
+	}
+	if got := chunkUIDs(list.Data[0].Content.Chunks); !equalStrings(got, expected) {
+		t.Errorf("ListItems chunks: expected %v, got %v", expected, got)
+	}
+
+	revisions, err := svc.GetItemRevisions(ctx, "col", "item", 1, "", 50, PrefetchMedium)
+	if err != nil || len(revisions.Data) != 1 {
+		t.Fatalf("GetItemRevisions: got %+v, %v", revisions, err)
+	}
+	if got := chunkUIDs(revisions.Data[0].Chunks); !equalStrings(got, expected) {
+		t.Errorf("GetItemRevisions chunks: expected %v, got %v", expected, got)
+	}
+
+	// Chunks are sent as [uid] arrays, like the reference server
+	data, err := msgpack.Marshal(item.Content)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var decoded struct {
+		Chunks [][]string `msgpack:"chunks"`
+	}
+	if err := msgpack.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(decoded.Chunks) != 3 || len(decoded.Chunks[1]) != 1 || decoded.Chunks[1][0] != "inline" {
+		t.Errorf("Unexpected encoded chunks: %v", decoded.Chunks)
+	}
+}
+
+func TestItemService_MissingChunk(t *testing.T) {
+	ctx := context.Background()
+	svc, _, itemRepo := newTestItemService(t)
+
+	req := &ItemBatchRequest{Items: []ItemBatchIn{{
+		UID: "item",
+		Content: ContentIn{
+			UID:    "rev1",
+			Meta:   []byte("meta"),
+			Chunks: []ChunkIn{{UID: "missing"}},
+		},
+	}}}
+	err := svc.BatchItems(ctx, "col", 1, "", req)
+	if !errors.Is(err, pkgerrors.ErrChunkNoContent) {
+		t.Fatalf("Expected ErrChunkNoContent, got %v", err)
+	}
+	if len(itemRepo.items) != 0 {
+		t.Errorf("Item created despite missing chunk")
+	}
+}
+
+func TestItemService_StaleStoken(t *testing.T) {
+	ctx := context.Background()
+	svc, _, itemRepo := newTestItemService(t)
+	collectionRepo := svc.collectionRepo.(*MockCollectionRepository)
+	collectionRepo.stokens[1] = &model.Stoken{ID: 2, UID: "current"}
+
+	req := func(stoken string) *ItemTransactionRequest {
+		return &ItemTransactionRequest{
+			Items: []ItemBatchIn{{UID: "item", Content: ContentIn{UID: "rev-" + stoken, Meta: []byte("meta")}}},
+			Deps:  &Dependencies{Stoken: stoken},
+		}
+	}
+
+	// A client that hasn't seen the latest changes is turned away
+	if err := svc.TransactionItems(ctx, "col", 1, "", req("old")); !errors.Is(err, pkgerrors.ErrStaleStoken) {
+		t.Fatalf("Expected ErrStaleStoken, got %v", err)
+	}
+	if err := svc.TransactionItems(ctx, "col", 1, "old", req("current")); !errors.Is(err, pkgerrors.ErrStaleStoken) {
+		t.Fatalf("Expected ErrStaleStoken for a stale query stoken, got %v", err)
+	}
+	if len(itemRepo.items) != 0 {
+		t.Fatalf("Stale transaction wrote items")
+	}
+
+	// Up-to-date clients and clients without a dependency are let through
+	if err := svc.TransactionItems(ctx, "col", 1, "", req("current")); err != nil {
+		t.Fatalf("TransactionItems failed: %v", err)
+	}
+	if err := svc.TransactionItems(ctx, "col", 1, "", &ItemTransactionRequest{}); err != nil {
+		t.Fatalf("TransactionItems without deps failed: %v", err)
+	}
+	if err := svc.BatchItems(ctx, "col", 1, "old", &ItemBatchRequest{}); !errors.Is(err, pkgerrors.ErrStaleStoken) {
+		t.Fatalf("Expected ErrStaleStoken for batch, got %v", err)
+	}
+}
+
+func TestItemService_RevisionLifecycle(t *testing.T) {
+	ctx := context.Background()
+	svc, _, itemRepo := newTestItemService(t)
+	revisionRepo := svc.revisionRepo.(*MockRevisionRepository)
+
+	write := func(revUID, meta string) error {
+		req := &ItemBatchRequest{Items: []ItemBatchIn{{
+			UID:     "item",
+			Version: 1,
+			Content: ContentIn{UID: revUID, Meta: []byte(meta)},
+		}}}
+		return svc.BatchItems(ctx, "col", 1, "", req)
+	}
+
+	if err := write("rev1", "first"); err != nil {
+		t.Fatalf("First write failed: %v", err)
+	}
+	if err := write("rev2", "second"); err != nil {
+		t.Fatalf("Second write failed: %v", err)
+	}
+
+	// The item now reflects the latest revision
+	item, err := svc.GetItem(ctx, "col", "item", 1, PrefetchMedium)
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if item.Etag != "rev2" || string(item.Content.Meta) != "second" {
+		t.Errorf("Expected etag rev2 with meta second, got %s with %s", item.Etag, item.Content.Meta)
+	}
+
+	// Only the latest revision is current, and each has its own stoken
+	current := 0
+	for _, rev := range revisionRepo.revisions {
+		if rev.Current != nil && *rev.Current {
+			current++
+		}
+	}
+	if len(revisionRepo.revisions) != 2 || current != 1 {
+		t.Errorf("Expected 2 revisions with 1 current, got %d with %d", len(revisionRepo.revisions), current)
+	}
+	if revisionRepo.revisions[0].StokenID == revisionRepo.revisions[1].StokenID {
+		t.Error("Revisions share a stoken")
+	}
+
+	// Resending the current revision is a no-op
+	if err := write("rev2", "second"); err != nil {
+		t.Fatalf("Resending the current revision failed: %v", err)
+	}
+	if len(revisionRepo.revisions) != 2 {
+		t.Errorf("Resending the current revision created a revision")
+	}
+
+	// Reusing an older revision's UID is rejected
+	err = write("rev1", "third")
+	var etebaseErr *pkgerrors.EtebaseError
+	if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrUniqueUID.Code {
+		t.Fatalf("Expected unique_uid, got %v", err)
+	}
+	if len(itemRepo.items) != 1 {
+		t.Errorf("Expected 1 item, got %d", len(itemRepo.items))
+	}
+}
+
+func TestItemService_RevisionPaging(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestItemService(t)
+	svc.cfg.RevisionListMaxLimit = 3
+
+	write := func(itemUID, revUID string) {
+		t.Helper()
+		req := &ItemBatchRequest{Items: []ItemBatchIn{{UID: itemUID, Content: ContentIn{UID: revUID}}}}
+		if err := svc.BatchItems(ctx, "col", 1, "", req); err != nil {
+			t.Fatalf("BatchItems failed: %v", err)
+		}
+	}
+	for _, uid := range []string{"rev1", "rev2", "rev3", "rev4", "rev5"} {
+		write("item", uid)
+	}
+	write("other", "other-rev")
+
+	// Pages run newest first, and the limit is capped at the configured maximum
+	var got []string
+	iterator := ""
+	for page := 0; ; page++ {
+		resp, err := svc.GetItemRevisions(ctx, "col", "item", 1, iterator, 50, PrefetchMedium)
+		if err != nil {
+			t.Fatalf("GetItemRevisions failed: %v", err)
+		}
+		if len(resp.Data) > 3 {
+			t.Fatalf("Page %d has %d revisions, expected at most 3", page, len(resp.Data))
+		}
+		for _, rev := range resp.Data {
+			got = append(got, rev.UID)
+		}
+		if resp.Iterator == nil || *resp.Iterator != got[len(got)-1] {
+			t.Fatalf("Page %d: iterator %v doesn't point at its last revision", page, resp.Iterator)
+		}
+		if resp.Done {
+			break
+		}
+		iterator = *resp.Iterator
+	}
+	if expected := []string{"rev5", "rev4", "rev3", "rev2", "rev1"}; !equalStrings(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	// Paging past the oldest revision returns an empty, done page
+	resp, err := svc.GetItemRevisions(ctx, "col", "item", 1, "rev1", 3, PrefetchMedium)
+	if err != nil || len(resp.Data) != 0 || !resp.Done || resp.Iterator != nil {
+		t.Errorf("Expected an empty done page, got %+v, %v", resp, err)
+	}
+
+	// The iterator must be one of the item's revisions
+	for _, iterator := range []string{"missing", "other-rev"} {
+		_, err := svc.GetItemRevisions(ctx, "col", "item", 1, iterator, 3, PrefetchMedium)
+		var etebaseErr *pkgerrors.EtebaseError
+		if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrDoesNotExist.Code {
+			t.Errorf("Iterator %s: expected does_not_exist, got %v", iterator, err)
+		}
+	}
+}
+
+func TestChunkIn_Msgpack(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []any
+		want ChunkIn
+	}{
+		{"uid only", []any{"abc"}, ChunkIn{UID: "abc"}},
+		{"with content", []any{"abc", []byte("data")}, ChunkIn{UID: "abc", Content: []byte("data")}},
+		{"null content", []any{"abc", nil}, ChunkIn{UID: "abc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := msgpack.Marshal(tt.in)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			var got ChunkIn
+			if err := msgpack.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if got.UID != tt.want.UID || string(got.Content) != string(tt.want.Content) || (got.Content == nil) != (tt.want.Content == nil) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+
+			// Round trip
+			data, err = msgpack.Marshal(got)
+			if err != nil {
+				t.Fatalf("Marshal ChunkIn failed: %v", err)
+			}
+			var again ChunkIn
+			if err := msgpack.Unmarshal(data, &again); err != nil || again.UID != got.UID || string(again.Content) != string(got.Content) {
+				t.Errorf("Round trip: expected %+v, got %+v, %v", got, again, err)
+			}
+		})
+	}
+
+	data, _ := msgpack.Marshal([]any{"abc", []byte("data"), "extra"})
+	var got ChunkIn
+	if err := msgpack.Unmarshal(data, &got); err == nil {
+		t.Error("Expected an error for a chunk with 3 elements")
+	}
+}
+
+func TestParsePrefetch(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Prefetch
+		wantErr  bool
+	}{
+		{"", PrefetchAuto, false},
+		{"auto", PrefetchAuto, false},
+		{"medium", PrefetchMedium, false},
+		{"full", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePrefetch(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.expected {
+			t.Errorf("ParsePrefetch(%q): expected %q (error %v), got %q, %v", tt.value, tt.expected, tt.wantErr, got, err)
+		}
+	}
+}
+
+func TestItemService_Prefetch(t *testing.T) {
+	ctx := context.Background()
+	svc, chunkService, _ := newTestItemService(t)
+	chunkRepo := chunkService.chunkRepo.(*MockChunkRepository)
+
+	req := &ItemBatchRequest{Items: []ItemBatchIn{{
+		UID: "item",
+		Content: ContentIn{
+			UID:    "rev1",
+			Meta:   []byte("meta"),
+			Chunks: []ChunkIn{{UID: "first", Content: []byte("one")}, {UID: "second", Content: []byte("two")}},
+		},
+	}}}
+	if err := svc.BatchItems(ctx, "col", 1, "", req); err != nil {
+		t.Fatalf("BatchItems failed: %v", err)
+	}
+
+	contents := func(prefetch Prefetch) []string {
+		t.Helper()
+		item, err := svc.GetItem(ctx, "col", "item", 1, prefetch)
+		if err != nil {
+			t.Fatalf("GetItem failed: %v", err)
+		}
+		if string(item.Content.Meta) != "meta" {
+			t.Errorf("Meta should always be sent, got %q", item.Content.Meta)
+		}
+		var out []string
+		for _, chunk := range item.Content.Chunks {
+			out = append(out, string(chunk.Content))
+		}
+		return out
+	}
+
+	// Auto sends chunk contents inline, medium only their UIDs
+	if got := contents(PrefetchAuto); !equalStrings(got, []string{"one", "two"}) {
+		t.Errorf("Auto: expected chunk contents, got %q", got)
+	}
+	if got := contents(PrefetchMedium); !equalStrings(got, []string{"", ""}) {
+		t.Errorf("Medium: expected no chunk contents, got %q", got)
+	}
+
+	updates, err := svc.FetchUpdates(ctx, "col", 1, &FetchUpdatesRequest{Items: []ItemFetchIn{{UID: "item"}}}, PrefetchAuto)
+	if err != nil || len(updates.Data) != 1 || string(updates.Data[0].Content.Chunks[1].Content) != "two" {
+		t.Errorf("FetchUpdates: expected prefetched chunks, got %+v, %v", updates, err)
+	}
+
+	// A chunk whose file is gone is sent without content instead of failing the listing
+	for _, chunk := range chunkRepo.chunks {
+		if chunk.UID == "first" {
+			if err := chunkService.store.Delete(ctx, chunk.ChunkFile); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+		}
+	}
+	if got := contents(PrefetchAuto); !equalStrings(got, []string{"", "two"}) {
+		t.Errorf("Expected only the remaining chunk's content, got %q", got)
+	}
+}
@@ -12,16 +12,22 @@ import (
 type MemberService struct {
 	memberRepo     repository.MemberRepository
 	collectionRepo repository.CollectionRepository
+	invitationRepo repository.InvitationRepository
+	transactor     repository.Transactor
 }
 
 // NewMemberService creates a new member service
 func NewMemberService(
 	memberRepo repository.MemberRepository,
 	collectionRepo repository.CollectionRepository,
+	invitationRepo repository.InvitationRepository,
+	transactor repository.Transactor,
 ) *MemberService {
 	return &MemberService{
 		memberRepo:     memberRepo,
 		collectionRepo: collectionRepo,
+		invitationRepo: invitationRepo,
+		transactor:     transactor,
 	}
 }
 
@@ -98,7 +104,10 @@ func (s *MemberService) ModifyMember(ctx context.Context, collectionUID, usernam
 	}
 
 	// Parse access level
-	newLevel := stringToAccessLevel(accessLevelStr)
+	newLevel, err := parseAccessLevel(accessLevelStr)
+	if err != nil {
+		return err
+	}
 
 	// Get target member by username
 	targetMember, err := s.memberRepo.GetByUsernameAndCollection(ctx, username, col.ID)
@@ -108,13 +117,31 @@ func (s *MemberService) ModifyMember(ctx context.Context, collectionUID, usernam
 	if targetMember == nil {
 		return pkgerrors.ErrNotMember
 	}
+	if targetMember.UserID == col.OwnerID && newLevel != targetMember.AccessLevel {
+		return pkgerrors.ErrOwnerImmutable.WithDetail("The collection owner's access level can't be changed")
+	}
 
-	// Update access level
-	targetMember.AccessLevel = newLevel
-	return s.memberRepo.Update(ctx, targetMember)
+	// Update access level. An admin losing admin access can no longer
+	// stand behind the invitations they sent, so those are withdrawn.
+	// The update gives the membership a new stoken, so the member's devices
+	// pick up the new access level on their next sync.
+	demoted := targetMember.IsAdmin() && newLevel != model.AccessLevelAdmin
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if demoted {
+			if err := s.checkOtherAdmin(ctx, targetMember); err != nil {
+				return err
+			}
+			if err := s.invitationRepo.DeleteFromMember(ctx, targetMember.ID); err != nil {
+				return err
+			}
+		}
+		targetMember.AccessLevel = newLevel
+		return s.memberRepo.Update(ctx, targetMember)
+	})
 }
 
-// RemoveMember removes a member from a collection
+// RemoveMember removes a member from a collection.
+// The owner can't be removed, not even by another admin.
 func (s *MemberService) RemoveMember(ctx context.Context, collectionUID, username string, userID uint) error {
 	// Get collection
 	col, err := s.collectionRepo.GetByUID(ctx, collectionUID)
@@ -134,8 +161,19 @@ func (s *MemberService) RemoveMember(ctx context.Context, collectionUID, usernam
 		return pkgerrors.ErrAdminRequired
 	}
 
-	// TODO: Get target user by username and remove them
-	return nil
+	// Get target member by username
+	targetMember, err := s.memberRepo.GetByUsernameAndCollection(ctx, username, col.ID)
+	if err != nil {
+		return err
+	}
+	if targetMember == nil {
+		return pkgerrors.ErrDoesNotExist.WithDetail("Member not found")
+	}
+	if targetMember.UserID == col.OwnerID {
+		return pkgerrors.ErrOwnerImmutable.WithDetail("The collection owner can't be removed")
+	}
+
+	return s.removeMember(ctx, targetMember)
 }
 
 // LeaveCollection allows a user to leave a collection
@@ -160,10 +198,43 @@ func (s *MemberService) LeaveCollection(ctx context.Context, collectionUID strin
 
 	// Can't leave if you're the owner (admin)
 	if member.IsAdmin() && col.OwnerID == userID {
-		return pkgerrors.ErrAdminRequired.WithDetail("Owner cannot leave collection")
+		return pkgerrors.ErrOwnerImmutable.WithDetail("Owner cannot leave collection")
 	}
 
-	return s.memberRepo.Delete(ctx, member.ID)
+	return s.removeMember(ctx, member)
+}
+
+// removeMember deletes a membership, recording its removal with a new stoken
+// so the member's devices drop the collection on their next sync. Invitations
+// the member sent are withdrawn along with it.
+func (s *MemberService) removeMember(ctx context.Context, member *model.CollectionMember) error {
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if member.IsAdmin() {
+			if err := s.checkOtherAdmin(ctx, member); err != nil {
+				return err
+			}
+		}
+		if err := s.invitationRepo.DeleteFromMember(ctx, member.ID); err != nil {
+			return err
+		}
+		return s.memberRepo.Delete(ctx, member.ID)
+	})
+}
+
+// checkOtherAdmin returns ErrLastAdmin unless member's collection has an
+// admin besides member. Run within the transaction making the change, so
+// concurrent changes can't each leave the other admin alone.
+func (s *MemberService) checkOtherAdmin(ctx context.Context, member *model.CollectionMember) error {
+	members, err := s.memberRepo.ListForCollection(ctx, member.CollectionID)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.ID != member.ID && m.IsAdmin() {
+			return nil
+		}
+	}
+	return pkgerrors.ErrLastAdmin
 }
 
 func accessLevelToString(level model.AccessLevel) string {
@@ -177,14 +248,16 @@ func accessLevelToString(level model.AccessLevel) string {
 	}
 }
 
-func stringToAccessLevel(s string) model.AccessLevel {
+// parseAccessLevel parses an access level as sent by clients
+func parseAccessLevel(s string) (model.AccessLevel, error) {
 	switch s {
 	case "admin":
-		return model.AccessLevelAdmin
+		return model.AccessLevelAdmin, nil
 	case "readWrite":
-		return model.AccessLevelReadWrite
-	default:
-		return model.AccessLevelReadOnly
+		return model.AccessLevelReadWrite, nil
+	case "readOnly":
+		return model.AccessLevelReadOnly, nil
 	}
+	return 0, pkgerrors.NewValidationError("accessLevel", "accessLevel must be admin, readWrite or readOnly")
 }
 
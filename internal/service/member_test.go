@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goatsync/internal/model"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+func TestMemberService_RemoveMember(t *testing.T) {
+	ctx := context.Background()
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	invitationRepo := NewMockInvitationRepository(memberRepo)
+	svc := NewMemberService(memberRepo, collectionRepo, invitationRepo, MockTransactor{})
+
+	col := &model.Collection{UID: "col", OwnerID: 1}
+	_ = collectionRepo.Create(ctx, col)
+	member := func(userID uint, username string, level model.AccessLevel) *model.CollectionMember {
+		m := &model.CollectionMember{
+			CollectionID: col.ID,
+			UserID:       userID,
+			AccessLevel:  level,
+			User:         &model.User{ID: userID, Username: username},
+		}
+		_ = memberRepo.Create(ctx, m)
+		return m
+	}
+	member(1, "owner", model.AccessLevelAdmin)
+	member(2, "admin", model.AccessLevelAdmin)
+	writer := member(3, "writer", model.AccessLevelReadWrite)
+	reader := member(4, "reader", model.AccessLevelReadOnly)
+
+	// The writer's invitation is withdrawn with them, the reader's is kept
+	_ = invitationRepo.Create(ctx, &model.CollectionInvitation{UID: "from-writer", FromMemberID: writer.ID, UserID: 5})
+	_ = invitationRepo.Create(ctx, &model.CollectionInvitation{UID: "from-reader", FromMemberID: reader.ID, UserID: 6})
+
+	tests := []struct {
+		name     string
+		userID   uint
+		username string
+		code     string
+	}{
+		{"not an admin", 4, "writer", pkgerrors.ErrAdminRequired.Code},
+		{"unknown member", 2, "nobody", pkgerrors.ErrDoesNotExist.Code},
+		{"owner", 2, "owner", pkgerrors.ErrOwnerImmutable.Code},
+		{"by an admin", 2, "writer", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.RemoveMember(ctx, "col", tt.username, tt.userID)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("RemoveMember failed: %v", err)
+				}
+				return
+			}
+			var etebaseErr *pkgerrors.EtebaseError
+			if !errors.As(err, &etebaseErr) || etebaseErr.Code != tt.code {
+				t.Errorf("Expected %s, got %v", tt.code, err)
+			}
+		})
+	}
+
+	if m, _ := memberRepo.GetByUserAndCollection(ctx, 3, col.ID); m != nil {
+		t.Error("Expected the writer's membership to be deleted")
+	}
+	if len(memberRepo.removed) != 1 || memberRepo.removed[0].UserID != 3 {
+		t.Errorf("Expected only the writer's removal recorded, got %+v", memberRepo.removed)
+	}
+	if inv, _ := invitationRepo.GetByUID(ctx, "from-writer"); inv != nil {
+		t.Error("Expected the writer's invitation to be withdrawn")
+	}
+	if inv, _ := invitationRepo.GetByUID(ctx, "from-reader"); inv == nil {
+		t.Error("Expected the reader's invitation to be kept")
+	}
+}
+
+func TestMemberService_ModifyMemberDemotion(t *testing.T) {
+	ctx := context.Background()
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	invitationRepo := NewMockInvitationRepository(memberRepo)
+	svc := NewMemberService(memberRepo, collectionRepo, invitationRepo, MockTransactor{})
+
+	col := &model.Collection{UID: "col", OwnerID: 1}
+	_ = collectionRepo.Create(ctx, col)
+	_ = memberRepo.Create(ctx, &model.CollectionMember{CollectionID: col.ID, UserID: 1, AccessLevel: model.AccessLevelAdmin})
+	admin := &model.CollectionMember{
+		CollectionID: col.ID,
+		UserID:       2,
+		AccessLevel:  model.AccessLevelAdmin,
+		User:         &model.User{ID: 2, Username: "admin"},
+	}
+	_ = memberRepo.Create(ctx, admin)
+	_ = invitationRepo.Create(ctx, &model.CollectionInvitation{UID: "invite", FromMemberID: admin.ID, UserID: 3})
+
+	// Staying an admin keeps the invitation
+	if err := svc.ModifyMember(ctx, "col", "admin", 1, "admin"); err != nil {
+		t.Fatalf("ModifyMember failed: %v", err)
+	}
+	if inv, _ := invitationRepo.GetByUID(ctx, "invite"); inv == nil {
+		t.Fatal("Expected the invitation to be kept")
+	}
+
+	if err := svc.ModifyMember(ctx, "col", "admin", 1, "readWrite"); err != nil {
+		t.Fatalf("ModifyMember failed: %v", err)
+	}
+	if m, _ := memberRepo.GetByID(ctx, admin.ID); m.AccessLevel != model.AccessLevelReadWrite {
+		t.Errorf("Expected read-write access, got %v", m.AccessLevel)
+	}
+	if inv, _ := invitationRepo.GetByUID(ctx, "invite"); inv != nil {
+		t.Error("Expected the demoted admin's invitation to be withdrawn")
+	}
+}
+
+func TestMemberService_ModifyMember(t *testing.T) {
+	ctx := context.Background()
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	svc := NewMemberService(memberRepo, collectionRepo, NewMockInvitationRepository(memberRepo), MockTransactor{})
+
+	col := &model.Collection{UID: "col", OwnerID: 1}
+	_ = collectionRepo.Create(ctx, col)
+	member := func(userID uint, username string, level model.AccessLevel) {
+		_ = memberRepo.Create(ctx, &model.CollectionMember{
+			CollectionID: col.ID,
+			UserID:       userID,
+			AccessLevel:  level,
+			User:         &model.User{ID: userID, Username: username},
+		})
+	}
+	member(1, "owner", model.AccessLevelAdmin)
+	member(2, "admin", model.AccessLevelAdmin)
+	member(3, "reader", model.AccessLevelReadOnly)
+
+	tests := []struct {
+		name     string
+		userID   uint
+		username string
+		level    string
+		code     string
+	}{
+		{"unknown level", 1, "reader", "owner", "validation_error"},
+		{"empty level", 1, "reader", "", "validation_error"},
+		{"owner", 2, "owner", "readWrite", pkgerrors.ErrOwnerImmutable.Code},
+		{"owner unchanged", 2, "owner", "admin", ""},
+		{"promote", 1, "reader", "readWrite", ""},
+		{"demote self", 2, "admin", "readOnly", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.ModifyMember(ctx, "col", tt.username, tt.userID, tt.level)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("ModifyMember failed: %v", err)
+				}
+				return
+			}
+			var etebaseErr *pkgerrors.EtebaseError
+			if !errors.As(err, &etebaseErr) || etebaseErr.Code != tt.code {
+				t.Fatalf("Expected %s, got %v", tt.code, err)
+			}
+			if tt.code == "validation_error" && etebaseErr.Field != "accessLevel" {
+				t.Errorf("Expected the error to point at accessLevel, got %q", etebaseErr.Field)
+			}
+		})
+	}
+
+	if m, _ := memberRepo.GetByUserAndCollection(ctx, 3, col.ID); m.AccessLevel != model.AccessLevelReadWrite {
+		t.Errorf("Expected reader to be promoted, got %v", m.AccessLevel)
+	}
+}
+
+func TestMemberService_LastAdmin(t *testing.T) {
+	ctx := context.Background()
+	collectionRepo := NewMockCollectionRepository()
+	memberRepo := NewMockMemberRepository()
+	svc := NewMemberService(memberRepo, collectionRepo, NewMockInvitationRepository(memberRepo), MockTransactor{})
+
+	// A collection whose owner isn't an admin any more, as older servers allowed
+	col := &model.Collection{UID: "col", OwnerID: 1}
+	_ = collectionRepo.Create(ctx, col)
+	_ = memberRepo.Create(ctx, &model.CollectionMember{CollectionID: col.ID, UserID: 1, AccessLevel: model.AccessLevelReadOnly})
+	_ = memberRepo.Create(ctx, &model.CollectionMember{
+		CollectionID: col.ID,
+		UserID:       2,
+		AccessLevel:  model.AccessLevelAdmin,
+		User:         &model.User{ID: 2, Username: "admin"},
+	})
+
+	lastAdmin := func(err error) bool {
+		var etebaseErr *pkgerrors.EtebaseError
+		return errors.As(err, &etebaseErr) && etebaseErr.Code == pkgerrors.ErrLastAdmin.Code
+	}
+	if err := svc.ModifyMember(ctx, "col", "admin", 2, "readWrite"); !lastAdmin(err) {
+		t.Errorf("Expected last_admin demoting the only admin, got %v", err)
+	}
+	if err := svc.LeaveCollection(ctx, "col", 2); !lastAdmin(err) {
+		t.Errorf("Expected last_admin when the only admin leaves, got %v", err)
+	}
+	if m, _ := memberRepo.GetByUserAndCollection(ctx, 2, col.ID); m == nil || !m.IsAdmin() {
+		t.Errorf("Expected the admin to be left as they were, got %+v", m)
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"goatsync/internal/storage"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// Prefetch controls how much content item and collection responses carry.
+// Clients send it as the prefetch query parameter.
+//
+// Like the reference server, revision metas are always sent in full and
+// only chunk contents depend on the mode.
+type Prefetch string
+
+const (
+	PrefetchAuto   Prefetch = "auto"   // Chunk contents are sent along with their UIDs (the default)
+	PrefetchMedium Prefetch = "medium" // Only chunk UIDs are sent; clients download chunks when needed
+)
+
+// ParsePrefetch parses the prefetch query parameter; empty means auto
+func ParsePrefetch(value string) (Prefetch, error) {
+	switch Prefetch(value) {
+	case "", PrefetchAuto:
+		return PrefetchAuto, nil
+	case PrefetchMedium:
+		return PrefetchMedium, nil
+	}
+	return "", pkgerrors.NewValidationError("prefetch", "prefetch must be auto or medium")
+}
+
+// prefetchContent fills in the chunk contents of content if prefetch asks for them.
+// A chunk whose file is missing or damaged is sent without its content,
+// so the client only fails when it downloads that chunk by itself.
+func (s *ChunkService) prefetchContent(ctx context.Context, content *ContentOut, prefetch Prefetch) error {
+	if prefetch != PrefetchAuto {
+		return nil
+	}
+
+	for i := range content.Chunks {
+		chunk := &content.Chunks[i]
+		if chunk.chunk == nil {
+			continue
+		}
+
+		data, err := s.readChunk(ctx, chunk)
+		if errors.Is(err, storage.ErrChunkNotFound) || errors.Is(err, storage.ErrChunkCorrupted) {
+			log.Printf("chunk %s can't be prefetched (%s): %v", chunk.UID, chunk.chunk.ChunkFile, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		chunk.Content = data
+	}
+	return nil
+}
+
+// readChunk reads a chunk's content in full, verifying it against its blob's checksum
+func (s *ChunkService) readChunk(ctx context.Context, chunk *ChunkOut) ([]byte, error) {
+	content, err := s.store.Get(ctx, chunk.chunk.ChunkFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = content.Close() }()
+
+	// Chunks stored before checksums were recorded can't be verified
+	blob := chunk.chunk.Blob
+	if blob == nil {
+		return io.ReadAll(content)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(content, blob.Size+1))
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.Verify(bytes.NewReader(data), blob.Hash, blob.Size); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// quotaNames are the user-facing names of quota resources
+var quotaNames = map[model.QuotaResource]string{
+	model.QuotaChunkBytes:  "chunk storage",
+	model.QuotaCollections: "collections",
+	model.QuotaItems:       "items",
+}
+
+// QuotaService enforces per-user quotas.
+// Usage is charged to the owner of the collection being written to.
+type QuotaService struct {
+	quotaRepo repository.QuotaRepository
+	cfg       *config.Config
+}
+
+// NewQuotaService creates a new quota service
+func NewQuotaService(quotaRepo repository.QuotaRepository, cfg *config.Config) *QuotaService {
+	return &QuotaService{
+		quotaRepo: quotaRepo,
+		cfg:       cfg,
+	}
+}
+
+// Charge reserves amount of resource for a user.
+// Returns ErrQuotaExceeded if that would take the user past their limit.
+func (s *QuotaService) Charge(ctx context.Context, userID uint, resource model.QuotaResource, amount int64) error {
+	if amount == 0 {
+		return nil
+	}
+
+	quota, err := s.quotaRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	limit := s.Limit(quota, resource)
+	ok, err := s.quotaRepo.Charge(ctx, userID, resource, amount, limit)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return quotaExceeded(resource, limit)
+	}
+	return nil
+}
+
+// Check returns ErrQuotaExceeded if charging amount of resource would take
+// the user past their limit, without charging anything
+func (s *QuotaService) Check(ctx context.Context, userID uint, resource model.QuotaResource, amount int64) error {
+	quota, err := s.quotaRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	limit := s.Limit(quota, resource)
+	if limit > 0 && quota.Used(resource)+amount > limit {
+		return quotaExceeded(resource, limit)
+	}
+	return nil
+}
+
+// Remaining returns how much more of resource the user may use,
+// or -1 if their usage isn't limited
+func (s *QuotaService) Remaining(ctx context.Context, userID uint, resource model.QuotaResource) (int64, error) {
+	quota, err := s.quotaRepo.Get(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	limit := s.Limit(quota, resource)
+	if limit <= 0 {
+		return -1, nil
+	}
+	return max(limit-quota.Used(resource), 0), nil
+}
+
+// Refund gives back amount of resource previously charged to a user
+func (s *QuotaService) Refund(ctx context.Context, userID uint, resource model.QuotaResource, amount int64) error {
+	if amount == 0 {
+		return nil
+	}
+	return s.quotaRepo.Refund(ctx, userID, resource, amount)
+}
+
+// MoveCollection charges a collection's usage to its new owner and refunds it
+// to the old one. Returns ErrQuotaExceeded if the new owner has no room for it,
+// in which case the transaction in ctx must be rolled back.
+func (s *QuotaService) MoveCollection(ctx context.Context, collectionID, fromUserID, toUserID uint) error {
+	usage, err := s.quotaRepo.CollectionUsage(ctx, collectionID)
+	if err != nil {
+		return err
+	}
+	// The old owner's quota must exist before anything is refunded to it
+	if _, err := s.quotaRepo.Get(ctx, fromUserID); err != nil {
+		return err
+	}
+
+	for _, resource := range []model.QuotaResource{model.QuotaCollections, model.QuotaItems, model.QuotaChunkBytes} {
+		if err := s.Charge(ctx, toUserID, resource, usage.Used(resource)); err != nil {
+			return err
+		}
+		if err := s.Refund(ctx, fromUserID, resource, usage.Used(resource)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Limit returns the user's effective limit for resource (0 = unlimited)
+func (s *QuotaService) Limit(quota *model.UserQuota, resource model.QuotaResource) int64 {
+	if override := quota.Limit(resource); override != nil {
+		return *override
+	}
+
+	switch resource {
+	case model.QuotaChunkBytes:
+		return s.cfg.QuotaChunkBytes
+	case model.QuotaCollections:
+		return s.cfg.QuotaCollections
+	case model.QuotaItems:
+		return s.cfg.QuotaItems
+	}
+	return 0
+}
+
+// quotaExceeded returns ErrQuotaExceeded naming the exceeded resource
+func quotaExceeded(resource model.QuotaResource, limit int64) error {
+	return pkgerrors.ErrQuotaExceeded.WithDetail(
+		fmt.Sprintf("Quota exceeded: %s (limit %d)", quotaNames[resource], limit))
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	pkgerrors "goatsync/pkg/errors"
+)
+
+// MockQuotaRepository is a mock implementation for testing
+type MockQuotaRepository struct {
+	quotas map[uint]*model.UserQuota
+	usage  map[uint]*model.UserQuota // By collection ID
+}
+
+func NewMockQuotaRepository() *MockQuotaRepository {
+	return &MockQuotaRepository{
+		quotas: make(map[uint]*model.UserQuota),
+		usage:  make(map[uint]*model.UserQuota),
+	}
+}
+
+func (m *MockQuotaRepository) Get(ctx context.Context, userID uint) (*model.UserQuota, error) {
+	if _, ok := m.quotas[userID]; !ok {
+		m.quotas[userID] = &model.UserQuota{UserID: userID}
+	}
+	quota := *m.quotas[userID]
+	return &quota, nil
+}
+
+func (m *MockQuotaRepository) used(userID uint, resource model.QuotaResource) *int64 {
+	quota := m.quotas[userID]
+	switch resource {
+	case model.QuotaChunkBytes:
+		return &quota.UsedChunkBytes
+	case model.QuotaCollections:
+		return &quota.UsedCollections
+	default:
+		return &quota.UsedItems
+	}
+}
+
+func (m *MockQuotaRepository) Charge(ctx context.Context, userID uint, resource model.QuotaResource, amount, limit int64) (bool, error) {
+	used := m.used(userID, resource)
+	if limit > 0 && *used+amount > limit {
+		return false, nil
+	}
+	*used += amount
+	return true, nil
+}
+
+func (m *MockQuotaRepository) Refund(ctx context.Context, userID uint, resource model.QuotaResource, amount int64) error {
+	used := m.used(userID, resource)
+	*used = max(*used-amount, 0)
+	return nil
+}
+
+func (m *MockQuotaRepository) SetLimits(ctx context.Context, quota *model.UserQuota) error {
+	stored := m.quotas[quota.UserID]
+	stored.MaxChunkBytes = quota.MaxChunkBytes
+	stored.MaxCollections = quota.MaxCollections
+	stored.MaxItems = quota.MaxItems
+	return nil
+}
+
+func (m *MockQuotaRepository) Recalculate(ctx context.Context, userID uint) (*model.UserQuota, error) {
+	return m.Get(ctx, userID)
+}
+
+func (m *MockQuotaRepository) CollectionUsage(ctx context.Context, collectionID uint) (*model.UserQuota, error) {
+	if usage, ok := m.usage[collectionID]; ok {
+		return usage, nil
+	}
+	return &model.UserQuota{UsedCollections: 1}, nil
+}
+
+func TestQuotaService_Charge(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMockQuotaRepository()
+	cfg := &config.Config{QuotaChunkBytes: 100, QuotaItems: 2}
+	svc := NewQuotaService(repo, cfg)
+
+	// Default limit
+	if err := svc.Charge(ctx, 1, model.QuotaChunkBytes, 60); err != nil {
+		t.Fatalf("Charge within limit failed: %v", err)
+	}
+	err := svc.Charge(ctx, 1, model.QuotaChunkBytes, 60)
+	var etebaseErr *pkgerrors.EtebaseError
+	if !errors.As(err, &etebaseErr) || etebaseErr.Code != pkgerrors.ErrQuotaExceeded.Code {
+		t.Fatalf("Expected quota_exceeded, got %v", err)
+	}
+	if repo.quotas[1].UsedChunkBytes != 60 {
+		t.Errorf("Rejected charge changed usage to %d", repo.quotas[1].UsedChunkBytes)
+	}
+
+	// Refunds free up space
+	if err := svc.Refund(ctx, 1, model.QuotaChunkBytes, 60); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if err := svc.Charge(ctx, 1, model.QuotaChunkBytes, 100); err != nil {
+		t.Errorf("Charge after refund failed: %v", err)
+	}
+
+	// A zero default is unlimited
+	if err := svc.Charge(ctx, 1, model.QuotaCollections, 1000); err != nil {
+		t.Errorf("Unlimited charge failed: %v", err)
+	}
+
+	// Per-user overrides take precedence over the default
+	unlimited, one := int64(0), int64(1)
+	_ = repo.SetLimits(ctx, &model.UserQuota{UserID: 1, MaxItems: &unlimited, MaxCollections: &one})
+	if err := svc.Charge(ctx, 1, model.QuotaItems, 10); err != nil {
+		t.Errorf("Charge with unlimited override failed: %v", err)
+	}
+	if err := svc.Charge(ctx, 1, model.QuotaCollections, 1); err == nil {
+		t.Error("Expected charge past the override to fail")
+	}
+
+	// Quotas are per user
+	if err := svc.Charge(ctx, 2, model.QuotaItems, 2); err != nil {
+		t.Errorf("Charge for another user failed: %v", err)
+	}
+}
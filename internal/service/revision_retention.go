@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+	"goatsync/internal/repository"
+)
+
+// pruneBatchSize is the number of revisions removed at once
+const pruneBatchSize = 500
+
+// RetentionPolicy decides which revisions of an item are kept.
+// The current revision is always kept, and so is any revision either rule
+// keeps; with both rules disabled the whole history is kept.
+type RetentionPolicy struct {
+	KeepRevisions int // Newest revisions kept per item, including the current one (0 disables the rule)
+	KeepDays      int // Revisions younger than this many days are kept (0 disables the rule)
+}
+
+// KeepsAll reports whether the policy never lets a revision go
+func (p RetentionPolicy) KeepsAll() bool {
+	return p.KeepRevisions <= 0 && p.KeepDays <= 0
+}
+
+// RevisionPruner removes revisions the retention policy no longer keeps,
+// along with their chunk references. Chunks left without references are
+// released, and their files left to the ChunkCollector once no chunk holds them.
+type RevisionPruner struct {
+	revisionRepo  repository.RevisionRepository
+	retentionRepo repository.RetentionRepository
+	chunkService  *ChunkService
+	cfg           *config.Config
+}
+
+// NewRevisionPruner creates a new revision pruner
+func NewRevisionPruner(
+	revisionRepo repository.RevisionRepository,
+	retentionRepo repository.RetentionRepository,
+	chunkService *ChunkService,
+	cfg *config.Config,
+) *RevisionPruner {
+	return &RevisionPruner{
+		revisionRepo:  revisionRepo,
+		retentionRepo: retentionRepo,
+		chunkService:  chunkService,
+		cfg:           cfg,
+	}
+}
+
+// DefaultPolicy returns the policy of collections without an override
+func (p *RevisionPruner) DefaultPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		KeepRevisions: p.cfg.RevisionKeepCount,
+		KeepDays:      p.cfg.RevisionKeepDays,
+	}
+}
+
+// Policy returns the effective policy of a collection with the given override
+func (p *RevisionPruner) Policy(override *model.CollectionRetention) RetentionPolicy {
+	policy := p.DefaultPolicy()
+	if override == nil {
+		return policy
+	}
+	if override.KeepRevisions != nil {
+		policy.KeepRevisions = *override.KeepRevisions
+	}
+	if override.KeepDays != nil {
+		policy.KeepDays = *override.KeepDays
+	}
+	return policy
+}
+
+// RevisionPruneReport summarizes a pruning run
+type RevisionPruneReport struct {
+	DryRun    bool
+	Revisions int // Revisions removed, or that would be in a dry run
+}
+
+// String returns a one-line summary of the report
+func (r *RevisionPruneReport) String() string {
+	verb := "removed"
+	if r.DryRun {
+		verb = "would remove"
+	}
+	return fmt.Sprintf("revision pruning %s %d revisions", verb, r.Revisions)
+}
+
+// Run prunes every collection by its effective policy.
+// In a dry run, revisions are only counted.
+func (p *RevisionPruner) Run(ctx context.Context, dryRun bool) (*RevisionPruneReport, error) {
+	report := &RevisionPruneReport{DryRun: dryRun}
+	now := time.Now()
+
+	// Collections without an override follow the default
+	if err := p.prune(ctx, 0, p.DefaultPolicy(), now, dryRun, report); err != nil {
+		return nil, err
+	}
+
+	overrides, err := p.retentionRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range overrides {
+		policy := p.Policy(&overrides[i])
+		if err := p.prune(ctx, overrides[i].CollectionID, policy, now, dryRun, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// prune removes the revisions policy lets go in one collection, or with
+// collectionID 0, in all collections without an override
+func (p *RevisionPruner) prune(
+	ctx context.Context,
+	collectionID uint,
+	policy RetentionPolicy,
+	now time.Time,
+	dryRun bool,
+	report *RevisionPruneReport,
+) error {
+	if policy.KeepsAll() {
+		return nil
+	}
+
+	filter := repository.PruneFilter{
+		CollectionID:  collectionID,
+		KeepRevisions: max(policy.KeepRevisions, 0),
+		Limit:         pruneBatchSize,
+	}
+	if policy.KeepDays > 0 {
+		filter.CreatedBefore = now.AddDate(0, 0, -policy.KeepDays)
+	}
+
+	for {
+		ids, err := p.revisionRepo.ListPrunable(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		filter.AfterID = ids[len(ids)-1]
+
+		if dryRun {
+			report.Revisions += len(ids)
+			continue
+		}
+
+		// Chunk references go first: if deleting the revisions fails, the
+		// next run finds them again and finishes the job
+		if err := p.chunkService.ReleaseRevisions(ctx, ids); err != nil {
+			return err
+		}
+		deleted, err := p.revisionRepo.DeleteNonCurrent(ctx, ids)
+		if err != nil {
+			return err
+		}
+		report.Revisions += int(deleted)
+	}
+}
+
+// RunJob performs a pruning pass and logs the result, for use as a background job
+func (p *RevisionPruner) RunJob() func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		report, err := p.Run(ctx, false)
+		if err != nil {
+			return err
+		}
+		log.Println(report)
+		return nil
+	}
+}
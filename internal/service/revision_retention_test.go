@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"goatsync/internal/config"
+	"goatsync/internal/model"
+)
+
+// MockRetentionRepository is a mock implementation for testing
+type MockRetentionRepository struct {
+	retentions map[uint]*model.CollectionRetention
+}
+
+func NewMockRetentionRepository() *MockRetentionRepository {
+	return &MockRetentionRepository{retentions: make(map[uint]*model.CollectionRetention)}
+}
+
+func (m *MockRetentionRepository) Get(ctx context.Context, collectionID uint) (*model.CollectionRetention, error) {
+	return m.retentions[collectionID], nil
+}
+
+func (m *MockRetentionRepository) Set(ctx context.Context, retention *model.CollectionRetention) error {
+	if retention.KeepRevisions == nil && retention.KeepDays == nil {
+		delete(m.retentions, retention.CollectionID)
+		return nil
+	}
+	m.retentions[retention.CollectionID] = retention
+	return nil
+}
+
+func (m *MockRetentionRepository) List(ctx context.Context) ([]model.CollectionRetention, error) {
+	var out []model.CollectionRetention
+	for _, retention := range m.retentions {
+		out = append(out, *retention)
+	}
+	return out, nil
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	pruner := NewRevisionPruner(nil, nil, nil, &config.Config{RevisionKeepCount: 5, RevisionKeepDays: 30})
+
+	tests := []struct {
+		name     string
+		override *model.CollectionRetention
+		expected RetentionPolicy
+	}{
+		{"no override", nil, RetentionPolicy{KeepRevisions: 5, KeepDays: 30}},
+		{"count override", &model.CollectionRetention{KeepRevisions: intPtr(10)}, RetentionPolicy{KeepRevisions: 10, KeepDays: 30}},
+		{"keep everything", &model.CollectionRetention{KeepRevisions: intPtr(0), KeepDays: intPtr(0)}, RetentionPolicy{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pruner.Policy(tt.override); got != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+
+	if !(RetentionPolicy{}).KeepsAll() || (RetentionPolicy{KeepDays: 1}).KeepsAll() {
+		t.Error("KeepsAll should only hold with both rules disabled")
+	}
+}
+
+func TestRevisionPruner_Run(t *testing.T) {
+	ctx := context.Background()
+	svc, chunkService, _ := newTestItemService(t)
+	revisionRepo := svc.revisionRepo.(*MockRevisionRepository)
+	chunkRepo := chunkService.chunkRepo.(*MockChunkRepository)
+
+	// Five revisions of one item, each with its own chunk
+	for i, uid := range []string{"rev1", "rev2", "rev3", "rev4", "rev5"} {
+		req := &ItemBatchRequest{Items: []ItemBatchIn{{
+			UID: "item",
+			Content: ContentIn{
+				UID:    uid,
+				Chunks: []ChunkIn{{UID: "chunk-" + uid, Content: []byte(strings.Repeat("x", i+1))}},
+			},
+		}}}
+		if err := svc.BatchItems(ctx, "col", 1, "", req); err != nil {
+			t.Fatalf("BatchItems failed: %v", err)
+		}
+	}
+	// The oldest three are a year old
+	old := time.Now().AddDate(-1, 0, 0)
+	for _, rev := range revisionRepo.revisions[:3] {
+		rev.CreatedAt = &old
+	}
+
+	remaining := func() []string {
+		var uids []string
+		for _, rev := range revisionRepo.revisions {
+			uids = append(uids, rev.UID)
+		}
+		return uids
+	}
+
+	cfg := &config.Config{}
+	retentionRepo := NewMockRetentionRepository()
+	pruner := NewRevisionPruner(revisionRepo, retentionRepo, chunkService, cfg)
+
+	// The default policy keeps everything
+	report, err := pruner.Run(ctx, false)
+	if err != nil || report.Revisions != 0 {
+		t.Fatalf("Expected nothing pruned, got %v, %v", report, err)
+	}
+
+	// A dry run only counts
+	cfg.RevisionKeepCount = 2
+	report, err = pruner.Run(ctx, true)
+	if err != nil || report.Revisions != 3 || len(revisionRepo.revisions) != 5 {
+		t.Fatalf("Dry run: expected 3 counted and 5 kept, got %v, %d kept, %v", report, len(revisionRepo.revisions), err)
+	}
+
+	// With both rules, revisions go once neither keeps them
+	cfg.RevisionKeepCount, cfg.RevisionKeepDays = 1, 30
+	report, err = pruner.Run(ctx, false)
+	if err != nil || report.Revisions != 3 {
+		t.Fatalf("Expected 3 pruned, got %v, %v", report, err)
+	}
+	if got := remaining(); !equalStrings(got, []string{"rev4", "rev5"}) {
+		t.Errorf("Expected rev4 and rev5 to remain, got %v", got)
+	}
+
+	// The pruned revisions' chunks are released
+	for _, rel := range chunkRepo.relations {
+		if rel.RevisionID <= 3 {
+			t.Errorf("Revision %d still references chunk %d", rel.RevisionID, rel.ChunkID)
+		}
+	}
+	if len(chunkRepo.relations) != 2 {
+		t.Errorf("Expected 2 chunk references left, got %d", len(chunkRepo.relations))
+	}
+	// Their files are left for the garbage collector to remove
+	for _, chunk := range chunkRepo.chunks {
+		if exists, _ := chunkService.store.Exists(ctx, chunk.ChunkFile); !exists {
+			t.Errorf("Expected the file of chunk %s to be kept, but it's gone", chunk.UID)
+		}
+	}
+
+	// The current revision is never pruned
+	cfg.RevisionKeepCount, cfg.RevisionKeepDays = 1, 0
+	if _, err := pruner.Run(ctx, false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := remaining(); !equalStrings(got, []string{"rev5"}) {
+		t.Errorf("Expected only rev5 to remain, got %v", got)
+	}
+	if item, err := svc.GetItem(ctx, "col", "item", 1, PrefetchMedium); err != nil || item.Etag != "rev5" {
+		t.Errorf("Expected the item to still be at rev5, got %+v, %v", item, err)
+	}
+}
@@ -2,12 +2,19 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// tempFilePattern names the temporary files chunks are written to before
+// being renamed into place
+const tempFilePattern = ".tmp-*"
+
 // FileStorage handles chunk file storage on the filesystem
 type FileStorage struct {
 	basePath string
@@ -21,30 +28,37 @@ func NewFileStorage(basePath string) *FileStorage {
 // ChunkPath returns the path for a chunk file
 // Format: {basePath}/user_{userID}/{collectionUID}/{uidPrefix}/{uidRest}
 func (s *FileStorage) ChunkPath(userID uint, collectionUID, chunkUID string) string {
-	if len(chunkUID) < 2 {
-		return filepath.Join(s.basePath, fmt.Sprintf("user_%d", userID), collectionUID, chunkUID)
-	}
-	prefix := chunkUID[:2]
-	rest := chunkUID[2:]
-	return filepath.Join(s.basePath, fmt.Sprintf("user_%d", userID), collectionUID, prefix, rest)
+	return s.keyPath(ChunkKey(userID, collectionUID, chunkUID))
 }
 
-// SaveChunk saves chunk data to the filesystem
-func (s *FileStorage) SaveChunk(userID uint, collectionUID, chunkUID string, data []byte) error {
-	path := s.ChunkPath(userID, collectionUID, chunkUID)
-
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+// keyPath returns the filesystem path for a store key.
+// Chunk rows written before the ChunkStore abstraction stored the full
+// path including basePath, so those are used as-is.
+func (s *FileStorage) keyPath(key string) string {
+	if s.isLegacyPath(key) {
+		return key
 	}
+	return filepath.Join(s.basePath, filepath.FromSlash(key))
+}
+
+// legacyPath returns the value a chunk row written before the ChunkStore
+// abstraction holds for key
+func (s *FileStorage) legacyPath(key string) string {
+	return filepath.Join(s.basePath, filepath.FromSlash(key))
+}
 
-	// Write file
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return fmt.Errorf("failed to write chunk: %w", err)
+// isLegacyPath reports whether p is a full chunk path rather than a store key
+func (s *FileStorage) isLegacyPath(p string) bool {
+	if filepath.IsAbs(p) {
+		return true
 	}
+	base := filepath.Clean(s.basePath)
+	return base != "." && strings.HasPrefix(filepath.Clean(p), base+string(filepath.Separator))
+}
 
-	return nil
+// SaveChunk saves chunk data to the filesystem
+func (s *FileStorage) SaveChunk(userID uint, collectionUID, chunkUID string, data []byte) error {
+	return s.writeFile(s.ChunkPath(userID, collectionUID, chunkUID), bytes.NewReader(data))
 }
 
 // LoadChunk loads chunk data from the filesystem
@@ -64,13 +78,7 @@ func (s *FileStorage) LoadChunk(userID uint, collectionUID, chunkUID string) ([]
 
 // DeleteChunk deletes a chunk file from the filesystem
 func (s *FileStorage) DeleteChunk(userID uint, collectionUID, chunkUID string) error {
-	path := s.ChunkPath(userID, collectionUID, chunkUID)
-
-	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete chunk: %w", err)
-	}
-
-	return nil
+	return s.removeFile(s.ChunkPath(userID, collectionUID, chunkUID))
 }
 
 // ChunkExists checks if a chunk file exists
@@ -82,26 +90,133 @@ func (s *FileStorage) ChunkExists(userID uint, collectionUID, chunkUID string) b
 
 // SaveChunkFromReader saves chunk data from an io.Reader
 func (s *FileStorage) SaveChunkFromReader(userID uint, collectionUID, chunkUID string, reader io.Reader) error {
-	path := s.ChunkPath(userID, collectionUID, chunkUID)
+	return s.writeFile(s.ChunkPath(userID, collectionUID, chunkUID), reader)
+}
+
+// Put implements ChunkStore
+func (s *FileStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	return s.writeFile(s.keyPath(key), r)
+}
+
+// Get implements ChunkStore
+func (s *FileStorage) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	file, err := os.Open(s.keyPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrChunkNotFound
+		}
+		return nil, fmt.Errorf("failed to open chunk: %w", err)
+	}
+	return file, nil
+}
+
+// Delete implements ChunkStore
+func (s *FileStorage) Delete(ctx context.Context, key string) error {
+	return s.removeFile(s.keyPath(key))
+}
+
+// Exists implements ChunkStore
+func (s *FileStorage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(s.keyPath(key))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to stat chunk: %w", err)
+}
+
+// Walk implements ChunkStore
+func (s *FileStorage) Walk(ctx context.Context, fn func(ObjectInfo) error) error {
+	err := filepath.WalkDir(s.basePath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.basePath, path)
+		if err != nil {
+			return err
+		}
+		return fn(ObjectInfo{
+			Key:     filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	})
+	if os.IsNotExist(err) {
+		// Nothing has been stored yet
+		return nil
+	}
+	return err
+}
 
+// writeFile streams reader into path, creating parent directories as needed.
+//
+// The data is written to a temporary file next to path, fsynced and then
+// renamed into place, so a crash or an interrupted upload never leaves a
+// truncated file under path. Temporary files left behind by a crash are
+// removed by the chunk garbage collector.
+func (s *FileStorage) writeFile(path string, reader io.Reader) error {
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	// Create file
-	file, err := os.Create(path)
+	tmp, err := os.CreateTemp(dir, tempFilePattern)
 	if err != nil {
 		return fmt.Errorf("failed to create chunk file: %w", err)
 	}
-	defer func() { _ = file.Close() }()
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
 
-	// Copy data
-	if _, err := io.Copy(file, reader); err != nil {
+	if _, err := io.Copy(tmp, reader); err != nil {
+		return fmt.Errorf("failed to write chunk data: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
 		return fmt.Errorf("failed to write chunk data: %w", err)
 	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync chunk file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write chunk data: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to move chunk file into place: %w", err)
+	}
+	committed = true
+
+	// Persist the rename itself; not every filesystem supports syncing directories
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
 
 	return nil
 }
 
+// removeFile deletes path, ignoring files that are already gone
+func (s *FileStorage) removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete chunk: %w", err)
+	}
+
+	return nil
+}
@@ -1,9 +1,14 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestFileStorage_ChunkPath(t *testing.T) {
@@ -120,3 +125,94 @@ func TestFileStorage_DirectoryCreation(t *testing.T) {
 	}
 }
 
+func TestFileStorage_InterruptedWrite(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	fs := NewFileStorage(tempDir)
+	key := ChunkKey(1, "collection", "chunk123456")
+
+	if err := fs.Put(ctx, key, strings.NewReader("original"), 8); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// An upload that dies half-way must not replace or truncate the stored chunk
+	broken := io.MultiReader(strings.NewReader("trunc"), iotest.ErrReader(errors.New("connection reset")))
+	if err := fs.Put(ctx, key, broken, 8); err == nil {
+		t.Fatal("Expected Put to fail")
+	}
+
+	data, err := fs.LoadChunk(1, "collection", "chunk123456")
+	if err != nil {
+		t.Fatalf("LoadChunk failed: %v", err)
+	}
+	if string(data) != "original" {
+		t.Errorf("Expected original content, got %q", data)
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(fs.keyPath(key)))
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the chunk file, found %d entries", len(entries))
+	}
+}
+
+func TestFileStorage_Walk(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "goatsync_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tempDir) }()
+
+	fs := NewFileStorage(tempDir)
+	ctx := context.Background()
+
+	keys := []string{BlobKey("aa11"), ChunkKey(1, "col", "chunk123456")}
+	for _, key := range keys {
+		if err := fs.Put(ctx, key, strings.NewReader("data"), 4); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	found := make(map[string]int64)
+	err = fs.Walk(ctx, func(obj ObjectInfo) error {
+		found[obj.Key] = obj.Size
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+
+	if len(found) != len(keys) {
+		t.Errorf("Walk found %d files, want %d", len(found), len(keys))
+	}
+	for _, key := range keys {
+		if found[key] != 4 {
+			t.Errorf("Walk didn't report %s with size 4", key)
+		}
+	}
+
+	// Walking a store that was never written to is not an error
+	if err := NewFileStorage(filepath.Join(tempDir, "missing")).Walk(ctx, func(ObjectInfo) error { return nil }); err != nil {
+		t.Errorf("Walk of missing directory returned error: %v", err)
+	}
+}
+
+func TestFileStorage_LegacyPaths(t *testing.T) {
+	fs := NewFileStorage("./data/chunks")
+	key := ChunkKey(1, "col123", "abcdef123456")
+
+	// Rows written before store keys hold the full path
+	legacy := "data/chunks/user_1/col123/ab/cdef123456"
+	if got := StoredLocations(fs, key); len(got) != 2 || got[1] != legacy {
+		t.Errorf("StoredLocations() = %v, want [%s %s]", got, key, legacy)
+	}
+	if got := fs.keyPath(legacy); got != legacy {
+		t.Errorf("keyPath(%s) = %s, want it unchanged", legacy, got)
+	}
+	if got := fs.keyPath(key); got != legacy {
+		t.Errorf("keyPath(%s) = %s, want %s", key, got, legacy)
+	}
+}
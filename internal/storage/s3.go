@@ -0,0 +1,484 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// unsignedPayload is sent instead of a body hash so uploads can be streamed
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Config holds the settings for an S3-compatible chunk store
+type S3Config struct {
+	Endpoint  string // host[:port] of the S3 API, e.g. "s3.amazonaws.com" or "minio:9000"
+	Region    string // Bucket region (default: us-east-1)
+	Bucket    string // Bucket holding the chunks
+	Prefix    string // Optional key prefix inside the bucket
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	PathStyle bool // Use path-style bucket addressing (required by most self-hosted servers)
+}
+
+// S3Storage stores chunks in an S3-compatible object store (AWS S3, MinIO, Garage, ...).
+// Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+// NewS3Storage creates a new S3-compatible chunk store.
+// The bucket must already exist.
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage requires an endpoint and a bucket")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+
+	return &S3Storage{
+		cfg:    cfg,
+		client: &http.Client{},
+	}, nil
+}
+
+// objectName returns the object name for a store key
+func (s *S3Storage) objectName(key string) string {
+	if s.cfg.Prefix == "" {
+		return key
+	}
+	return path.Join(s.cfg.Prefix, key)
+}
+
+// objectURL returns the URL of the object stored under key
+func (s *S3Storage) objectURL(key string) *url.URL {
+	return s.bucketURL("/" + s.objectName(key))
+}
+
+// bucketURL returns the URL of objectPath inside the bucket
+func (s *S3Storage) bucketURL(objectPath string) *url.URL {
+	scheme := "http"
+	if s.cfg.UseSSL {
+		scheme = "https"
+	}
+
+	u := &url.URL{Scheme: scheme, Host: s.cfg.Endpoint}
+	if s.cfg.PathStyle {
+		objectPath = "/" + s.cfg.Bucket + objectPath
+	} else {
+		u.Host = s.cfg.Bucket + "." + s.cfg.Endpoint
+	}
+	u.Path = objectPath
+	u.RawPath = s3EscapePath(objectPath)
+	return u
+}
+
+// Put implements ChunkStore
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	// S3 needs the length up front; buffer bodies of unknown size
+	if size < 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read chunk: %w", err)
+		}
+		r = bytes.NewReader(data)
+		size = int64(len(data))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), r)
+	if err != nil {
+		return fmt.Errorf("failed to upload chunk: %w", err)
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload chunk: %w", err)
+	}
+	defer drainAndClose(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to upload chunk: %w", s3ResponseError(resp))
+	}
+	return nil
+}
+
+// Get implements ChunkStore.
+// The returned object reads through ranged GET requests after a seek.
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	body, size, err := s.getRange(ctx, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{s: s, ctx: ctx, key: key, size: size, body: body}, nil
+}
+
+// getRange opens the object stored under key from offset to its end.
+// size is the total size of the object.
+func (s *S3Storage) getRange(ctx context.Context, key string, offset int64) (body io.ReadCloser, size int64, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download chunk: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download chunk: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK && offset == 0:
+		size = resp.ContentLength
+		if size < 0 {
+			// The object was sent without a Content-Length
+			if size, err = s.size(ctx, key); err != nil {
+				drainAndClose(resp.Body)
+				return nil, 0, err
+			}
+		}
+		return resp.Body, size, nil
+	case resp.StatusCode == http.StatusPartialContent:
+		// Content-Range: bytes {first}-{last}/{size}
+		contentRange := resp.Header.Get("Content-Range")
+		if _, err := fmt.Sscanf(contentRange[strings.LastIndex(contentRange, "/")+1:], "%d", &size); err != nil {
+			drainAndClose(resp.Body)
+			return nil, 0, fmt.Errorf("failed to download chunk: bad Content-Range %q", contentRange)
+		}
+		return resp.Body, size, nil
+	case resp.StatusCode == http.StatusNotFound:
+		drainAndClose(resp.Body)
+		return nil, 0, ErrChunkNotFound
+	default:
+		defer drainAndClose(resp.Body)
+		return nil, 0, fmt.Errorf("failed to download chunk: %w", s3ResponseError(resp))
+	}
+}
+
+// size returns the size of the object stored under key.
+func (s *S3Storage) size(ctx context.Context, key string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.objectURL(key).String(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat chunk: %w", err)
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat chunk: %w", err)
+	}
+	defer drainAndClose(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusOK && resp.ContentLength >= 0:
+		return resp.ContentLength, nil
+	case resp.StatusCode == http.StatusOK:
+		return 0, fmt.Errorf("failed to stat chunk: size of %q is unknown", key)
+	case resp.StatusCode == http.StatusNotFound:
+		return 0, ErrChunkNotFound
+	default:
+		return 0, fmt.Errorf("failed to stat chunk: %w", s3ResponseError(resp))
+	}
+}
+
+// s3Object is an object opened by S3Storage.Get.
+// Reading after a seek re-requests the object from the new offset.
+type s3Object struct {
+	s    *S3Storage
+	ctx  context.Context
+	key  string
+	size int64
+
+	offset     int64         // Current read offset
+	body       io.ReadCloser // Open response body, if any
+	bodyOffset int64         // Offset body is positioned at
+}
+
+// Read implements io.Reader
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil || o.bodyOffset != o.offset {
+		if o.body != nil {
+			_ = o.body.Close()
+			o.body = nil
+		}
+		body, _, err := o.s.getRange(o.ctx, o.key, o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body, o.bodyOffset = body, o.offset
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.bodyOffset += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, fmt.Errorf("s3: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("s3: negative position %d", offset)
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+// Close implements io.Closer
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// Delete implements ChunkStore
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key).String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete chunk: %w", err)
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete chunk: %w", err)
+	}
+	defer drainAndClose(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("failed to delete chunk: %w", s3ResponseError(resp))
+	}
+}
+
+// Exists implements ChunkStore
+func (s *S3Storage) Exists(ctx context.Context, key string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.objectURL(key).String(), nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat chunk: %w", err)
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat chunk: %w", err)
+	}
+	defer drainAndClose(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to stat chunk: %w", s3ResponseError(resp))
+	}
+}
+
+// listBucketResult is the response body of ListObjectsV2
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Walk implements ChunkStore
+func (s *S3Storage) Walk(ctx context.Context, fn func(ObjectInfo) error) error {
+	prefix := ""
+	if s.cfg.Prefix != "" {
+		prefix = s.cfg.Prefix + "/"
+	}
+
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		u := s.bucketURL("/")
+		u.RawQuery = s3CanonicalQuery(query)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return fmt.Errorf("failed to list chunks: %w", err)
+		}
+
+		resp, err := s.do(req)
+		if err != nil {
+			return fmt.Errorf("failed to list chunks: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3ResponseError(resp)
+			drainAndClose(resp.Body)
+			return fmt.Errorf("failed to list chunks: %w", err)
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		drainAndClose(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to decode chunk listing: %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			if err := fn(ObjectInfo{
+				Key:     strings.TrimPrefix(obj.Key, prefix),
+				Size:    obj.Size,
+				ModTime: obj.LastModified,
+			}); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do signs req and sends it
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + unsignedPayload + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, s.cfg.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath URI-encodes every byte of p except unreserved characters and '/'
+func s3EscapePath(p string) string {
+	return s3Escape(p, true)
+}
+
+// s3CanonicalQuery encodes query sorted by key, as required for signing
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape URI-encodes every byte of s except unreserved characters,
+// and '/' if keepSlash is set
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c == '/' && keepSlash) || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3ResponseError builds an error from a failed S3 response
+func s3ResponseError(resp *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil && body.Code != "" {
+		return fmt.Errorf("s3: %s (%s): %s", resp.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("s3: %s", resp.Status)
+}
+
+// drainAndClose discards the rest of body so the connection can be reused
+func drainAndClose(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	_ = body.Close()
+}
@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a minimal in-process S3 server supporting the object calls used by S3Storage.
+// Signatures are not verified, only required to be present.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+
+	// chunked sends whole objects without a Content-Length
+	chunked bool
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+		f.list(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = body
+		w.Header().Set("ETag", `"fake"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				_, _ = fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Message>not found</Message><Key>%s</Key></Error>", key)
+			}
+			return
+		}
+		w.Header().Set("ETag", `"fake"`)
+		if r.Method == http.MethodGet && f.chunked && r.Header.Get("Range") == "" {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(data)
+			w.(http.Flusher).Flush()
+			return
+		}
+		if r.Method == http.MethodGet {
+			// Handles Range requests like S3 does
+			http.ServeContent(w, r, "", time.Now(), bytes.NewReader(data))
+			return
+		}
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// fakeS3PageSize is the number of keys per listing page, small enough to exercise pagination
+const fakeS3PageSize = 2
+
+// list serves ListObjectsV2 for the bucket in the request path
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	bucket := strings.Trim(r.URL.Path, "/")
+	prefix := bucket + "/" + r.URL.Query().Get("prefix")
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := r.URL.Query().Get("continuation-token"); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := min(start+fakeS3PageSize, len(keys))
+
+	var b strings.Builder
+	b.WriteString("<ListBucketResult>")
+	for _, key := range keys[start:end] {
+		fmt.Fprintf(&b, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>",
+			strings.TrimPrefix(key, bucket+"/"), len(f.objects[key]), time.Now().UTC().Format(time.RFC3339))
+	}
+	if end < len(keys) {
+		fmt.Fprintf(&b, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+	} else {
+		b.WriteString("<IsTruncated>false</IsTruncated>")
+	}
+	b.WriteString("</ListBucketResult>")
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// newTestS3Storage returns an S3Storage backed by a fake server, or by a real
+// server when GOATSYNC_TEST_S3_ENDPOINT is set (e.g. a local MinIO).
+func newTestS3Storage(t *testing.T) *S3Storage {
+	t.Helper()
+
+	cfg := S3Config{
+		Endpoint:  os.Getenv("GOATSYNC_TEST_S3_ENDPOINT"),
+		Bucket:    os.Getenv("GOATSYNC_TEST_S3_BUCKET"),
+		AccessKey: os.Getenv("GOATSYNC_TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("GOATSYNC_TEST_S3_SECRET_KEY"),
+		Region:    "us-east-1",
+		Prefix:    "test",
+		PathStyle: true,
+	}
+
+	if cfg.Endpoint == "" {
+		return newFakeS3Storage(t, newFakeS3())
+	}
+
+	s, err := NewS3Storage(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Storage failed: %v", err)
+	}
+	return s
+}
+
+// newFakeS3Storage returns an S3Storage backed by the given fake server
+func newFakeS3Storage(t *testing.T, f *fakeS3) *S3Storage {
+	t.Helper()
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse fake server URL: %v", err)
+	}
+	s, err := NewS3Storage(S3Config{
+		Endpoint:  u.Host,
+		Bucket:    "goatsync",
+		AccessKey: "test",
+		SecretKey: "testtesttest",
+		Region:    "us-east-1",
+		Prefix:    "test",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Storage failed: %v", err)
+	}
+	return s
+}
+
+func TestS3Storage_PutGetDelete(t *testing.T) {
+	s := newTestS3Storage(t)
+	ctx := context.Background()
+
+	key := ChunkKey(1, "test-collection", "test-chunk-12345")
+	data := []byte("Hello, this is test chunk data!")
+
+	if err := s.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	exists, err := s.Exists(ctx, key)
+	if err != nil {
+		t.Fatalf("Exists failed: %v", err)
+	}
+	if !exists {
+		t.Error("Exists returned false after put")
+	}
+
+	reader, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	loaded, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		t.Fatalf("Reading chunk failed: %v", err)
+	}
+	if !bytes.Equal(loaded, data) {
+		t.Errorf("Loaded data doesn't match: got %s, want %s", loaded, data)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	exists, err = s.Exists(ctx, key)
+	if err != nil {
+		t.Fatalf("Exists failed: %v", err)
+	}
+	if exists {
+		t.Error("Exists returned true after delete")
+	}
+}
+
+func TestS3Storage_PutUnknownSize(t *testing.T) {
+	s := newTestS3Storage(t)
+	ctx := context.Background()
+
+	key := ChunkKey(1, "test-collection", "streamed-chunk")
+	data := bytes.Repeat([]byte("x"), 4096)
+
+	// Hide the concrete type so the client can't learn the size
+	if err := s.Put(ctx, key, io.MultiReader(bytes.NewReader(data)), -1); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	defer func() { _ = s.Delete(ctx, key) }()
+
+	reader, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	loaded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Reading chunk failed: %v", err)
+	}
+	if !bytes.Equal(loaded, data) {
+		t.Errorf("Loaded %d bytes, want %d", len(loaded), len(data))
+	}
+}
+
+func TestS3Storage_GetSeek(t *testing.T) {
+	s := newTestS3Storage(t)
+	ctx := context.Background()
+
+	key := ChunkKey(1, "test-collection", "seekable-chunk")
+	if err := s.Put(ctx, key, strings.NewReader("0123456789"), 10); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	defer func() { _ = s.Delete(ctx, key) }()
+
+	obj, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer func() { _ = obj.Close() }()
+
+	size, err := obj.Seek(0, io.SeekEnd)
+	if err != nil || size != 10 {
+		t.Fatalf("Expected size 10, got %d, %v", size, err)
+	}
+
+	// Reading after a seek fetches the rest of the object from there
+	if _, err := obj.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	tail, err := io.ReadAll(obj)
+	if err != nil || string(tail) != "6789" {
+		t.Errorf("Expected %q, got %q, %v", "6789", tail, err)
+	}
+
+	if _, err := obj.Seek(2, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	part := make([]byte, 3)
+	if _, err := io.ReadFull(obj, part); err != nil || string(part) != "234" {
+		t.Errorf("Expected %q, got %q, %v", "234", part, err)
+	}
+}
+
+func TestS3Storage_GetUnknownSize(t *testing.T) {
+	f := newFakeS3()
+	f.chunked = true
+	s := newFakeS3Storage(t, f)
+	ctx := context.Background()
+
+	key := ChunkKey(1, "test-collection", "chunked-chunk")
+	if err := s.Put(ctx, key, strings.NewReader("0123456789"), 10); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	obj, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer func() { _ = obj.Close() }()
+
+	size, err := obj.Seek(0, io.SeekEnd)
+	if err != nil || size != 10 {
+		t.Fatalf("Expected size 10, got %d, %v", size, err)
+	}
+	if _, err := obj.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	data, err := io.ReadAll(obj)
+	if err != nil || string(data) != "0123456789" {
+		t.Errorf("Expected %q, got %q, %v", "0123456789", data, err)
+	}
+}
+
+func TestS3Storage_GetNonExistent(t *testing.T) {
+	s := newTestS3Storage(t)
+	ctx := context.Background()
+
+	_, err := s.Get(ctx, "user_1/col/nonexistent")
+	if !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("Expected ErrChunkNotFound, got %v", err)
+	}
+
+	// Deleting a missing key is not an error
+	if err := s.Delete(ctx, "user_1/col/nonexistent"); err != nil {
+		t.Errorf("Delete of missing key returned error: %v", err)
+	}
+}
+
+func TestS3Storage_ObjectURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      S3Config
+		expected string
+	}{
+		{
+			"path style",
+			S3Config{Endpoint: "minio:9000", Bucket: "chunks", PathStyle: true},
+			"http://minio:9000/chunks/user_1/col/ab/cdef",
+		},
+		{
+			"virtual host with prefix",
+			S3Config{Endpoint: "s3.amazonaws.com", Bucket: "chunks", Prefix: "/goatsync/", UseSSL: true},
+			"https://chunks.s3.amazonaws.com/goatsync/user_1/col/ab/cdef",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewS3Storage(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewS3Storage failed: %v", err)
+			}
+			got := s.objectURL(ChunkKey(1, "col", "abcdef")).String()
+			if got != tt.expected {
+				t.Errorf("objectURL() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestS3Storage_Walk(t *testing.T) {
+	s := newTestS3Storage(t)
+	ctx := context.Background()
+
+	keys := []string{
+		BlobKey("aa11"),
+		BlobKey("bb22"),
+		ChunkKey(1, "col", "legacy-chunk"),
+	}
+	for _, key := range keys {
+		if err := s.Put(ctx, key, strings.NewReader("data"), 4); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		defer func(key string) { _ = s.Delete(ctx, key) }(key)
+	}
+
+	found := make(map[string]int64)
+	err := s.Walk(ctx, func(obj ObjectInfo) error {
+		found[obj.Key] = obj.Size
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+
+	for _, key := range keys {
+		if size, ok := found[key]; !ok || size != 4 {
+			t.Errorf("Walk didn't report %s with size 4 (got %v, %d)", key, ok, size)
+		}
+	}
+}
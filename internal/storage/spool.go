@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Spool is an upload buffered to a temporary file so its content hash is
+// known before it's written to a ChunkStore.
+type Spool struct {
+	file *os.File
+	Hash string // Hex-encoded SHA-256 of the content
+	Size int64  // Content length in bytes
+}
+
+// NewSpool copies r into a temporary file, hashing it on the way.
+// The caller must Close the spool to remove the file.
+func NewSpool(r io.Reader) (*Spool, error) {
+	file, err := os.CreateTemp("", "goatsync-chunk-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hasher), r)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to spool chunk: %w", err)
+	}
+
+	return &Spool{
+		file: file,
+		Hash: hex.EncodeToString(hasher.Sum(nil)),
+		Size: size,
+	}, nil
+}
+
+// Reader returns a reader over the spooled content, starting from the beginning
+func (s *Spool) Reader() (io.Reader, error) {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind spool file: %w", err)
+	}
+	return s.file, nil
+}
+
+// Close closes and removes the spool file
+func (s *Spool) Close() error {
+	_ = s.file.Close()
+	if err := os.Remove(s.file.Name()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove spool file: %w", err)
+	}
+	return nil
+}
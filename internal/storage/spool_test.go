@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	data := []byte("Hello, this is test chunk data!")
+
+	spool, err := NewSpool(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+
+	// sha256 of the test data
+	const expectedHash = "6dbaad3dbce74a50276c7933110b1c671d97298a03d28be57ccf0c531cd4b218"
+	if spool.Hash != expectedHash {
+		t.Errorf("Hash = %s, want %s", spool.Hash, expectedHash)
+	}
+	if spool.Size != int64(len(data)) {
+		t.Errorf("Size = %d, want %d", spool.Size, len(data))
+	}
+
+	// The content can be read more than once
+	for i := 0; i < 2; i++ {
+		r, err := spool.Reader()
+		if err != nil {
+			t.Fatalf("Reader failed: %v", err)
+		}
+		loaded, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Reading spool failed: %v", err)
+		}
+		if !bytes.Equal(loaded, data) {
+			t.Errorf("Spooled data doesn't match: got %s, want %s", loaded, data)
+		}
+	}
+
+	name := spool.file.Name()
+	if err := spool.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Error("Spool file still exists after Close")
+	}
+}
+
+func TestSpool_SameContentSameHash(t *testing.T) {
+	a, err := NewSpool(bytes.NewReader([]byte("same bytes")))
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	b, err := NewSpool(bytes.NewReader([]byte("same bytes")))
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+	defer func() { _ = b.Close() }()
+
+	if a.Hash != b.Hash {
+		t.Errorf("Hashes differ for identical content: %s != %s", a.Hash, b.Hash)
+	}
+	if BlobKey(a.Hash) != "blobs/"+a.Hash[:2]+"/"+a.Hash[2:] {
+		t.Errorf("Unexpected blob key %s", BlobKey(a.Hash))
+	}
+}
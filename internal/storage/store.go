@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"goatsync/internal/config"
+)
+
+// ErrChunkNotFound is returned by a ChunkStore when the requested key doesn't exist
+var ErrChunkNotFound = errors.New("chunk not found")
+
+// ChunkStore is a backend that stores encrypted chunk blobs.
+//
+// Keys are slash-separated paths relative to the root of the store
+// (see ChunkKey). Implementations must be safe for concurrent use so that
+// several GoatSync replicas can share the same backend.
+type ChunkStore interface {
+	// Put stores the content of r under key, replacing any existing blob.
+	// size is the number of bytes in r, or -1 if unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+
+	// Get opens the blob stored under key. The caller must close it.
+	// Seeking is cheap, so ranges can be read without reading the whole blob.
+	// Returns ErrChunkNotFound if the key doesn't exist.
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+
+	// Delete removes the blob stored under key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// Exists reports whether a blob is stored under key
+	Exists(ctx context.Context, key string) (bool, error)
+
+	// Walk calls fn for every blob in the store, in no particular order.
+	// Walking stops at the first error returned by fn.
+	Walk(ctx context.Context, fn func(ObjectInfo) error) error
+}
+
+// ObjectInfo describes a blob found by ChunkStore.Walk
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// ChunkKey returns the store key for a chunk.
+// Format: user_{userID}/{collectionUID}/{uidPrefix}/{uidRest}
+//
+// This matches the chunk_directory_path layout of the Django server so
+// existing chunk directories can be reused as-is.
+func ChunkKey(userID uint, collectionUID, chunkUID string) string {
+	if len(chunkUID) < 2 {
+		return path.Join(fmt.Sprintf("user_%d", userID), collectionUID, chunkUID)
+	}
+	return path.Join(fmt.Sprintf("user_%d", userID), collectionUID, chunkUID[:2], chunkUID[2:])
+}
+
+// BlobKey returns the store key for a content-addressed chunk blob.
+// Format: blobs/{hashPrefix}/{hashRest}
+//
+// Chunks with identical bytes share one blob, whatever user or collection
+// they belong to.
+func BlobKey(hash string) string {
+	if len(hash) < 2 {
+		return path.Join("blobs", hash)
+	}
+	return path.Join("blobs", hash[:2], hash[2:])
+}
+
+// BlobHash returns the content hash of the blob stored under key,
+// or false if key isn't a BlobKey
+func BlobHash(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, "blobs/")
+	if !ok {
+		return "", false
+	}
+	return strings.Replace(rest, "/", "", 1), true
+}
+
+// UploadPrefix is the key prefix of the data of resumable uploads in progress.
+// It's managed by the upload service, not the garbage collector.
+const UploadPrefix = "uploads/"
+
+// UploadKey returns the store key for the data of a resumable upload in progress.
+// Format: uploads/{uploadID}
+func UploadKey(uploadID uint) string {
+	return UploadPrefix + strconv.FormatUint(uint64(uploadID), 10)
+}
+
+// StoredLocations returns the values a chunk row may hold for the blob stored
+// under key: the key itself and, for the filesystem backend, the full path
+// that rows written before the ChunkStore abstraction contain.
+func StoredLocations(store ChunkStore, key string) []string {
+	if fs, ok := store.(*FileStorage); ok {
+		return []string{key, fs.legacyPath(key)}
+	}
+	return []string{key}
+}
+
+// NewChunkStore creates the chunk store selected by cfg.ChunkStorageBackend
+func NewChunkStore(cfg *config.Config) (ChunkStore, error) {
+	switch cfg.ChunkStorageBackend {
+	case "", "filesystem":
+		return NewFileStorage(cfg.ChunkStoragePath), nil
+	case "s3":
+		s3, err := NewS3Storage(S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			Prefix:    cfg.S3Prefix,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			UseSSL:    cfg.S3UseSSL,
+			PathStyle: cfg.S3PathStyle,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	default:
+		return nil, fmt.Errorf("unknown chunk storage backend %q", cfg.ChunkStorageBackend)
+	}
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+// ErrChunkCorrupted is returned when a stored chunk doesn't match the
+// checksum recorded when it was written
+var ErrChunkCorrupted = errors.New("chunk content doesn't match its checksum")
+
+// Verify reads r to the end and checks that its content is size bytes with
+// the hex-encoded SHA-256 checksum want.
+// Returns ErrChunkCorrupted on a mismatch.
+func Verify(r io.Reader, want string, size int64) error {
+	hasher := sha256.New()
+	// Read one byte past size to catch an overlong chunk
+	n, err := io.Copy(hasher, io.LimitReader(r, size+1))
+	if err != nil {
+		return err
+	}
+	if n != size || hex.EncodeToString(hasher.Sum(nil)) != want {
+		return ErrChunkCorrupted
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// SHA-256 of "hello world"
+const helloWorldHash = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		size    int64
+		wantErr error
+	}{
+		{"intact", "hello world", 11, nil},
+		{"truncated", "hello", 11, ErrChunkCorrupted},
+		{"damaged", "hello w0rld", 11, ErrChunkCorrupted},
+		{"too long", "hello world!", 11, ErrChunkCorrupted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(strings.NewReader(tt.content), helloWorldHash, tt.size); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
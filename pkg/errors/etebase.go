@@ -138,6 +138,14 @@ var ErrWrongEtag = &EtebaseError{
 	StatusCode: http.StatusConflict,
 }
 
+// ErrDoesNotExist is returned when an object referenced by the request, such
+// as a pagination iterator, doesn't exist
+var ErrDoesNotExist = &EtebaseError{
+	Code:       "does_not_exist",
+	Detail:     "Not found",
+	StatusCode: http.StatusNotFound,
+}
+
 // ErrUniqueUID is returned when trying to create an item with a duplicate UID
 var ErrUniqueUID = &EtebaseError{
 	Code:       "unique_uid",
@@ -170,8 +178,22 @@ var ErrNotMember = &EtebaseError{
 	StatusCode: http.StatusForbidden,
 }
 
+// ErrLastAdmin is returned when a membership change would leave a collection without an admin
+var ErrLastAdmin = &EtebaseError{
+	Code:       "last_admin",
+	Detail:     "A collection must keep at least one admin",
+	StatusCode: http.StatusForbidden,
+}
+
+// ErrOwnerImmutable is returned when a membership change would demote or remove the collection owner
+var ErrOwnerImmutable = &EtebaseError{
+	Code:       "owner_immutable",
+	Detail:     "The collection owner's membership can't be changed",
+	StatusCode: http.StatusForbidden,
+}
+
 // ============================================================================
-// Chunk Errors (400, 409)
+// Chunk Errors (400, 404, 409, 500)
 // ============================================================================
 
 // ErrChunkExists is returned when trying to upload a chunk that already exists
@@ -188,6 +210,39 @@ var ErrChunkNoContent = &EtebaseError{
 	StatusCode: http.StatusBadRequest,
 }
 
+// ErrChunkCorrupted is returned when a stored chunk no longer matches its checksum
+var ErrChunkCorrupted = &EtebaseError{
+	Code:       "chunk_corrupted",
+	Detail:     "The stored chunk is damaged and can't be served",
+	StatusCode: http.StatusInternalServerError,
+}
+
+// ErrUploadNotFound is returned when a resumable upload doesn't exist or has expired
+var ErrUploadNotFound = &EtebaseError{
+	Code:       "upload_not_found",
+	Detail:     "Upload not found or expired",
+	StatusCode: http.StatusNotFound,
+}
+
+// ErrUploadOffsetMismatch is returned when data is sent for a resumable upload
+// at an offset other than the number of bytes received so far
+var ErrUploadOffsetMismatch = &EtebaseError{
+	Code:       "upload_offset_mismatch",
+	Detail:     "Upload offset doesn't match the data received so far",
+	StatusCode: http.StatusConflict,
+}
+
+// ============================================================================
+// Quota Errors (403)
+// ============================================================================
+
+// ErrQuotaExceeded is returned when a write would take a user past one of their quotas
+var ErrQuotaExceeded = &EtebaseError{
+	Code:       "quota_exceeded",
+	Detail:     "Quota exceeded",
+	StatusCode: http.StatusForbidden,
+}
+
 // ============================================================================
 // Invitation Errors (400, 409)
 // ============================================================================
@@ -132,8 +132,11 @@ func TestErrorStatusCodes(t *testing.T) {
 		{"ErrUserExists", ErrUserExists, http.StatusConflict},
 		{"ErrBadStoken", ErrBadStoken, http.StatusBadRequest},
 		{"ErrWrongEtag", ErrWrongEtag, http.StatusConflict},
+		{"ErrDoesNotExist", ErrDoesNotExist, http.StatusNotFound},
 		{"ErrAdminRequired", ErrAdminRequired, http.StatusForbidden},
 		{"ErrNotMember", ErrNotMember, http.StatusForbidden},
+		{"ErrLastAdmin", ErrLastAdmin, http.StatusForbidden},
+		{"ErrOwnerImmutable", ErrOwnerImmutable, http.StatusForbidden},
 		{"ErrNotSupported", ErrNotSupported, http.StatusNotImplemented},
 	}
 